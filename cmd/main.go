@@ -9,18 +9,54 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/sgreben/httpfileserver"
 )
 
 const (
-	addrEnvVarName           = "ADDR"
-	allowUploadsEnvVarName   = "UPLOADS"
-	defaultAddr              = ":8080"
-	portEnvVarName           = "PORT"
-	quietEnvVarName          = "QUIET"
-	sslCertificateEnvVarName = "SSL_CERTIFICATE"
-	sslKeyEnvVarName         = "SSL_KEY"
+	addrEnvVarName                    = "ADDR"
+	allowUploadsEnvVarName            = "UPLOADS"
+	archiveCacheDirEnvVarName         = "ARCHIVE_CACHE"
+	archiveCacheSizeEnvVarName        = "ARCHIVE_CACHE_SIZE"
+	archiveChecksumsEnvVarName        = "ARCHIVE_CHECKSUMS"
+	archiveConcurrencyEnvVarName      = "ARCHIVE_CONCURRENCY"
+	archiveFormatsEnvVarName          = "ARCHIVE_FORMATS"
+	archiveGzipWorkersEnvVarName      = "ARCHIVE_GZIP_WORKERS"
+	archiveLevelEnvVarName            = "ARCHIVE_LEVEL"
+	archiveMaxDepthEnvVarName         = "ARCHIVE_MAX_DEPTH"
+	archiveMaxFilesEnvVarName         = "ARCHIVE_MAX_FILES"
+	archiveMaxSizeEnvVarName          = "ARCHIVE_MAX_SIZE"
+	archiveQueueEnvVarName            = "ARCHIVE_QUEUE"
+	archiveQueueTimeoutEnvVarName     = "ARCHIVE_QUEUE_TIMEOUT"
+	archiveReproducibleEnvVarName     = "ARCHIVE_REPRODUCIBLE"
+	archiveRouteConcurrencyEnvVarName = "ARCHIVE_ROUTE_CONCURRENCY"
+	archiveSkipErrorsEnvVarName       = "ARCHIVE_SKIP_ERRORS"
+	archiveSymlinksEnvVarName         = "ARCHIVE_SYMLINKS"
+	browseArchivesEnvVarName          = "BROWSE_ARCHIVES"
+	defaultAddr                       = ":8080"
+	manageAuthEnvVarName              = "MANAGE_AUTH"
+	manageEnvVarName                  = "MANAGE"
+	metricsRouteEnvVarName            = "METRICS_ROUTE"
+	portEnvVarName                    = "PORT"
+	quietEnvVarName                   = "QUIET"
+	sourceDateEpochEnvVarName         = "SOURCE_DATE_EPOCH"
+	sslCertificateEnvVarName          = "SSL_CERTIFICATE"
+	sslKeyEnvVarName                  = "SSL_KEY"
+	uploadAllowExtEnvVarName          = "UPLOAD_ALLOW_EXT"
+	uploadAllowTypeEnvVarName         = "UPLOAD_ALLOW_TYPE"
+	uploadConflictEnvVarName          = "UPLOAD_CONFLICT"
+	uploadCreateDirsEnvVarName        = "UPLOAD_CREATE_DIRS"
+	uploadDenyDotfilesEnvVarName      = "UPLOAD_DENY_DOTFILES"
+	uploadDenyExtEnvVarName           = "UPLOAD_DENY_EXT"
+	uploadDenyTypeEnvVarName          = "UPLOAD_DENY_TYPE"
+	uploadMaxFileSizeEnvVarName       = "UPLOAD_MAX_FILE_SIZE"
+	uploadMaxNameLengthEnvVarName     = "UPLOAD_MAX_NAME_LENGTH"
+	uploadMaxRequestSizeEnvVarName    = "UPLOAD_MAX_REQUEST_SIZE"
+	uploadNamesEnvVarName             = "UPLOAD_NAMES"
+	uploadResumableDirEnvVarName      = "UPLOAD_RESUMABLE_DIR"
+	uploadResumableExpiryEnvVarName   = "UPLOAD_RESUMABLE_EXPIRY"
+	webDAVEnvVarName                  = "WEBDAV"
 )
 
 var version = ":unknown:"
@@ -72,9 +108,48 @@ func configureRuntime(cfg httpfileserver.Config) httpfileserver.Config {
 	flag.BoolVar(&cfg.AllowUploadsFlag, "u", cfg.AllowUploadsFlag, "(alias for -uploads)")
 	flag.Var(&cfg.Routes, "route", cfg.Routes.Help())
 	flag.Var(&cfg.Routes, "r", "(alias for -route)")
+	flag.Var(&cfg.RouteOptions, "route-option", cfg.RouteOptions.Help())
 	flag.StringVar(&cfg.SslCertificate, "ssl-cert", cfg.SslCertificate, fmt.Sprintf("path to SSL server certificate (environment variable %q)", sslCertificateEnvVarName))
 	flag.StringVar(&cfg.SslKey, "ssl-key", cfg.SslKey, fmt.Sprintf("path to SSL private key (environment variable %q)", sslKeyEnvVarName))
+	flag.IntVar(&cfg.ArchiveConcurrency, "archive-concurrency", cfg.ArchiveConcurrency, fmt.Sprintf("maximum number of archives generated at once across all routes, 0 for no limit (environment variable %q)", archiveConcurrencyEnvVarName))
+	flag.IntVar(&cfg.ArchiveRouteConcurrency, "archive-route-concurrency", cfg.ArchiveRouteConcurrency, fmt.Sprintf("maximum number of archives generated at once per route, 0 for no limit (environment variable %q)", archiveRouteConcurrencyEnvVarName))
+	flag.IntVar(&cfg.ArchiveQueue, "archive-queue", cfg.ArchiveQueue, fmt.Sprintf("number of archive requests that may wait for a free slot before 503 responses are sent (environment variable %q)", archiveQueueEnvVarName))
+	flag.DurationVar(&cfg.ArchiveQueueTimeout, "archive-queue-timeout", cfg.ArchiveQueueTimeout, fmt.Sprintf("how long an archive request may wait for a free slot (environment variable %q)", archiveQueueTimeoutEnvVarName))
+	flag.BoolVar(&cfg.ArchiveSkipErrors, "archive-skip-errors", cfg.ArchiveSkipErrors, fmt.Sprintf("leave unreadable files out of archives and list them in ERRORS.txt instead of failing the download (environment variable %q)", archiveSkipErrorsEnvVarName))
+	flag.Var(&cfg.ArchiveSymlinks, "archive-symlinks", fmt.Sprintf("how archives treat symbolic links: follow (default), store or skip (environment variable %q)", archiveSymlinksEnvVarName))
+	flag.Var(&cfg.ArchiveFormats, "archive-formats", fmt.Sprintf("comma-separated archive formats offered for download: tar.gz, tar, zip, zip-store (environment variable %q)", archiveFormatsEnvVarName))
+	flag.IntVar(&cfg.ArchiveLevel, "archive-level", cfg.ArchiveLevel, fmt.Sprintf("default archive compression level from 1 (fastest) to 9 (smallest), 0 for the compressor's default (environment variable %q)", archiveLevelEnvVarName))
+	flag.IntVar(&cfg.ArchiveGzipWorkers, "archive-gzip-workers", cfg.ArchiveGzipWorkers, fmt.Sprintf("number of goroutines compressing each .tar.gz archive in parallel blocks, 1 for a single stream (environment variable %q)", archiveGzipWorkersEnvVarName))
+	flag.StringVar(&cfg.ArchiveCacheDir, "archive-cache", cfg.ArchiveCacheDir, fmt.Sprintf("directory to cache generated archives in for resumable downloads, disabled if empty (environment variable %q)", archiveCacheDirEnvVarName))
+	flag.Var(&cfg.ArchiveCacheSize, "archive-cache-size", fmt.Sprintf("maximum size of the archive cache, e.g. 500M or 20G (environment variable %q)", archiveCacheSizeEnvVarName))
+	flag.BoolVar(&cfg.ArchiveReproducible, "archive-reproducible", cfg.ArchiveReproducible, fmt.Sprintf("generate byte-for-byte identical archives of unchanged trees: fixed ownership, UTC times and a stable gzip header (environment variable %q)", archiveReproducibleEnvVarName))
+	flag.Int64Var(&cfg.SourceDateEpoch, "source-date-epoch", cfg.SourceDateEpoch, fmt.Sprintf("clamp modification times in archives to this Unix time, disabled if 0 (environment variable %q)", sourceDateEpochEnvVarName))
+	flag.BoolVar(&cfg.ArchiveChecksums, "archive-checksums", cfg.ArchiveChecksums, fmt.Sprintf("add a SHA256SUMS entry with the digest of every file to archives (environment variable %q)", archiveChecksumsEnvVarName))
+	flag.Var(&cfg.ArchiveMaxSize, "archive-max-size", fmt.Sprintf("refuse archives of more than this many bytes of files before compression, e.g. 10G, 0 for no limit (environment variable %q)", archiveMaxSizeEnvVarName))
+	flag.IntVar(&cfg.ArchiveMaxFiles, "archive-max-files", cfg.ArchiveMaxFiles, fmt.Sprintf("refuse archives of more than this many files, 0 for no limit (environment variable %q)", archiveMaxFilesEnvVarName))
+	flag.IntVar(&cfg.ArchiveMaxDepth, "archive-max-depth", cfg.ArchiveMaxDepth, fmt.Sprintf("refuse archives of trees nested more than this many levels deep, 0 for no limit (environment variable %q)", archiveMaxDepthEnvVarName))
+	flag.BoolVar(&cfg.BrowseArchives, "browse-archives", cfg.BrowseArchives, fmt.Sprintf("list zip, tar and tar.gz files as directories at URLs ending in a slash, e.g. /files/release.zip/ (environment variable %q)", browseArchivesEnvVarName))
+	flag.Var(&cfg.UploadMaxFileSize, "upload-max-file-size", fmt.Sprintf("refuse uploaded files larger than this, e.g. 2G, 0 for no limit (environment variable %q)", uploadMaxFileSizeEnvVarName))
+	flag.Var(&cfg.UploadMaxRequestSize, "upload-max-request-size", fmt.Sprintf("refuse upload requests larger than this, e.g. 10G, 0 for no limit (environment variable %q)", uploadMaxRequestSizeEnvVarName))
+	flag.Var(&cfg.UploadConflict, "upload-conflict", fmt.Sprintf("what happens to uploads whose name is taken: overwrite (default), reject with 409 or rename to \"name (1).ext\" (environment variable %q)", uploadConflictEnvVarName))
+	flag.BoolVar(&cfg.UploadCreateDirs, "upload-create-dirs", cfg.UploadCreateDirs, fmt.Sprintf("let PUT uploads create missing parent directories (environment variable %q)", uploadCreateDirsEnvVarName))
+	flag.Var(&cfg.UploadAllowExt, "upload-allow-ext", fmt.Sprintf("comma-separated file extensions uploads are limited to, e.g. .pdf,.tar.gz (environment variable %q)", uploadAllowExtEnvVarName))
+	flag.Var(&cfg.UploadDenyExt, "upload-deny-ext", fmt.Sprintf("comma-separated file extensions refused for uploads, e.g. .exe,.php (environment variable %q)", uploadDenyExtEnvVarName))
+	flag.Var(&cfg.UploadAllowType, "upload-allow-type", fmt.Sprintf("comma-separated media types, sniffed from the content, uploads are limited to, e.g. image/*,application/pdf (environment variable %q)", uploadAllowTypeEnvVarName))
+	flag.Var(&cfg.UploadDenyType, "upload-deny-type", fmt.Sprintf("comma-separated media types, sniffed from the content, refused for uploads, e.g. text/html (environment variable %q)", uploadDenyTypeEnvVarName))
+	flag.Var(&cfg.UploadNames, "upload-names", fmt.Sprintf("what happens to uploads with unsafe names (control characters, reserved device names, names not in Unicode NFC...): keep (default), reject with 400 or sanitize (environment variable %q)", uploadNamesEnvVarName))
+	flag.IntVar(&cfg.UploadMaxNameLength, "upload-max-name-length", cfg.UploadMaxNameLength, fmt.Sprintf("refuse uploads with file or directory names longer than this many bytes, 0 for no limit (environment variable %q)", uploadMaxNameLengthEnvVarName))
+	flag.BoolVar(&cfg.UploadDenyDotfiles, "upload-deny-dotfiles", cfg.UploadDenyDotfiles, fmt.Sprintf("refuse uploads of files or directories whose names start with a dot (environment variable %q)", uploadDenyDotfilesEnvVarName))
+	flag.StringVar(&cfg.UploadResumableDir, "upload-resumable-dir", cfg.UploadResumableDir, fmt.Sprintf("directory to stage resumable (tus) uploads in, disabled if empty (environment variable %q)", uploadResumableDirEnvVarName))
+	flag.DurationVar(&cfg.UploadResumableExpiry, "upload-resumable-expiry", cfg.UploadResumableExpiry, fmt.Sprintf("how long an unfinished resumable upload is kept after its last data arrived (environment variable %q)", uploadResumableExpiryEnvVarName))
+	flag.BoolVar(&cfg.Manage, "manage", cfg.Manage, fmt.Sprintf("let clients authenticated with -manage-auth create directories, and delete and move files (environment variable %q)", manageEnvVarName))
+	flag.Var(&cfg.ManageAuth, "manage-auth", fmt.Sprintf("USER:PASSWORD for HTTP basic authentication of management requests (environment variable %q)", manageAuthEnvVarName))
+	flag.BoolVar(&cfg.WebDAV, "webdav", cfg.WebDAV, fmt.Sprintf("serve routes to WebDAV clients, writable as far as -uploads and -manage allow (environment variable %q)", webDAVEnvVarName))
+	flag.StringVar(&cfg.MetricsRoute, "metrics", cfg.MetricsRoute, fmt.Sprintf("route to serve expvar metrics on, disabled if empty (environment variable %q)", metricsRouteEnvVarName))
 	flag.Parse()
+	if cfg.ArchiveLevel < 0 || cfg.ArchiveLevel > 9 {
+		log.Fatalf("archive level %d: want 0 to 9", cfg.ArchiveLevel)
+	}
 	if quietFlag {
 		log.SetOutput(ioutil.Discard)
 	}
@@ -85,6 +160,9 @@ func configureRuntime(cfg httpfileserver.Config) httpfileserver.Config {
 			log.Fatalf("%q: %v", arg, err)
 		}
 	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
 	return cfg
 }
@@ -98,6 +176,101 @@ func newConfig() httpfileserver.Config {
 	cfg.SslCertificate = os.Getenv(sslCertificateEnvVarName)
 	cfg.SslKey = os.Getenv(sslKeyEnvVarName)
 	cfg.RootRoute = "/"
+	if v, err := strconv.Atoi(os.Getenv(archiveConcurrencyEnvVarName)); err == nil {
+		cfg.ArchiveConcurrency = v
+	}
+	if v, err := strconv.Atoi(os.Getenv(archiveRouteConcurrencyEnvVarName)); err == nil {
+		cfg.ArchiveRouteConcurrency = v
+	}
+	if v, err := strconv.Atoi(os.Getenv(archiveQueueEnvVarName)); err == nil {
+		cfg.ArchiveQueue = v
+	}
+	if v, err := time.ParseDuration(os.Getenv(archiveQueueTimeoutEnvVarName)); err == nil {
+		cfg.ArchiveQueueTimeout = v
+	}
+	cfg.ArchiveSkipErrors = os.Getenv(archiveSkipErrorsEnvVarName) == "true"
+	if v := os.Getenv(archiveSymlinksEnvVarName); v != "" {
+		if err := cfg.ArchiveSymlinks.Set(v); err != nil {
+			log.Fatalf("%s: %v", archiveSymlinksEnvVarName, err)
+		}
+	}
+	if v, ok := os.LookupEnv(archiveFormatsEnvVarName); ok {
+		if err := cfg.ArchiveFormats.Set(v); err != nil {
+			log.Fatalf("%s: %v", archiveFormatsEnvVarName, err)
+		}
+	}
+	if v, err := strconv.Atoi(os.Getenv(archiveLevelEnvVarName)); err == nil {
+		cfg.ArchiveLevel = v
+	}
+	if v, err := strconv.Atoi(os.Getenv(archiveGzipWorkersEnvVarName)); err == nil {
+		cfg.ArchiveGzipWorkers = v
+	}
+	cfg.ArchiveCacheDir = os.Getenv(archiveCacheDirEnvVarName)
+	if v := os.Getenv(archiveCacheSizeEnvVarName); v != "" {
+		if err := cfg.ArchiveCacheSize.Set(v); err != nil {
+			log.Fatalf("%s: %v", archiveCacheSizeEnvVarName, err)
+		}
+	}
+	cfg.ArchiveReproducible = os.Getenv(archiveReproducibleEnvVarName) == "true"
+	if v, err := strconv.ParseInt(os.Getenv(sourceDateEpochEnvVarName), 10, 64); err == nil {
+		cfg.SourceDateEpoch = v
+	}
+	cfg.ArchiveChecksums = os.Getenv(archiveChecksumsEnvVarName) == "true"
+	if v := os.Getenv(archiveMaxSizeEnvVarName); v != "" {
+		if err := cfg.ArchiveMaxSize.Set(v); err != nil {
+			log.Fatalf("%s: %v", archiveMaxSizeEnvVarName, err)
+		}
+	}
+	if v, err := strconv.Atoi(os.Getenv(archiveMaxFilesEnvVarName)); err == nil {
+		cfg.ArchiveMaxFiles = v
+	}
+	if v, err := strconv.Atoi(os.Getenv(archiveMaxDepthEnvVarName)); err == nil {
+		cfg.ArchiveMaxDepth = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv(browseArchivesEnvVarName)); err == nil {
+		cfg.BrowseArchives = v
+	}
+	if v := os.Getenv(uploadMaxFileSizeEnvVarName); v != "" {
+		if err := cfg.UploadMaxFileSize.Set(v); err != nil {
+			log.Fatalf("%s: %v", uploadMaxFileSizeEnvVarName, err)
+		}
+	}
+	if v := os.Getenv(uploadMaxRequestSizeEnvVarName); v != "" {
+		if err := cfg.UploadMaxRequestSize.Set(v); err != nil {
+			log.Fatalf("%s: %v", uploadMaxRequestSizeEnvVarName, err)
+		}
+	}
+	if v := os.Getenv(uploadConflictEnvVarName); v != "" {
+		if err := cfg.UploadConflict.Set(v); err != nil {
+			log.Fatalf("%s: %v", uploadConflictEnvVarName, err)
+		}
+	}
+	cfg.UploadCreateDirs = os.Getenv(uploadCreateDirsEnvVarName) == "true"
+	_ = cfg.UploadAllowExt.Set(os.Getenv(uploadAllowExtEnvVarName))
+	_ = cfg.UploadDenyExt.Set(os.Getenv(uploadDenyExtEnvVarName))
+	_ = cfg.UploadAllowType.Set(os.Getenv(uploadAllowTypeEnvVarName))
+	_ = cfg.UploadDenyType.Set(os.Getenv(uploadDenyTypeEnvVarName))
+	if v := os.Getenv(uploadNamesEnvVarName); v != "" {
+		if err := cfg.UploadNames.Set(v); err != nil {
+			log.Fatalf("%s: %v", uploadNamesEnvVarName, err)
+		}
+	}
+	if v, err := strconv.Atoi(os.Getenv(uploadMaxNameLengthEnvVarName)); err == nil {
+		cfg.UploadMaxNameLength = v
+	}
+	cfg.UploadDenyDotfiles = os.Getenv(uploadDenyDotfilesEnvVarName) == "true"
+	cfg.UploadResumableDir = os.Getenv(uploadResumableDirEnvVarName)
+	if v, err := time.ParseDuration(os.Getenv(uploadResumableExpiryEnvVarName)); err == nil {
+		cfg.UploadResumableExpiry = v
+	}
+	cfg.Manage = os.Getenv(manageEnvVarName) == "true"
+	if v := os.Getenv(manageAuthEnvVarName); v != "" {
+		if err := cfg.ManageAuth.Set(v); err != nil {
+			log.Fatalf("%s: %v", manageAuthEnvVarName, err)
+		}
+	}
+	cfg.WebDAV = os.Getenv(webDAVEnvVarName) == "true"
+	cfg.MetricsRoute = os.Getenv(metricsRouteEnvVarName)
 
 	return cfg
 }
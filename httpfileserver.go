@@ -2,13 +2,25 @@ package httpfileserver
 
 import (
 	"context"
+	"expvar"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
 
+	"github.com/sgreben/httpfileserver/internal/archive"
+	"github.com/sgreben/httpfileserver/internal/archivecache"
+	"github.com/sgreben/httpfileserver/internal/archivefs"
+	"github.com/sgreben/httpfileserver/internal/bytesize"
 	"github.com/sgreben/httpfileserver/internal/filehandler"
+	"github.com/sgreben/httpfileserver/internal/limiter"
 	"github.com/sgreben/httpfileserver/internal/routes"
+	"github.com/sgreben/httpfileserver/internal/tus"
+	"github.com/sgreben/httpfileserver/internal/webdav"
 )
 
 type Config struct {
@@ -18,8 +30,49 @@ type Config struct {
 	SslCertificate   string
 	SslKey           string
 	Routes           routes.Routes
+	RouteOptions     routes.Options
+
+	ArchiveConcurrency      int
+	ArchiveRouteConcurrency int
+	ArchiveQueue            int
+	ArchiveQueueTimeout     time.Duration
+	ArchiveSkipErrors       bool
+	ArchiveSymlinks         archive.SymlinkPolicy
+	ArchiveFormats          filehandler.ArchiveFormats
+	ArchiveLevel            int
+	ArchiveGzipWorkers      int
+	ArchiveCacheDir         string
+	ArchiveCacheSize        bytesize.Size
+	ArchiveReproducible     bool
+	SourceDateEpoch         int64
+	ArchiveChecksums        bool
+	ArchiveMaxSize          bytesize.Size
+	ArchiveMaxFiles         int
+	ArchiveMaxDepth         int
+	BrowseArchives          bool
+	UploadMaxFileSize       bytesize.Size
+	UploadMaxRequestSize    bytesize.Size
+	UploadConflict          filehandler.ConflictPolicy
+	UploadCreateDirs        bool
+	UploadAllowExt          filehandler.List
+	UploadDenyExt           filehandler.List
+	UploadAllowType         filehandler.List
+	UploadDenyType          filehandler.List
+	UploadNames             filehandler.NamePolicy
+	UploadMaxNameLength     int
+	UploadDenyDotfiles      bool
+	UploadResumableDir      string
+	UploadResumableExpiry   time.Duration
+	Manage                  bool
+	ManageAuth              filehandler.Credentials
+	WebDAV                  bool
+	MetricsRoute            string
 }
 
+// archiveIndexCacheEntries is the number of entries of browsed archives
+// whose indexes are kept in memory.
+const archiveIndexCacheEntries = 1 << 18
+
 type routeEntry interface {
 	http.Handler
 	GetRoute() string
@@ -36,6 +89,42 @@ func NewConfig() Config {
 		RootRoute:        "/",
 		SslCertificate:   "",
 		SslKey:           "",
+
+		ArchiveConcurrency:      runtime.NumCPU(),
+		ArchiveRouteConcurrency: 0,
+		ArchiveQueue:            runtime.NumCPU(),
+		ArchiveQueueTimeout:     30 * time.Second,
+		ArchiveSkipErrors:       false,
+		ArchiveSymlinks:         archive.SymlinksFollow,
+		ArchiveFormats:          filehandler.DefaultArchiveFormats,
+		ArchiveLevel:            0,
+		ArchiveGzipWorkers:      1,
+		ArchiveCacheDir:         "",
+		ArchiveCacheSize:        10 * bytesize.GB,
+		ArchiveReproducible:     false,
+		SourceDateEpoch:         0,
+		ArchiveChecksums:        false,
+		ArchiveMaxSize:          0,
+		ArchiveMaxFiles:         0,
+		ArchiveMaxDepth:         0,
+		BrowseArchives:          true,
+		UploadMaxFileSize:       0,
+		UploadMaxRequestSize:    0,
+		UploadConflict:          filehandler.ConflictOverwrite,
+		UploadCreateDirs:        false,
+		UploadAllowExt:          nil,
+		UploadDenyExt:           nil,
+		UploadAllowType:         nil,
+		UploadDenyType:          nil,
+		UploadNames:             filehandler.NamesKeep,
+		UploadMaxNameLength:     0,
+		UploadDenyDotfiles:      false,
+		UploadResumableDir:      "",
+		UploadResumableExpiry:   24 * time.Hour,
+		Manage:                  false,
+		ManageAuth:              filehandler.Credentials{},
+		WebDAV:                  false,
+		MetricsRoute:            "",
 	}
 }
 
@@ -50,22 +139,219 @@ func getExeName() string {
 	return exeName
 }
 
-func loadRouteHandlers(cfg *Config) map[string]routeEntry {
+func loadArchiveCache(cfg *Config) *archivecache.Cache {
+	if cfg.ArchiveCacheDir == "" {
+		return nil
+	}
+	cache, err := archivecache.New(cfg.ArchiveCacheDir, int64(cfg.ArchiveCacheSize))
+	if err != nil {
+		log.Printf("archive cache disabled: %v", err)
+		return nil
+	}
+	log.Printf("caching up to %s of archives in %q", cfg.ArchiveCacheSize, cfg.ArchiveCacheDir)
+	return cache
+}
+
+func loadUploadStore(cfg *Config) *tus.Store {
+	if cfg.UploadResumableDir == "" || !cfg.AllowUploadsFlag {
+		return nil
+	}
+	store, err := tus.NewStore(cfg.UploadResumableDir, cfg.UploadResumableExpiry)
+	if err != nil {
+		log.Printf("resumable uploads disabled: %v", err)
+		return nil
+	}
+	log.Printf("staging resumable uploads in %q for up to %s", cfg.UploadResumableDir, cfg.UploadResumableExpiry)
+	return store
+}
+
+// routeSettings are the settings of a route that -route-option can
+// override.
+type routeSettings struct {
+	archiveLimits    archive.Limits
+	uploadLimits     filehandler.UploadLimits
+	uploadConflict   filehandler.ConflictPolicy
+	uploadCreateDirs bool
+	uploadPolicy     filehandler.UploadPolicy
+	manage           bool
+	manageAuth       filehandler.Credentials
+	webDAV           bool
+}
+
+// parseSize parses a size such as "512" or "2G" into *n.
+func parseSize(n *int64, value string) error {
+	size, err := bytesize.Parse(value)
+	*n = int64(size)
+	return err
+}
+
+// loadRouteSettings returns the settings of route: the global settings,
+// overridden by the route's -route-option settings.
+func loadRouteSettings(cfg *Config, route string) (routeSettings, error) {
+	s := routeSettings{
+		archiveLimits: archive.Limits{
+			Bytes: int64(cfg.ArchiveMaxSize),
+			Files: cfg.ArchiveMaxFiles,
+			Depth: cfg.ArchiveMaxDepth,
+		},
+		uploadLimits: filehandler.UploadLimits{
+			FileSize:    int64(cfg.UploadMaxFileSize),
+			RequestSize: int64(cfg.UploadMaxRequestSize),
+		},
+		uploadConflict:   cfg.UploadConflict,
+		uploadCreateDirs: cfg.UploadCreateDirs,
+		uploadPolicy: filehandler.UploadPolicy{
+			AllowExtensions: cfg.UploadAllowExt,
+			DenyExtensions:  cfg.UploadDenyExt,
+			AllowTypes:      cfg.UploadAllowType,
+			DenyTypes:       cfg.UploadDenyType,
+			Names:           cfg.UploadNames,
+			MaxNameLength:   cfg.UploadMaxNameLength,
+			DenyDotfiles:    cfg.UploadDenyDotfiles,
+		},
+		manage:     cfg.Manage,
+		manageAuth: cfg.ManageAuth,
+		webDAV:     cfg.WebDAV,
+	}
+	for key, value := range cfg.RouteOptions.Values[route] {
+		var err error
+		switch key {
+		case "archive-max-size":
+			err = parseSize(&s.archiveLimits.Bytes, value)
+		case "archive-max-files":
+			s.archiveLimits.Files, err = strconv.Atoi(value)
+		case "archive-max-depth":
+			s.archiveLimits.Depth, err = strconv.Atoi(value)
+		case "upload-max-file-size":
+			err = parseSize(&s.uploadLimits.FileSize, value)
+		case "upload-max-request-size":
+			err = parseSize(&s.uploadLimits.RequestSize, value)
+		case "upload-conflict":
+			err = s.uploadConflict.Set(value)
+		case "upload-create-dirs":
+			s.uploadCreateDirs, err = strconv.ParseBool(value)
+		case "upload-allow-ext":
+			err = s.uploadPolicy.AllowExtensions.Set(value)
+		case "upload-deny-ext":
+			err = s.uploadPolicy.DenyExtensions.Set(value)
+		case "upload-allow-type":
+			err = s.uploadPolicy.AllowTypes.Set(value)
+		case "upload-deny-type":
+			err = s.uploadPolicy.DenyTypes.Set(value)
+		case "upload-names":
+			err = s.uploadPolicy.Names.Set(value)
+		case "upload-max-name-length":
+			s.uploadPolicy.MaxNameLength, err = strconv.Atoi(value)
+		case "upload-deny-dotfiles":
+			s.uploadPolicy.DenyDotfiles, err = strconv.ParseBool(value)
+		case "manage":
+			s.manage, err = strconv.ParseBool(value)
+		case "manage-auth":
+			err = s.manageAuth.Set(value)
+		case "webdav":
+			s.webDAV, err = strconv.ParseBool(value)
+		default:
+			return s, fmt.Errorf("route %q: unknown option %q", route, key)
+		}
+		if err != nil {
+			return s, fmt.Errorf("route %q: option %q: %v", route, key, err)
+		}
+	}
+	if err := (archive.Options{Limits: s.archiveLimits}).Validate(); err != nil {
+		return s, fmt.Errorf("route %q: %v", route, err)
+	}
+	if s.manage && s.manageAuth.User == "" {
+		return s, fmt.Errorf("route %q: management needs credentials (-manage-auth)", route)
+	}
+	return s, nil
+}
+
+// Validate checks the limits and the per-route options of the configured
+// routes.
+func (cfg Config) Validate() error {
+	registered := make(map[string]bool)
+	configured := cfg.Routes
+	if len(configured.Values) == 0 {
+		_ = configured.Set(".")
+	}
+	for _, route := range configured.Values {
+		registered[route.Route] = true
+		if _, err := loadRouteSettings(&cfg, route.Route); err != nil {
+			return err
+		}
+	}
+	for route := range cfg.RouteOptions.Values {
+		if !registered[route] {
+			return fmt.Errorf("option for unknown route %q", route)
+		}
+	}
+	return nil
+}
+
+func loadRouteHandlers(cfg *Config) (map[string]routeEntry, error) {
 	handlers := make(map[string]routeEntry)
 
 	if len(cfg.Routes.Values) == 0 {
 		_ = cfg.Routes.Set(".")
 	}
 
+	archiveLimiter := limiter.New("global", cfg.ArchiveConcurrency, cfg.ArchiveQueue, cfg.ArchiveQueueTimeout)
+	archiveCache := loadArchiveCache(cfg)
+	uploadStore := loadUploadStore(cfg)
+	webDAVLocks := webdav.NewLocks()
+	var archiveIndexCache *archivefs.Cache
+	if cfg.BrowseArchives {
+		archiveIndexCache = archivefs.NewCache(archiveIndexCacheEntries)
+	}
+	var sourceDateEpoch time.Time
+	if cfg.SourceDateEpoch > 0 {
+		sourceDateEpoch = time.Unix(cfg.SourceDateEpoch, 0).UTC()
+	}
 	for _, route := range cfg.Routes.Values {
+		settings, err := loadRouteSettings(cfg, route.Route)
+		if err != nil {
+			return nil, err
+		}
 		handlers[route.Route] = filehandler.NewFileHandler(
 			route.Route,
 			route.Path,
 			cfg.AllowUploadsFlag,
+			filehandler.Options{
+				ArchiveLimiter:      archiveLimiter,
+				RouteArchiveLimiter: limiter.New(route.Route, cfg.ArchiveRouteConcurrency, cfg.ArchiveQueue, cfg.ArchiveQueueTimeout),
+				ArchiveRetryAfter:   cfg.ArchiveQueueTimeout,
+				ArchiveSkipErrors:   cfg.ArchiveSkipErrors,
+				ArchiveSymlinks:     cfg.ArchiveSymlinks,
+				ArchiveFormats:      cfg.ArchiveFormats,
+				ArchiveLevel:        cfg.ArchiveLevel,
+				ArchiveCache:        archiveCache,
+
+				ArchiveReproducible:    cfg.ArchiveReproducible,
+				ArchiveSourceDateEpoch: sourceDateEpoch,
+				ArchiveChecksums:       cfg.ArchiveChecksums,
+				ArchiveLimits:          settings.archiveLimits,
+				ArchiveGzipWorkers:     cfg.ArchiveGzipWorkers,
+
+				BrowseArchives:    cfg.BrowseArchives,
+				ArchiveIndexCache: archiveIndexCache,
+
+				UploadLimits:   settings.uploadLimits,
+				UploadConflict: settings.uploadConflict,
+
+				UploadCreateDirs: settings.uploadCreateDirs,
+				UploadPolicy:     settings.uploadPolicy,
+				UploadStore:      uploadStore,
+
+				Manage:     settings.manage,
+				ManageAuth: settings.manageAuth,
+
+				WebDAV:      settings.webDAV,
+				WebDAVLocks: webDAVLocks,
+			},
 		)
 	}
 
-	return handlers
+	return handlers, nil
 }
 
 func addMuxRoutes(mux *http.ServeMux, handlers map[string]routeEntry) {
@@ -89,16 +375,54 @@ func redirectRootRoute(cfg Config, mux *http.ServeMux, handlers map[string]route
 	}
 }
 
-func getMux(cfg Config) *http.ServeMux {
-	handlers := loadRouteHandlers(&cfg)
+// metricsVars are the expvar variables served on the metrics route. Those
+// published by the expvar package itself are left out: cmdline would show
+// credentials given as flags to everyone.
+var metricsVars = []string{"archives"}
+
+// serveMetrics serves metricsVars as a JSON object, like expvar.Handler.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, "{\n")
+	sep := ""
+	for _, name := range metricsVars {
+		if v := expvar.Get(name); v != nil {
+			fmt.Fprintf(w, "%s%q: %s", sep, name, v)
+			sep = ",\n"
+		}
+	}
+	fmt.Fprint(w, "\n}\n")
+}
+
+func addMetricsRoute(cfg Config, mux *http.ServeMux) {
+	if cfg.MetricsRoute == "" {
+		return
+	}
+	mux.HandleFunc(cfg.MetricsRoute, serveMetrics)
+	log.Printf("serving metrics on %q", cfg.MetricsRoute)
+}
+
+func getMux(cfg Config) (*http.ServeMux, error) {
+	handlers, err := loadRouteHandlers(&cfg)
+	if err != nil {
+		return nil, err
+	}
 	mux := http.NewServeMux()
 	addMuxRoutes(mux, handlers)
+	addMetricsRoute(cfg, mux)
 	redirectRootRoute(cfg, mux, handlers)
-	return mux
+	return mux, nil
 }
 
+// Serve validates cfg and serves its routes until the server fails.
 func Serve(ctx context.Context, cfg Config) error {
-	mux := getMux(cfg)
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	mux, err := getMux(cfg)
+	if err != nil {
+		return err
+	}
 	exeName := getExeName()
 
 	if cfg.SslCertificate != "" && cfg.SslKey != "" {
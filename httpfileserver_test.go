@@ -2,14 +2,19 @@ package httpfileserver
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"testing"
+	"time"
 
+	"github.com/sgreben/httpfileserver/internal/archive"
+	"github.com/sgreben/httpfileserver/internal/bytesize"
 	"github.com/sgreben/httpfileserver/internal/filehandler"
 	"github.com/sgreben/httpfileserver/internal/routes"
 )
@@ -107,7 +112,10 @@ func Test_loadRouteHandlers(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := loadRouteHandlers(&tt.args.cfg)
+			got, err := loadRouteHandlers(&tt.args.cfg)
+			if err != nil {
+				t.Fatalf("loadRouteHandlers() error = %v", err)
+			}
 
 			for _, v := range tt.want {
 				if _, ok := got[v]; !ok {
@@ -131,6 +139,42 @@ func TestNewConfig(t *testing.T) {
 				RootRoute:        "/",
 				SslCertificate:   "",
 				SslKey:           "",
+
+				ArchiveConcurrency:      runtime.NumCPU(),
+				ArchiveRouteConcurrency: 0,
+				ArchiveQueue:            runtime.NumCPU(),
+				ArchiveQueueTimeout:     30 * time.Second,
+				ArchiveSkipErrors:       false,
+				ArchiveSymlinks:         archive.SymlinksFollow,
+				ArchiveFormats:          filehandler.DefaultArchiveFormats,
+				ArchiveLevel:            0,
+				ArchiveGzipWorkers:      1,
+				ArchiveCacheDir:         "",
+				ArchiveCacheSize:        10 * bytesize.GB,
+				ArchiveReproducible:     false,
+				SourceDateEpoch:         0,
+				ArchiveChecksums:        false,
+				ArchiveMaxSize:          0,
+				ArchiveMaxFiles:         0,
+				ArchiveMaxDepth:         0,
+				BrowseArchives:          true,
+				UploadMaxFileSize:       0,
+				UploadMaxRequestSize:    0,
+				UploadConflict:          filehandler.ConflictOverwrite,
+				UploadCreateDirs:        false,
+				UploadAllowExt:          nil,
+				UploadDenyExt:           nil,
+				UploadAllowType:         nil,
+				UploadDenyType:          nil,
+				UploadNames:             filehandler.NamesKeep,
+				UploadMaxNameLength:     0,
+				UploadDenyDotfiles:      false,
+				UploadResumableDir:      "",
+				UploadResumableExpiry:   24 * time.Hour,
+				Manage:                  false,
+				ManageAuth:              filehandler.Credentials{},
+				WebDAV:                  false,
+				MetricsRoute:            "",
 			},
 		},
 	}
@@ -254,10 +298,33 @@ func Test_getMux(t *testing.T) {
 	type args struct {
 		cfg Config
 	}
+	var manageWithoutAuth routes.Options
+	_ = manageWithoutAuth.Set("/route/:manage=true")
 	tests := []struct {
-		name string
-		args args
+		name    string
+		args    args
+		wantErr bool
 	}{
+		{
+			name: "invalid route option",
+			args: args{
+				cfg: Config{
+					Routes: routes.Routes{
+						Values: []struct {
+							Route string
+							Path  string
+						}{
+							{
+								Route: "/route/",
+								Path:  "/path",
+							},
+						},
+					},
+					RouteOptions: manageWithoutAuth,
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "success",
 			args: args{
@@ -280,7 +347,11 @@ func Test_getMux(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := getMux(tt.args.cfg); got == nil {
+			got, err := getMux(tt.args.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getMux() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got == nil && !tt.wantErr {
 				t.Errorf("getMux() returned nil mux")
 			}
 		})
@@ -297,6 +368,8 @@ func TestServe(t *testing.T) {
 	tlsConfig := NewConfig()
 	tlsConfig.SslCertificate = "certfile"
 	tlsConfig.SslKey = "keyfile"
+	invalidConfig := NewConfig()
+	_ = invalidConfig.RouteOptions.Set("/elsewhere/:webdav=true")
 
 	type args struct {
 		ctx context.Context
@@ -323,6 +396,14 @@ func TestServe(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "invalid config",
+			args: args{
+				ctx: context.Background(),
+				cfg: invalidConfig,
+			},
+			wantErr: true,
+		},
 	}
 
 	listenAndServe = httpServe
@@ -336,3 +417,183 @@ func TestServe(t *testing.T) {
 		})
 	}
 }
+
+func Test_addMetricsRoute(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		wantStatus int
+	}{
+		{
+			name:       "disabled",
+			cfg:        Config{},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "enabled",
+			cfg: Config{
+				MetricsRoute: "/metrics",
+			},
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			addMetricsRoute(tt.cfg, mux)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("addMetricsRoute() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var vars map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &vars); err != nil {
+				t.Fatalf("addMetricsRoute() body %q: %v", w.Body, err)
+			}
+			if _, ok := vars["archives"]; !ok {
+				t.Errorf("addMetricsRoute() body %q lacks the archive metrics", w.Body)
+			}
+			if _, ok := vars["cmdline"]; ok {
+				t.Errorf("addMetricsRoute() body %q shows the command line", w.Body)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	route := func(v string) routes.Routes {
+		var r routes.Routes
+		_ = r.Set(v)
+		return r
+	}
+	option := func(vs ...string) routes.Options {
+		var o routes.Options
+		for _, v := range vs {
+			_ = o.Set(v)
+		}
+		return o
+	}
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{
+			name: "no options",
+			cfg:  Config{Routes: route("/srv/=.")},
+		},
+		{
+			name: "route limits",
+			cfg:  Config{Routes: route("/srv/=."), RouteOptions: option("/srv/:archive-max-size=1G", "/srv/:archive-max-files=10", "/srv/:archive-max-depth=3")},
+		},
+		{
+			name:    "unknown route",
+			cfg:     Config{Routes: route("/srv/=."), RouteOptions: option("/tmp/:archive-max-files=10")},
+			wantErr: true,
+		},
+		{
+			name:    "unknown key",
+			cfg:     Config{Routes: route("/srv/=."), RouteOptions: option("/srv/:colour=blue")},
+			wantErr: true,
+		},
+		{
+			name: "route upload limits",
+			cfg:  Config{Routes: route("/srv/=."), RouteOptions: option("/srv/:upload-max-file-size=1G", "/srv/:upload-max-request-size=2G")},
+		},
+		{
+			name:    "invalid conflict policy",
+			cfg:     Config{Routes: route("/srv/=."), RouteOptions: option("/srv/:upload-conflict=ask")},
+			wantErr: true,
+		},
+		{
+			name: "route upload policy",
+			cfg:  Config{Routes: route("/srv/=."), RouteOptions: option("/srv/:upload-deny-type=text/html", "/srv/:upload-max-name-length=100", "/srv/:upload-deny-dotfiles=true")},
+		},
+		{
+			name:    "invalid name policy",
+			cfg:     Config{Routes: route("/srv/=."), RouteOptions: option("/srv/:upload-names=strip")},
+			wantErr: true,
+		},
+		{
+			name: "route management",
+			cfg:  Config{Routes: route("/srv/=."), RouteOptions: option("/srv/:manage=true", "/srv/:manage-auth=admin:secret")},
+		},
+		{
+			name:    "management without credentials",
+			cfg:     Config{Routes: route("/srv/=."), Manage: true},
+			wantErr: true,
+		},
+		{
+			name:    "invalid credentials",
+			cfg:     Config{Routes: route("/srv/=."), RouteOptions: option("/srv/:manage-auth=admin")},
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			cfg:     Config{Routes: route("/srv/=."), RouteOptions: option("/srv/:archive-max-files=many")},
+			wantErr: true,
+		},
+		{
+			name:    "negative global limit",
+			cfg:     Config{Routes: route("/srv/=."), ArchiveMaxDepth: -1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Config.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_loadRouteSettings(t *testing.T) {
+	var options routes.Options
+	_ = options.Set("/srv/:archive-max-files=10")
+	_ = options.Set("/srv/:upload-max-file-size=1M")
+	_ = options.Set("/srv/:upload-conflict=rename")
+	_ = options.Set("/srv/:upload-create-dirs=true")
+	_ = options.Set("/srv/:upload-allow-ext=.PDF, .tar.gz")
+	_ = options.Set("/srv/:upload-names=sanitize")
+	_ = options.Set("/srv/:manage=true")
+	_ = options.Set("/srv/:webdav=true")
+	cfg := &Config{ManageAuth: filehandler.Credentials{User: "admin", Password: "secret"}, ArchiveMaxSize: bytesize.GB, ArchiveMaxFiles: 100, UploadMaxRequestSize: bytesize.GB, UploadConflict: filehandler.ConflictReject, UploadDenyDotfiles: true, RouteOptions: options}
+
+	got, err := loadRouteSettings(cfg, "/srv/")
+	if err != nil {
+		t.Fatalf("loadRouteSettings() error = %v", err)
+	}
+	want := routeSettings{
+		archiveLimits:    archive.Limits{Bytes: int64(bytesize.GB), Files: 10},
+		uploadLimits:     filehandler.UploadLimits{FileSize: int64(bytesize.MB), RequestSize: int64(bytesize.GB)},
+		uploadConflict:   filehandler.ConflictRename,
+		uploadCreateDirs: true,
+		uploadPolicy: filehandler.UploadPolicy{
+			AllowExtensions: filehandler.List{".pdf", ".tar.gz"},
+			Names:           filehandler.NamesSanitize,
+			DenyDotfiles:    true,
+		},
+		manage:     true,
+		manageAuth: filehandler.Credentials{User: "admin", Password: "secret"},
+		webDAV:     true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadRouteSettings() = %+v, want %+v", got, want)
+	}
+	got, _ = loadRouteSettings(cfg, "/tmp/")
+	want = routeSettings{
+		archiveLimits:  archive.Limits{Bytes: int64(bytesize.GB), Files: 100},
+		uploadLimits:   filehandler.UploadLimits{RequestSize: int64(bytesize.GB)},
+		uploadConflict: filehandler.ConflictReject,
+		uploadPolicy:   filehandler.UploadPolicy{DenyDotfiles: true},
+		manageAuth:     filehandler.Credentials{User: "admin", Password: "secret"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadRouteSettings() = %+v, want %+v", got, want)
+	}
+}
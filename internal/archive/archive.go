@@ -0,0 +1,470 @@
+package archive
+
+import (
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrorsFileName is the name of the entry listing the files left out of an
+// archive built with Options.SkipErrors.
+const ErrorsFileName = "ERRORS.txt"
+
+// Options control how a file tree is archived.
+type Options struct {
+	// SkipErrors leaves out entries that cannot be read instead of failing
+	// the whole archive.
+	SkipErrors bool
+	// Symlinks is the policy for symbolic links found in the tree.
+	Symlinks SymlinkPolicy
+	// Level is the compression level from 1 (fastest) to 9 (smallest), or
+	// 0 for the default level.
+	Level int
+	// Store disables compression.
+	Store bool
+	// GzipWorkers, if greater than 1, compresses gzip streams in blocks on
+	// this many goroutines.
+	GzipWorkers int
+	// Include, if not empty, restricts the archive to files whose path or
+	// name matches one of these path.Match patterns. Directories are only
+	// added if they contain an included file.
+	Include []string
+	// Exclude leaves out files and whole directories whose path or name
+	// matches one of these path.Match patterns.
+	Exclude []string
+	// MaxDepth, if positive, leaves out entries nested deeper than this
+	// many levels below the root.
+	MaxDepth int
+	// Paths, if not empty, restricts the archive to these clean,
+	// slash-separated paths relative to the root directory.
+	Paths []string
+	// Reproducible makes archives of unchanged trees byte-for-byte
+	// identical: ownership is stored as uid and gid 0 without names, times
+	// are stored in UTC and entries generated for the archive get a fixed
+	// timestamp.
+	Reproducible bool
+	// SourceDateEpoch, if set, clamps modification times to it, following
+	// the SOURCE_DATE_EPOCH convention for reproducible builds.
+	SourceDateEpoch time.Time
+	// Checksums adds a ChecksumsFileName entry with the SHA-256 digest of
+	// every archived file.
+	Checksums bool
+	// Limits, if set, end the walk with an error wrapping ErrTooLarge once
+	// the archive would exceed them.
+	Limits Limits
+	// Password, if set, encrypts the files of zip archives.
+	Password string
+}
+
+// reproducibleTime is the timestamp of generated entries in reproducible
+// archives without a SourceDateEpoch. It is the earliest time a zip archive
+// can store.
+var reproducibleTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ModTime returns the modification time to store for an entry last
+// modified at t.
+func (o Options) ModTime(t time.Time) time.Time {
+	if !o.SourceDateEpoch.IsZero() && t.After(o.SourceDateEpoch) {
+		t = o.SourceDateEpoch
+	}
+	if o.Reproducible {
+		t = t.UTC()
+	}
+	return t
+}
+
+// GeneratedTime returns the modification time to store for entries that are
+// generated for the archive, such as ErrorsFileName.
+func (o Options) GeneratedTime() time.Time {
+	switch {
+	case !o.SourceDateEpoch.IsZero():
+		return o.ModTime(o.SourceDateEpoch)
+	case o.Reproducible:
+		return reproducibleTime
+	default:
+		return time.Now()
+	}
+}
+
+// Validate checks the options' patterns, depth and limits.
+func (o Options) Validate() error {
+	for _, pattern := range append(append([]string(nil), o.Include...), o.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("pattern %q: %v", pattern, err)
+		}
+	}
+	if o.MaxDepth < 0 {
+		return fmt.Errorf("negative max depth %d", o.MaxDepth)
+	}
+	if o.Limits.Bytes < 0 || o.Limits.Files < 0 || o.Limits.Depth < 0 {
+		return fmt.Errorf("negative limit %+v", o.Limits)
+	}
+	return nil
+}
+
+// CompressionLevel returns the compress/flate level selected by the
+// options.
+func (o Options) CompressionLevel() int {
+	switch {
+	case o.Store:
+		return flate.NoCompression
+	case o.Level == 0:
+		return flate.DefaultCompression
+	default:
+		return o.Level
+	}
+}
+
+// SymlinkPolicy decides how symbolic links are archived. It implements
+// flag.Value.
+type SymlinkPolicy string
+
+const (
+	// SymlinksFollow archives the link target in place of the link,
+	// descending into linked directories. It is the default.
+	SymlinksFollow SymlinkPolicy = "follow"
+	// SymlinksStore archives the link itself.
+	SymlinksStore SymlinkPolicy = "store"
+	// SymlinksSkip leaves links out of the archive.
+	SymlinksSkip SymlinkPolicy = "skip"
+)
+
+func (p SymlinkPolicy) String() string {
+	if p == "" {
+		return string(SymlinksFollow)
+	}
+	return string(p)
+}
+
+// Set is flag.Value.Set
+func (p *SymlinkPolicy) Set(v string) error {
+	switch policy := SymlinkPolicy(v); policy {
+	case SymlinksFollow, SymlinksStore, SymlinksSkip:
+		*p = policy
+		return nil
+	default:
+		return fmt.Errorf("unknown symlink policy %q, want one of %q, %q or %q", v, SymlinksFollow, SymlinksStore, SymlinksSkip)
+	}
+}
+
+// Entry is a file or directory visited by Walk.
+type Entry struct {
+	// Name is the slash-separated path of the entry inside the archive.
+	Name string
+	Info os.FileInfo
+	// File is open for reading if the entry is a regular file, nil otherwise.
+	File *os.File
+	// Link is the target of a symbolic link stored with SymlinksStore.
+	Link string
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// ContextReader returns a reader that fails with the context's error once
+// ctx is done, so copies of large files stop promptly on cancellation.
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return contextReader{ctx: ctx, r: r}
+}
+
+type walker struct {
+	ctx  context.Context
+	opts Options
+	fn   func(Entry) error
+	// statOnly visits regular files without opening them.
+	statOnly bool
+	skipped  []error
+	parents  []os.FileInfo
+	// files and bytes count the entries passed to fn so far.
+	files int
+	bytes int64
+	// pending holds the directories on the current path that have not been
+	// passed to fn yet because, with include patterns, a directory is only
+	// added once it turns out to contain an included file.
+	pending []Entry
+}
+
+// matchAny reports whether any of the patterns matches the entry's
+// slash-separated name or its last element.
+func matchAny(patterns []string, name string) bool {
+	base := path.Base(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *walker) emit(entry Entry) error {
+	for _, dir := range w.pending {
+		if err := w.add(dir); err != nil {
+			return err
+		}
+	}
+	w.pending = w.pending[:0]
+	return w.add(entry)
+}
+
+func (w *walker) emitDir(entry Entry) error {
+	if len(w.opts.Include) > 0 {
+		w.pending = append(w.pending, entry)
+		return nil
+	}
+	return w.add(entry)
+}
+
+func (w *walker) dropDir(entry Entry) {
+	if n := len(w.pending); n > 0 && w.pending[n-1].Name == entry.Name {
+		w.pending = w.pending[:n-1]
+	}
+}
+
+func (w *walker) skip(name string, err error) error {
+	if !w.opts.SkipErrors {
+		return err
+	}
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = &os.PathError{Op: pathErr.Op, Path: name, Err: pathErr.Err}
+	}
+	w.skipped = append(w.skipped, err)
+	return nil
+}
+
+func (w *walker) isLoop(info os.FileInfo) bool {
+	for _, parent := range w.parents {
+		if os.SameFile(parent, info) {
+			return true
+		}
+	}
+	return false
+}
+
+// visit adds the entry for path and, for directories, its children. The
+// info is from os.Lstat, so symbolic links are resolved here according to
+// the symlink policy.
+func (w *walker) visit(path, name string, info os.FileInfo) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if matchAny(w.opts.Exclude, name) {
+		return nil
+	}
+	depth := strings.Count(name, "/") + 1
+	if w.opts.MaxDepth > 0 && depth > w.opts.MaxDepth {
+		return nil
+	}
+	if err := w.checkDepth(name, depth); err != nil {
+		return err
+	}
+	entry := Entry{Name: name, Info: info}
+	if info.Mode()&os.ModeSymlink != 0 {
+		switch w.opts.Symlinks {
+		case SymlinksSkip:
+			return nil
+		case SymlinksStore:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return w.skip(name, err)
+			}
+			entry.Link = link
+			if len(w.opts.Include) > 0 && !matchAny(w.opts.Include, name) {
+				return nil
+			}
+			return w.emit(entry)
+		default:
+			target, err := os.Stat(path)
+			if err != nil {
+				return w.skip(name, err)
+			}
+			info = target
+			entry.Info = target
+		}
+	}
+	switch {
+	case info.IsDir():
+		if w.isLoop(info) {
+			return nil
+		}
+		if err := w.emitDir(entry); err != nil {
+			return err
+		}
+		defer w.dropDir(entry)
+		if w.opts.MaxDepth > 0 && depth == w.opts.MaxDepth {
+			return nil
+		}
+		return w.visitChildren(path, name, info)
+	case info.Mode().IsRegular():
+		if len(w.opts.Include) > 0 && !matchAny(w.opts.Include, name) {
+			return nil
+		}
+		if w.statOnly {
+			return w.emit(entry)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return w.skip(name, err)
+		}
+		defer file.Close()
+		entry.File = file
+		return w.emit(entry)
+	default:
+		return nil
+	}
+}
+
+func (w *walker) visitChildren(path, name string, info os.FileInfo) error {
+	names, err := readDirNames(path)
+	if err != nil {
+		return w.skip(name, err)
+	}
+	w.parents = append(w.parents, info)
+	defer func() { w.parents = w.parents[:len(w.parents)-1] }()
+	for _, childName := range names {
+		childPath := filepath.Join(path, childName)
+		childInfo, err := os.Lstat(childPath)
+		if err != nil {
+			if err := w.skip(joinName(name, childName), err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.visit(childPath, joinName(name, childName), childInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func joinName(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+func readDirNames(path string) ([]string, error) {
+	d, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Walk calls fn for each entry of the file tree rooted at root, or of the
+// subtrees selected by opts.Paths, in lexical order, stopping with the
+// context's error once ctx is done. Directories are visited before their
+// contents; the root directory itself is not visited, and a root that is a
+// file is visited under its base name.
+//
+// With opts.SkipErrors, entries that cannot be listed or opened are left out
+// and returned as skipped; any other error, including exceeding opts.Limits,
+// ends the walk.
+func Walk(ctx context.Context, root string, opts Options, fn func(Entry) error) (skipped []error, err error) {
+	return walk(&walker{ctx: ctx, opts: opts, fn: fn}, root)
+}
+
+// Fingerprint returns a digest of the options and of the names, sizes,
+// modes and modification times of the entries Walk would visit. Archives
+// of trees with equal fingerprints have the same contents.
+func Fingerprint(ctx context.Context, root string, opts Options) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q\n%+v\n", root, opts)
+	w := &walker{ctx: ctx, opts: opts, statOnly: true, fn: func(e Entry) error {
+		fmt.Fprintf(h, "%q %d %o %d %q\n", e.Name, e.Info.Size(), e.Info.Mode(), e.Info.ModTime().UnixNano(), e.Link)
+		return nil
+	}}
+	skipped, err := walk(w, root)
+	if err != nil {
+		return "", err
+	}
+	for _, err := range skipped {
+		fmt.Fprintf(h, "skipped %v\n", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func walk(w *walker, root string) (skipped []error, err error) {
+	ctx, opts := w.ctx, w.opts
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !info.IsDir():
+		err = w.visit(root, filepath.Base(root), info)
+	case len(opts.Paths) > 0:
+		err = w.visitPaths(root, info)
+	default:
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err = w.visitChildren(root, "", info)
+	}
+	return w.skipped, err
+}
+
+// visitPaths visits the selected paths below root. Paths inside another
+// selected directory are dropped since they are visited along with it.
+func (w *walker) visitPaths(root string, info os.FileInfo) error {
+	paths := append([]string(nil), w.opts.Paths...)
+	sort.Strings(paths)
+	w.parents = append(w.parents, info)
+	var last string
+	for _, name := range paths {
+		if last != "" && (name == last || strings.HasPrefix(name, last+"/")) {
+			continue
+		}
+		last = name
+		path := filepath.Join(root, filepath.FromSlash(name))
+		info, err := os.Lstat(path)
+		if err != nil {
+			if err := w.skip(name, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.visit(path, name, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrorsFile returns the contents of the ErrorsFileName entry listing the
+// skipped errors, one per line.
+func ErrorsFile(skipped []error) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d entries could not be read and were left out of this archive:\n", len(skipped))
+	for _, err := range skipped {
+		fmt.Fprintln(&b, err)
+	}
+	return []byte(b.String())
+}
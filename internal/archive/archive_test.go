@@ -0,0 +1,343 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"a.txt":     "a",
+		"sub/b.txt": "bb",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "broken")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestWalk(t *testing.T) {
+	dir := testTree(t)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		root        string
+		opts        Options
+		want        []string
+		wantSkipped int
+		wantErr     bool
+	}{
+		{
+			name:    "unreadable entry",
+			ctx:     context.Background(),
+			root:    dir,
+			opts:    Options{},
+			want:    []string{"a.txt"},
+			wantErr: true,
+		},
+		{
+			name:        "skip errors",
+			ctx:         context.Background(),
+			root:        dir,
+			opts:        Options{SkipErrors: true},
+			want:        []string{"a.txt", "sub", "sub/b.txt"},
+			wantSkipped: 1,
+		},
+		{
+			name: "single file",
+			ctx:  context.Background(),
+			root: filepath.Join(dir, "a.txt"),
+			opts: Options{},
+			want: []string{"a.txt"},
+		},
+		{
+			name:    "canceled",
+			ctx:     canceled,
+			root:    dir,
+			opts:    Options{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			skipped, err := Walk(tt.ctx, tt.root, tt.opts, func(e Entry) error {
+				if e.Info.Mode().IsRegular() == (e.File == nil) {
+					t.Errorf("Walk() entry %q file = %v", e.Name, e.File)
+				}
+				got = append(got, e.Name)
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Walk() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Walk() entries = %v, want %v", got, tt.want)
+			}
+			if len(skipped) != tt.wantSkipped {
+				t.Errorf("Walk() skipped = %v, want %d", skipped, tt.wantSkipped)
+			}
+			for _, err := range skipped {
+				if strings.Contains(err.Error(), dir) {
+					t.Errorf("Walk() skipped error %q reveals the root path", err)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorsFile(t *testing.T) {
+	got := string(ErrorsFile([]error{&os.PathError{Op: "open", Path: "x", Err: os.ErrPermission}}))
+	want := "1 entries could not be read and were left out of this archive:\nopen x: permission denied\n"
+	if got != want {
+		t.Errorf("ErrorsFile() = %q, want %q", got, want)
+	}
+}
+
+func TestWalk_symlinkLoop(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", filepath.Join(dir, "sub", "up")); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	_, err := Walk(context.Background(), dir, Options{Symlinks: SymlinksFollow}, func(e Entry) error {
+		got = append(got, e.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	if want := []string{"sub"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Walk() entries = %v, want %v", got, want)
+	}
+}
+
+func TestSymlinkPolicy_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       string
+		want    SymlinkPolicy
+		wantErr bool
+	}{
+		{name: "follow", v: "follow", want: SymlinksFollow},
+		{name: "store", v: "store", want: SymlinksStore},
+		{name: "skip", v: "skip", want: SymlinksSkip},
+		{name: "unknown", v: "copy", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p SymlinkPolicy
+			if err := p.Set(tt.v); (err != nil) != tt.wantErr {
+				t.Errorf("SymlinkPolicy.Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if p != tt.want {
+				t.Errorf("SymlinkPolicy.Set() = %q, want %q", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestWalk_filters(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"app.log",
+		"readme.txt",
+		"node_modules/pkg/index.js",
+		"logs/2020/old.log",
+		"logs/current.log",
+		"src/main.go",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{
+			name: "include",
+			opts: Options{Include: []string{"*.log"}},
+			want: []string{"app.log", "logs", "logs/2020", "logs/2020/old.log", "logs/current.log"},
+		},
+		{
+			name: "include path",
+			opts: Options{Include: []string{"logs/*.log"}},
+			want: []string{"logs", "logs/current.log"},
+		},
+		{
+			name: "exclude",
+			opts: Options{Exclude: []string{"node_modules", "*.log"}},
+			want: []string{"logs", "logs/2020", "readme.txt", "src", "src/main.go"},
+		},
+		{
+			name: "max depth",
+			opts: Options{MaxDepth: 1},
+			want: []string{"app.log", "logs", "node_modules", "readme.txt", "src"},
+		},
+		{
+			name: "include with max depth",
+			opts: Options{Include: []string{"*.log"}, MaxDepth: 2},
+			want: []string{"app.log", "logs", "logs/current.log"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			_, err := Walk(context.Background(), dir, tt.opts, func(e Entry) error {
+				got = append(got, e.Name)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Walk() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Walk() entries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "valid", opts: Options{Include: []string{"*.log"}, Exclude: []string{"[a-z]*"}, MaxDepth: 3}},
+		{name: "bad include", opts: Options{Include: []string{"[*.log"}}, wantErr: true},
+		{name: "bad exclude", opts: Options{Exclude: []string{"\\"}}, wantErr: true},
+		{name: "negative depth", opts: Options{MaxDepth: -1}, wantErr: true},
+		{name: "negative limit", opts: Options{Limits: Limits{Files: -1}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Options.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWalk_paths(t *testing.T) {
+	dir := testTree(t)
+	tests := []struct {
+		name        string
+		paths       []string
+		want        []string
+		wantSkipped int
+	}{
+		{
+			name:  "files and directories",
+			paths: []string{"sub", "a.txt"},
+			want:  []string{"a.txt", "sub", "sub/b.txt"},
+		},
+		{
+			name:  "nested selection",
+			paths: []string{"sub/b.txt", "sub", "sub"},
+			want:  []string{"sub", "sub/b.txt"},
+		},
+		{
+			name:        "missing path",
+			paths:       []string{"missing", "sub/b.txt"},
+			want:        []string{"sub/b.txt"},
+			wantSkipped: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			skipped, err := Walk(context.Background(), dir, Options{Paths: tt.paths, SkipErrors: true}, func(e Entry) error {
+				got = append(got, e.Name)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Walk() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Walk() entries = %v, want %v", got, tt.want)
+			}
+			if len(skipped) != tt.wantSkipped {
+				t.Errorf("Walk() skipped = %v, want %d", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestOptions_ModTime(t *testing.T) {
+	epoch := time.Unix(1500000000, 0)
+	before := epoch.Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		opts Options
+		t    time.Time
+		want time.Time
+	}{
+		{
+			name: "unchanged",
+			opts: Options{},
+			t:    epoch.Add(time.Hour),
+			want: epoch.Add(time.Hour),
+		},
+		{
+			name: "clamped",
+			opts: Options{SourceDateEpoch: epoch},
+			t:    epoch.Add(time.Hour),
+			want: epoch,
+		},
+		{
+			name: "older than epoch",
+			opts: Options{SourceDateEpoch: epoch},
+			t:    before,
+			want: before,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.ModTime(tt.t); !got.Equal(tt.want) {
+				t.Errorf("ModTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := (Options{Reproducible: true}).ModTime(before); got.Location() != time.UTC {
+		t.Errorf("ModTime() location = %v, want UTC", got.Location())
+	}
+}
+
+func TestOptions_GeneratedTime(t *testing.T) {
+	epoch := time.Unix(1500000000, 0)
+	if got := (Options{SourceDateEpoch: epoch}).GeneratedTime(); !got.Equal(epoch) {
+		t.Errorf("GeneratedTime() = %v, want %v", got, epoch)
+	}
+	if got := (Options{Reproducible: true}).GeneratedTime(); !got.Equal(reproducibleTime) {
+		t.Errorf("GeneratedTime() = %v, want %v", got, reproducibleTime)
+	}
+}
@@ -0,0 +1,65 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+)
+
+// ChecksumsFileName is the name of the entry listing the SHA-256 digests of
+// the files in an archive built with Options.Checksums.
+const ChecksumsFileName = "SHA256SUMS"
+
+// Checksums collects the digests of archived files while they are copied,
+// so that no second pass over the files is needed. A nil *Checksums copies
+// without hashing.
+type Checksums struct {
+	b bytes.Buffer
+}
+
+// NewChecksums returns a *Checksums if opts ask for a ChecksumsFileName
+// entry, nil otherwise.
+func NewChecksums(opts Options) *Checksums {
+	if !opts.Checksums {
+		return nil
+	}
+	return &Checksums{}
+}
+
+// Copy copies the content of entry's file to w, stopping once ctx is done,
+// and records its digest under the entry's name.
+func (c *Checksums) Copy(ctx context.Context, w io.Writer, entry Entry) (int64, error) {
+	r := ContextReader(ctx, entry.File)
+	if c == nil {
+		return io.Copy(w, r)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		return n, err
+	}
+	c.add(entry.Name, h.Sum(nil))
+	return n, nil
+}
+
+// add appends a line in the format read by sha256sum -c. Like GNU
+// coreutils, names containing a backslash or newline are escaped and the
+// line is marked with a leading backslash.
+func (c *Checksums) add(name string, sum []byte) {
+	if strings.ContainsAny(name, "\\\n") {
+		c.b.WriteByte('\\')
+		name = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+	}
+	c.b.WriteString(hex.EncodeToString(sum))
+	c.b.WriteString("  ")
+	c.b.WriteString(name)
+	c.b.WriteByte('\n')
+}
+
+// Bytes returns the contents of the ChecksumsFileName entry.
+func (c *Checksums) Bytes() []byte {
+	return c.b.Bytes()
+}
@@ -0,0 +1,77 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	dir := testTree(t)
+	sums := NewChecksums(Options{Checksums: true})
+	_, err := Walk(context.Background(), dir, Options{Symlinks: SymlinksSkip}, func(entry Entry) error {
+		if entry.File == nil {
+			return nil
+		}
+		_, err := sums.Copy(context.Background(), ioutil.Discard, entry)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	want := "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb  a.txt\n" +
+		"3b64db95cb55c763391c707108489ae18b4112d783300de38e033b4c98c3deaf  sub/b.txt\n"
+	if got := string(sums.Bytes()); got != want {
+		t.Errorf("Checksums.Bytes() = %q, want %q", got, want)
+	}
+}
+
+func TestChecksums_nil(t *testing.T) {
+	if sums := NewChecksums(Options{}); sums != nil {
+		t.Fatalf("NewChecksums() = %v, want nil", sums)
+	}
+	var sums *Checksums
+	var buf bytes.Buffer
+	f, err := os.Open(filepath.Join(testTree(t), "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := sums.Copy(context.Background(), &buf, Entry{Name: "f", File: f}); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if buf.String() != "a" {
+		t.Errorf("Copy() wrote %q, want %q", buf.String(), "a")
+	}
+}
+
+func TestChecksums_add(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{
+			name: "plain",
+			file: "dir/file name.txt",
+			want: "00  dir/file name.txt\n",
+		},
+		{
+			name: "escaped",
+			file: "a\\b\nc",
+			want: "\\00  a\\\\b\\nc\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sums Checksums
+			sums.add(tt.file, []byte{0})
+			if got := string(sums.Bytes()); got != tt.want {
+				t.Errorf("add() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
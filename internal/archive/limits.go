@@ -0,0 +1,70 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sgreben/httpfileserver/internal/bytesize"
+)
+
+// ErrTooLarge is returned, wrapped, by Walk and Check once a tree exceeds
+// Options.Limits.
+var ErrTooLarge = errors.New("archive too large")
+
+// Limits bound the archive of a tree. Zero fields are not enforced.
+type Limits struct {
+	// Bytes is the maximum total size of the archived files, before
+	// compression.
+	Bytes int64
+	// Files is the maximum number of archived files and links.
+	Files int
+	// Depth is the maximum number of levels the walk descends below the
+	// root.
+	Depth int
+}
+
+// IsZero reports whether no limit is set.
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+// add passes entry to fn once it has been counted against the limits.
+func (w *walker) add(entry Entry) error {
+	limits := w.opts.Limits
+	if !entry.Info.IsDir() {
+		w.files++
+		if limits.Files > 0 && w.files > limits.Files {
+			return fmt.Errorf("%w: more than %d files", ErrTooLarge, limits.Files)
+		}
+	}
+	if entry.Link == "" && entry.Info.Mode().IsRegular() {
+		w.bytes += entry.Info.Size()
+		if limits.Bytes > 0 && w.bytes > limits.Bytes {
+			return fmt.Errorf("%w: more than %s of files", ErrTooLarge, bytesize.Size(limits.Bytes))
+		}
+	}
+	return w.fn(entry)
+}
+
+// checkDepth fails once the walk reaches an entry nested deeper than the
+// depth limit.
+func (w *walker) checkDepth(name string, depth int) error {
+	if limit := w.opts.Limits.Depth; limit > 0 && depth > limit {
+		return fmt.Errorf("%w: %q is nested more than %d levels deep", ErrTooLarge, name, limit)
+	}
+	return nil
+}
+
+// Check walks the tree like Walk, without opening any files, and returns an
+// error wrapping ErrTooLarge if the archive would exceed opts.Limits. Walk
+// enforces the limits as well, so Check only serves to refuse an archive
+// before any of it is written.
+func Check(ctx context.Context, root string, opts Options) error {
+	if opts.Limits.IsZero() {
+		return nil
+	}
+	w := &walker{ctx: ctx, opts: opts, statOnly: true, fn: func(Entry) error { return nil }}
+	_, err := walk(w, root)
+	return err
+}
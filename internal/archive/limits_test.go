@@ -0,0 +1,65 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestWalk_limits(t *testing.T) {
+	dir := testTree(t)
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{
+			name: "within limits",
+			opts: Options{Limits: Limits{Bytes: 3, Files: 2, Depth: 2}, Symlinks: SymlinksSkip},
+		},
+		{
+			name:    "too many bytes",
+			opts:    Options{Limits: Limits{Bytes: 2}, Symlinks: SymlinksSkip},
+			wantErr: true,
+		},
+		{
+			name:    "too many files",
+			opts:    Options{Limits: Limits{Files: 1}, Symlinks: SymlinksSkip},
+			wantErr: true,
+		},
+		{
+			name:    "stored links count as files",
+			opts:    Options{Limits: Limits{Files: 2}, Symlinks: SymlinksStore},
+			wantErr: true,
+		},
+		{
+			name:    "too deep",
+			opts:    Options{Limits: Limits{Depth: 1}, Symlinks: SymlinksSkip},
+			wantErr: true,
+		},
+		{
+			name: "too deep but filtered",
+			opts: Options{Limits: Limits{Depth: 1}, MaxDepth: 1, Symlinks: SymlinksSkip},
+		},
+		{
+			name:    "not skipped",
+			opts:    Options{Limits: Limits{Files: 1}, SkipErrors: true},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Walk(context.Background(), dir, tt.opts, func(Entry) error { return nil })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Walk() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrTooLarge) {
+				t.Errorf("Walk() error = %v, want ErrTooLarge", err)
+			}
+			if err := Check(context.Background(), dir, tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package archivecache
+
+import (
+	"container/list"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const fileExtension = ".archive"
+
+var (
+	// ErrInProgress is returned by Create while another writer for the same
+	// key has not been committed or aborted yet.
+	ErrInProgress = errors.New("archive is being generated")
+	// ErrTooLarge is returned by Writer.Write once the archive would no
+	// longer fit in the cache.
+	ErrTooLarge = errors.New("archive does not fit in the archive cache")
+)
+
+type entry struct {
+	key        string
+	generation string
+	size       int64
+}
+
+// Cache is a size-bounded directory of generated archives, evicting the
+// least recently used archives first.
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu         sync.Mutex
+	size       int64
+	lru        *list.List // of *entry, most recently used first
+	entries    map[string]*list.Element
+	inProgress map[string]bool
+	generation uint64 // archives created, to tell generations apart
+}
+
+// New returns a cache storing up to maxSize bytes of archives in dir,
+// creating dir if necessary. Archives left in dir by an earlier run are
+// kept, ordered by their modification times.
+//
+// Archives are stored as KEY.GENERATION.archive, where the generation
+// tells apart archives generated for the same key at different times,
+// which need not be byte-for-byte identical.
+func New(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:        dir,
+		maxSize:    maxSize,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+		inProgress: make(map[string]bool),
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	for _, info := range infos {
+		name := info.Name()
+		switch {
+		case strings.HasSuffix(name, fileExtension):
+			key, generation, ok := splitFileName(name)
+			if _, dup := c.entries[key]; !ok || dup {
+				// left by an older version, or an older generation
+				_ = os.Remove(filepath.Join(dir, name))
+				continue
+			}
+			c.entries[key] = c.lru.PushBack(&entry{key: key, generation: generation, size: info.Size()})
+			c.size += info.Size()
+		case strings.HasSuffix(name, ".tmp"):
+			_ = os.Remove(filepath.Join(dir, name))
+		}
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// splitFileName returns the key and generation of the archive stored in
+// the file called name.
+func splitFileName(name string) (key, generation string, ok bool) {
+	base := strings.TrimSuffix(name, fileExtension)
+	i := strings.LastIndex(base, ".")
+	if i <= 0 || i == len(base)-1 {
+		return "", "", false
+	}
+	return base[:i], base[i+1:], true
+}
+
+func (c *Cache) path(key, generation string) string {
+	return filepath.Join(c.dir, key+"."+generation+fileExtension)
+}
+
+// Generation returns the generation of the cached archive file opened by
+// Open.
+func Generation(file *os.File) string {
+	_, generation, _ := splitFileName(filepath.Base(file.Name()))
+	return generation
+}
+
+// Open returns the cached archive for key, marking it as recently used.
+func (c *Cache) Open(key string) (*os.File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	file, err := os.Open(c.path(key, e.Value.(*entry).generation))
+	if err != nil {
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return file, true
+}
+
+// Create returns a Writer whose contents become the cached archive for key
+// once committed.
+func (c *Cache) Create(key string) (*Writer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inProgress[key] {
+		return nil, ErrInProgress
+	}
+	file, err := ioutil.TempFile(c.dir, key+"-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	c.inProgress[key] = true
+	c.generation++
+	generation := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(c.generation, 36)
+	return &Writer{file: file, cache: c, key: key, generation: generation}, nil
+}
+
+func (c *Cache) add(key, generation string, size int64) {
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key: key, generation: generation, size: size})
+	c.size += size
+	c.evict()
+}
+
+func (c *Cache) remove(e *list.Element) {
+	ent := e.Value.(*entry)
+	c.lru.Remove(e)
+	delete(c.entries, ent.key)
+	c.size -= ent.size
+	if err := os.Remove(c.path(ent.key, ent.generation)); err != nil && !os.IsNotExist(err) {
+		log.Printf("archive cache: %v", err)
+	}
+}
+
+// evict removes least recently used archives until the cache fits its
+// size. Open files of evicted archives stay readable where the OS allows.
+func (c *Cache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Writer writes an archive into the cache. Writes fail with ErrTooLarge
+// before the archive outgrows the cache; the writer must then be aborted.
+type Writer struct {
+	file       *os.File
+	cache      *Cache
+	key        string
+	generation string
+	size       int64
+}
+
+// Generation returns the generation the archive will have once committed.
+func (w *Writer) Generation() string {
+	return w.generation
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.size+int64(len(p)) > w.cache.maxSize {
+		return 0, ErrTooLarge
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Commit stores the written archive under the writer's key.
+func (w *Writer) Commit() error {
+	c := w.cache
+	defer func() {
+		c.mu.Lock()
+		delete(c.inProgress, w.key)
+		c.mu.Unlock()
+	}()
+	if err := w.file.Close(); err != nil {
+		_ = os.Remove(w.file.Name())
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Rename(w.file.Name(), c.path(w.key, w.generation)); err != nil {
+		_ = os.Remove(w.file.Name())
+		return err
+	}
+	c.add(w.key, w.generation, w.size)
+	return nil
+}
+
+// Abort discards the written data.
+func (w *Writer) Abort() {
+	c := w.cache
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+	c.mu.Lock()
+	delete(c.inProgress, w.key)
+	c.mu.Unlock()
+}
@@ -0,0 +1,134 @@
+package archivecache
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func put(t *testing.T, c *Cache, key, data string) {
+	t.Helper()
+	w, err := c.Create(key)
+	if err != nil {
+		t.Fatalf("Cache.Create(%q) error = %v", key, err)
+	}
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Writer.Commit() error = %v", err)
+	}
+}
+
+func has(c *Cache, key string) bool {
+	file, ok := c.Open(key)
+	if ok {
+		file.Close()
+	}
+	return ok
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 10)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	put(t, c, "a", "aaaa")
+	put(t, c, "b", "bbbb")
+	if !has(c, "a") {
+		t.Fatalf("Cache.Open(%q) missing", "a")
+	}
+	put(t, c, "c", "cccc")
+	if has(c, "b") {
+		t.Errorf("Cache.Open(%q) not evicted", "b")
+	}
+	if !has(c, "a") || !has(c, "c") {
+		t.Errorf("Cache.Open() evicted recently used archives")
+	}
+
+	w, err := c.Create("big")
+	if err != nil {
+		t.Fatalf("Cache.Create() error = %v", err)
+	}
+	if n, err := io.Copy(w, strings.NewReader("0123456789")); n != 10 || err != nil {
+		t.Fatalf("io.Copy() = %d, %v", n, err)
+	}
+	if n, err := io.Copy(w, strings.NewReader("0")); n != 0 || !errors.Is(err, ErrTooLarge) {
+		t.Errorf("io.Copy() beyond the cache size = %d, %v, want %v", n, err, ErrTooLarge)
+	}
+	w.Abort()
+	if has(c, "big") {
+		t.Errorf("Cache.Open(%q) stored archive larger than the cache", "big")
+	}
+
+	file, ok := c.Open("a")
+	if !ok {
+		t.Fatalf("Cache.Open(%q) missing", "a")
+	}
+	data, _ := ioutil.ReadAll(file)
+	file.Close()
+	if string(data) != "aaaa" {
+		t.Errorf("Cache.Open(%q) = %q, want %q", "a", data, "aaaa")
+	}
+
+	reloaded, err := New(dir, 10)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if !has(reloaded, "a") || !has(reloaded, "c") {
+		t.Errorf("New() did not load existing archives")
+	}
+}
+
+func TestCache_Create(t *testing.T) {
+	c, err := New(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	w, err := c.Create("a")
+	if err != nil {
+		t.Fatalf("Cache.Create() error = %v", err)
+	}
+	if _, err := c.Create("a"); err != ErrInProgress {
+		t.Errorf("Cache.Create() concurrent error = %v, want %v", err, ErrInProgress)
+	}
+	w.Abort()
+	if has(c, "a") {
+		t.Errorf("Cache.Open() returned aborted archive")
+	}
+	w, err = c.Create("a")
+	if err != nil {
+		t.Fatalf("Cache.Create() after abort error = %v", err)
+	}
+	w.Abort()
+}
+
+func TestCache_generation(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New(dir, 10)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	generation := func() string {
+		file, ok := c.Open("a")
+		if !ok {
+			t.Fatalf("Cache.Open(%q) missing", "a")
+		}
+		defer file.Close()
+		return Generation(file)
+	}
+
+	put(t, c, "a", "aaaa")
+	first := generation()
+	put(t, c, "a", "AAAA")
+	if second := generation(); second == "" || second == first {
+		t.Errorf("Generation() after regenerating = %q, first %q", second, first)
+	}
+	if entries, err := ioutil.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("cache directory holds %d files, %v, want 1", len(entries), err)
+	}
+}
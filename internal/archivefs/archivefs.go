@@ -0,0 +1,227 @@
+// Package archivefs reads zip and tar archives as read-only directory trees.
+package archivefs
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Archive formats that can be browsed.
+const (
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+// maxEntries bounds the number of entries indexed per archive.
+const maxEntries = 1 << 16
+
+// ErrUnsupported is returned by Open for entries that cannot be read, such
+// as encrypted zip entries or entries compressed with an unknown method.
+var ErrUnsupported = errors.New("unsupported archive entry")
+
+// DetectFormat returns the format of the archive file name by its
+// extension, or "" if it is not a browsable archive.
+func DetectFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return FormatZip
+	case strings.HasSuffix(name, ".tar"):
+		return FormatTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGz
+	}
+	return ""
+}
+
+// Entry is a file or directory inside an archive.
+type Entry struct {
+	// Name is the slash-separated path of the entry inside the archive,
+	// without leading or trailing slashes; the root directory is "".
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+
+	// seq is the position of the entry's header among the tar headers.
+	seq int
+	// offset is the position of the entry's data in the archive file, or
+	// -1 if it has to be read by decompressing the archive from the start.
+	offset int64
+	// compressedSize, method and crc32 describe the data of zip entries.
+	compressedSize int64
+	method         uint16
+	crc32          uint32
+	unsupported    bool
+}
+
+// IsDir reports whether e is a directory.
+func (e *Entry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// Compressed reports whether reading e decompresses data or reads the
+// archive from the start, rather than reading e's data in place.
+func (e *Entry) Compressed() bool {
+	return e.method == zip.Deflate || e.offset < 0
+}
+
+// Base returns the last element of the entry's name.
+func (e *Entry) Base() string {
+	return path.Base(e.Name)
+}
+
+// Index lists the entries of an archive file.
+type Index struct {
+	path     string
+	format   string
+	entries  map[string]*Entry
+	children map[string][]*Entry
+}
+
+// Build reads the index of the archive file at path.
+func Build(path string) (*Index, error) {
+	format := DetectFormat(path)
+	ix := &Index{
+		path:     path,
+		format:   format,
+		entries:  make(map[string]*Entry),
+		children: make(map[string][]*Entry),
+	}
+	var err error
+	switch format {
+	case FormatZip:
+		err = ix.readZip()
+	case FormatTar, FormatTarGz:
+		err = ix.readTar()
+	default:
+		err = fmt.Errorf("%s: not a browsable archive", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ix.link()
+	return ix, nil
+}
+
+// cleanName turns the name of an archive header into an entry name,
+// confining names with ".." elements or leading slashes to the archive.
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// add records e, replacing an earlier entry of the same name as tar
+// extraction would.
+func (ix *Index) add(e *Entry) error {
+	if e.Name == "" {
+		return nil
+	}
+	if _, ok := ix.entries[e.Name]; !ok && len(ix.entries) >= maxEntries {
+		return fmt.Errorf("%s: more than %d entries", ix.path, maxEntries)
+	}
+	ix.entries[e.Name] = e
+	return nil
+}
+
+// link adds the directories implied by entry names and sorts the children
+// of every directory by name.
+func (ix *Index) link() {
+	ix.entries[""] = &Entry{Mode: os.ModeDir | 0755, offset: -1}
+	names := make([]string, 0, len(ix.entries))
+	for name := range ix.entries {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		for name != "" {
+			dir := path.Dir(name)
+			if dir == "." {
+				dir = ""
+			}
+			parent, ok := ix.entries[dir]
+			if !ok {
+				parent = &Entry{Name: dir, Mode: os.ModeDir | 0755, offset: -1}
+				ix.entries[dir] = parent
+			} else if !parent.IsDir() {
+				// a file shadowed by a directory of the same name
+				parent.Mode = os.ModeDir | 0755
+			}
+			ix.children[dir] = append(ix.children[dir], ix.entries[name])
+			if ok {
+				break
+			}
+			name = dir
+		}
+	}
+	for _, children := range ix.children {
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].Name < children[j].Name
+		})
+	}
+}
+
+// Lookup returns the entry called name, "" being the root directory.
+func (ix *Index) Lookup(name string) (*Entry, bool) {
+	e, ok := ix.entries[cleanName(name)]
+	return e, ok
+}
+
+// ReadDir returns the entries of the directory dir, sorted by name.
+func (ix *Index) ReadDir(dir string) []*Entry {
+	return ix.children[cleanName(dir)]
+}
+
+// Len returns the number of entries, including implied directories.
+func (ix *Index) Len() int {
+	return len(ix.entries)
+}
+
+// Open returns the contents of the regular file e. The reader also
+// implements io.Seeker if the entry is stored uncompressed.
+func (ix *Index) Open(e *Entry) (io.ReadCloser, error) {
+	if e.IsDir() {
+		return nil, fmt.Errorf("%s: is a directory", e.Name)
+	}
+	if e.unsupported {
+		return nil, ErrUnsupported
+	}
+	file, err := os.Open(ix.path)
+	if err != nil {
+		return nil, err
+	}
+	var r io.ReadCloser
+	switch ix.format {
+	case FormatZip:
+		r, err = openZip(file, e)
+	default:
+		r, err = openTar(file, ix.format, e)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// sectionReadCloser reads an uncompressed entry directly from the archive.
+type sectionReadCloser struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// readCloser reads a compressed entry, closing the archive file when done.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
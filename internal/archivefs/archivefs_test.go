@@ -0,0 +1,284 @@
+package archivefs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testFiles = []struct {
+	name, body string
+}{
+	{"a.txt", "alpha"},
+	{"dir/b.txt", strings.Repeat("bravo ", 1000)},
+	{"dir/sub/c.txt", "charlie"},
+	{"../escape.txt", "escape"},
+}
+
+func writeTestZip(t *testing.T, path string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	if _, err := w.Create("dir/"); err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range testFiles {
+		method := zip.Deflate
+		if i%2 == 0 {
+			method = zip.Store
+		}
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: f.name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, f.body)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestTar(t *testing.T, path string, compress bool) {
+	t.Helper()
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(&buf)
+		out = zw
+	}
+	w := tar.NewWriter(out)
+	w.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755})
+	w.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "a.txt"})
+	for _, f := range testFiles {
+		w.WriteHeader(&tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(f.body)), ModTime: time.Unix(1e9, 0)})
+		io.WriteString(w, f.body)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if zw != nil {
+		zw.Close()
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"a.zip", FormatZip},
+		{"A.ZIP", FormatZip},
+		{"a.tar", FormatTar},
+		{"a.tar.gz", FormatTarGz},
+		{"a.tgz", FormatTarGz},
+		{"a.gz", ""},
+		{"zip", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectFormat(tt.name); got != tt.want {
+				t.Errorf("DetectFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	writeTestZip(t, filepath.Join(dir, "test.zip"))
+	writeTestTar(t, filepath.Join(dir, "test.tar"), false)
+	writeTestTar(t, filepath.Join(dir, "test.tar.gz"), true)
+
+	tests := []struct {
+		name         string
+		wantSeekable []string
+	}{
+		{name: "test.zip", wantSeekable: []string{"a.txt", "dir/sub/c.txt"}},
+		{name: "test.tar", wantSeekable: []string{"a.txt", "dir/b.txt", "dir/sub/c.txt", "escape.txt"}},
+		{name: "test.tar.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ix, err := Build(filepath.Join(dir, tt.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, e := range ix.ReadDir("") {
+				names = append(names, e.Base())
+			}
+			if want := []string{"a.txt", "dir", "escape.txt"}; !reflect.DeepEqual(names, want) {
+				t.Errorf("ReadDir(\"\") = %v, want %v", names, want)
+			}
+			if e, ok := ix.Lookup("dir/sub"); !ok || !e.IsDir() {
+				t.Errorf("Lookup(dir/sub) = %v, %v, want implied directory", e, ok)
+			}
+			if _, ok := ix.Lookup("link"); ok {
+				t.Error("Lookup(link) found a symbolic link")
+			}
+			var seekable []string
+			for _, f := range testFiles {
+				e, ok := ix.Lookup(f.name)
+				if !ok {
+					t.Fatalf("Lookup(%q) not found", f.name)
+				}
+				if e.Size != int64(len(f.body)) {
+					t.Errorf("%s: Size = %d, want %d", f.name, e.Size, len(f.body))
+				}
+				r, err := ix.Open(e)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := r.(io.Seeker); ok {
+					seekable = append(seekable, e.Name)
+				}
+				got, err := ioutil.ReadAll(r)
+				r.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != f.body {
+					t.Errorf("%s: content = %q, want %q", f.name, got, f.body)
+				}
+			}
+			if !reflect.DeepEqual(seekable, tt.wantSeekable) {
+				t.Errorf("seekable entries = %v, want %v", seekable, tt.wantSeekable)
+			}
+		})
+	}
+}
+
+func TestBuild_notArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.zip")
+	if err := ioutil.WriteFile(path, []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Build(path); err == nil {
+		t.Error("Build() error = nil, want error")
+	}
+}
+
+func TestIndex_Open_checksum(t *testing.T) {
+	var data bytes.Buffer
+	fw, _ := flate.NewWriter(&data, flate.DefaultCompression)
+	io.WriteString(fw, "alpha")
+	fw.Close()
+	tests := []struct {
+		name    string
+		crc     uint32
+		size    uint64
+		wantErr error
+	}{
+		{name: "valid", crc: crc32.ChecksumIEEE([]byte("alpha")), size: 5},
+		{name: "wrong checksum", crc: 1, size: 5, wantErr: zip.ErrChecksum},
+		{name: "truncated", crc: crc32.ChecksumIEEE([]byte("alpha")), size: 6, wantErr: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			w, err := zw.CreateRaw(&zip.FileHeader{
+				Name:               "a.txt",
+				Method:             zip.Deflate,
+				CRC32:              tt.crc,
+				CompressedSize64:   uint64(data.Len()),
+				UncompressedSize64: tt.size,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(data.Bytes())
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "test.zip")
+			if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			ix, err := Build(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, _ := ix.Lookup("a.txt")
+			r, err := ix.Open(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if _, err := ioutil.ReadAll(r); err != tt.wantErr {
+				t.Errorf("Index.Open() read error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCache_Index(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.tar")
+	writeTestTar(t, path, false)
+	c := NewCache(1)
+
+	first, err := c.Index(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := c.Index(path); again != first {
+		t.Error("Index() rebuilt an unchanged archive")
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(make([]byte, 1024))
+	f.Close()
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := c.Index(path); err != nil || changed == first {
+		t.Error("Index() returned the index of a changed archive")
+	}
+	if c.lru.Len() != 1 {
+		t.Errorf("cache holds %d indexes, want 1", c.lru.Len())
+	}
+}
+
+func TestCache_maxEntries(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "a.tar"), filepath.Join(dir, "b.tar")}
+	for _, path := range paths {
+		writeTestTar(t, path, false)
+	}
+	ix, err := Build(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCache(ix.Len() + 1)
+	for _, path := range paths {
+		if _, err := c.Index(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.Has(paths[0]) || !c.Has(paths[1]) {
+		t.Errorf("Cache.Has() = %v, %v, want false, true", c.Has(paths[0]), c.Has(paths[1]))
+	}
+	if c.size != ix.Len() {
+		t.Errorf("cache holds %d entries, want %d", c.size, ix.Len())
+	}
+}
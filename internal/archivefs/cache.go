@@ -0,0 +1,98 @@
+package archivefs
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+)
+
+type cacheEntry struct {
+	key   string
+	index *Index
+}
+
+// Cache keeps the indexes of recently browsed archives, so that listing a
+// tar.gz file does not decompress it every time. Indexes are keyed by the
+// path, size and modification time of the archive, so changed archives are
+// indexed again.
+type Cache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	size    int // entries of the cached indexes
+}
+
+// NewCache returns a cache holding indexes of up to maxEntries archive
+// entries in total, and at least the most recently used index.
+func NewCache(maxEntries int) *Cache {
+	return &Cache{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func cacheKey(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s\x00%d\x00%d", path, info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// Has reports whether the index of the archive file at path is cached, so
+// that Index returns without reading the archive.
+func (c *Cache) Has(path string) bool {
+	if c == nil {
+		return false
+	}
+	key, err := cacheKey(path)
+	if err != nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.entries[key]
+	return ok
+}
+
+// Index returns the index of the archive file at path, building it if it
+// is not cached. A nil Cache builds the index on every call.
+func (c *Cache) Index(path string) (*Index, error) {
+	if c == nil {
+		return Build(path)
+	}
+	key, err := cacheKey(path)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*cacheEntry).index, nil
+	}
+	c.mu.Unlock()
+
+	ix, err := Build(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, index: ix})
+		c.size += ix.Len()
+	}
+	for c.size > c.maxEntries && c.lru.Len() > 1 {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*cacheEntry).key)
+		c.size -= e.Value.(*cacheEntry).index.Len()
+	}
+	return ix, nil
+}
@@ -0,0 +1,134 @@
+package archivefs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// countingReader tracks the position in a plain tar file, so that the data
+// offsets of entries can be recorded. It forwards Seek so that tar.Reader
+// can skip entry data without reading it.
+type countingReader struct {
+	f   *os.File
+	pos int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.f.Read(p)
+	c.pos += int64(n)
+	return n, err
+}
+
+func (c *countingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := c.f.Seek(offset, whence)
+	if err == nil {
+		c.pos = pos
+	}
+	return pos, err
+}
+
+// isSparse reports whether h describes a sparse file, whose data is not
+// stored contiguously in the archive.
+func isSparse(h *tar.Header) bool {
+	if h.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range h.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// newTarReader returns a tar reader for file, and the counting reader
+// beneath it for plain tar files.
+func newTarReader(file *os.File, format string) (*tar.Reader, *countingReader, io.Closer, error) {
+	if format == FormatTarGz {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return tar.NewReader(zr), nil, zr, nil
+	}
+	c := &countingReader{f: file}
+	return tar.NewReader(c), c, io.NopCloser(nil), nil
+}
+
+func (ix *Index) readTar() error {
+	file, err := os.Open(ix.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	tr, counter, closer, err := newTarReader(file, ix.format)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	for seq := 0; ; seq++ {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		e := &Entry{
+			Name:    cleanName(h.Name),
+			Size:    h.Size,
+			Mode:    h.FileInfo().Mode(),
+			ModTime: h.ModTime,
+			seq:     seq,
+			offset:  -1,
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			e.Size = 0
+		case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+			if counter != nil && !isSparse(h) {
+				e.offset = counter.pos
+			}
+		default:
+			continue
+		}
+		if err := ix.add(e); err != nil {
+			return err
+		}
+	}
+}
+
+// openTar reads e from a tar file: directly at its data offset if it is
+// known, else by reading the archive up to e's header.
+func openTar(file *os.File, format string, e *Entry) (io.ReadCloser, error) {
+	if e.offset >= 0 {
+		return sectionReadCloser{io.NewSectionReader(file, e.offset, e.Size), file}, nil
+	}
+	tr, _, closer, err := newTarReader(file, format)
+	if err != nil {
+		return nil, err
+	}
+	for seq := 0; ; seq++ {
+		if _, err := tr.Next(); err != nil {
+			closer.Close()
+			if err == io.EOF {
+				err = fmt.Errorf("%s: entry not found", e.Name)
+			}
+			return nil, err
+		}
+		if seq == e.seq {
+			break
+		}
+	}
+	return readCloser{
+		Reader: tr,
+		close: func() error {
+			closer.Close()
+			return file.Close()
+		},
+	}, nil
+}
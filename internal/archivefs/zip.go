@@ -0,0 +1,100 @@
+package archivefs
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+// zipFlagEncrypted is the general purpose flag bit of encrypted entries.
+const zipFlagEncrypted = 0x1
+
+func (ix *Index) readZip() error {
+	r, err := zip.OpenReader(ix.path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		e := &Entry{
+			Name:    cleanName(f.Name),
+			Mode:    f.Mode(),
+			ModTime: f.Modified,
+			offset:  -1,
+		}
+		if strings.HasSuffix(f.Name, "/") {
+			e.Mode = os.ModeDir | e.Mode.Perm()
+			if err := ix.add(e); err != nil {
+				return err
+			}
+			continue
+		}
+		if !e.Mode.IsRegular() {
+			continue
+		}
+		e.Size = int64(f.UncompressedSize64)
+		e.compressedSize = int64(f.CompressedSize64)
+		e.method = f.Method
+		e.crc32 = f.CRC32
+		switch {
+		case f.Flags&zipFlagEncrypted != 0:
+			e.unsupported = true
+		case f.Method == zip.Store || f.Method == zip.Deflate:
+			if e.offset, err = f.DataOffset(); err != nil {
+				return err
+			}
+		default:
+			e.unsupported = true
+		}
+		if err := ix.add(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openZip reads e from the zip file directly at its data offset, so that
+// no central directory has to be parsed again.
+func openZip(file *os.File, e *Entry) (io.ReadCloser, error) {
+	data := io.NewSectionReader(file, e.offset, e.compressedSize)
+	if e.method == zip.Store {
+		return sectionReadCloser{io.NewSectionReader(file, e.offset, e.Size), file}, nil
+	}
+	fr := flate.NewReader(data)
+	return readCloser{
+		Reader: &checksumReader{r: io.LimitReader(fr, e.Size), hash: crc32.NewIEEE(), want: e.crc32, size: e.Size},
+		close: func() error {
+			fr.Close()
+			return file.Close()
+		},
+	}, nil
+}
+
+// checksumReader fails at the end of a decompressed zip entry that is
+// shorter than its size or does not match its CRC-32.
+type checksumReader struct {
+	r    io.Reader
+	hash hash.Hash32
+	want uint32
+	n    int64
+	size int64
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	c.n += int64(n)
+	if err == io.EOF {
+		switch {
+		case c.n != c.size:
+			err = io.ErrUnexpectedEOF
+		case c.hash.Sum32() != c.want:
+			err = zip.ErrChecksum
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,69 @@
+package bytesize
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size is a number of bytes. It implements flag.Value, accepting plain
+// numbers as well as the suffixes K, M, G and T (powers of 1024).
+type Size int64
+
+const (
+	KB Size = 1 << (10 * (iota + 1))
+	MB
+	GB
+	TB
+)
+
+var suffixes = []struct {
+	suffix string
+	size   Size
+}{
+	{"T", TB},
+	{"G", GB},
+	{"M", MB},
+	{"K", KB},
+}
+
+// Parse parses a size such as "512", "64K" or "2G".
+func Parse(v string) (Size, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	unit := Size(1)
+	for _, u := range suffixes {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			unit = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	if n > int64(^uint64(0)>>1)/int64(unit) {
+		return 0, fmt.Errorf("size %q too large", v)
+	}
+	return Size(n) * unit, nil
+}
+
+func (s Size) String() string {
+	for _, u := range suffixes {
+		if s != 0 && s%u.size == 0 {
+			return fmt.Sprintf("%d%s", s/u.size, u.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(s), 10)
+}
+
+// Set is flag.Value.Set
+func (s *Size) Set(v string) error {
+	size, err := Parse(v)
+	if err != nil {
+		return err
+	}
+	*s = size
+	return nil
+}
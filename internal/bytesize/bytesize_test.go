@@ -0,0 +1,52 @@
+package bytesize
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       string
+		want    Size
+		wantErr bool
+	}{
+		{name: "bytes", v: "512", want: 512},
+		{name: "kilobytes", v: "64K", want: 64 * KB},
+		{name: "megabytes", v: "10MB", want: 10 * MB},
+		{name: "gibibytes", v: "2GiB", want: 2 * GB},
+		{name: "lower case", v: "1t", want: TB},
+		{name: "negative", v: "-1", wantErr: true},
+		{name: "garbage", v: "lots", wantErr: true},
+		{name: "overflow", v: "9999999999T", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.v)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Parse() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSize_String(t *testing.T) {
+	tests := []struct {
+		name string
+		s    Size
+		want string
+	}{
+		{name: "zero", s: 0, want: "0"},
+		{name: "bytes", s: 1500, want: "1500"},
+		{name: "kilobytes", s: 3 * KB, want: "3K"},
+		{name: "gigabytes", s: 2 * GB, want: "2G"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s.String(); got != tt.want {
+				t.Errorf("Size.String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
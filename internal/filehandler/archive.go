@@ -0,0 +1,455 @@
+package filehandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sgreben/httpfileserver/internal/archive"
+	"github.com/sgreben/httpfileserver/internal/archivecache"
+	"github.com/sgreben/httpfileserver/internal/limiter"
+)
+
+type archiverFunc func(context.Context, io.Writer, string, archive.Options) error
+
+// Archive formats that can be offered by a route.
+const (
+	ArchiveFormatTarGz    = "tar.gz"
+	ArchiveFormatTar      = "tar"
+	ArchiveFormatZip      = "zip"
+	ArchiveFormatZipStore = "zip-store"
+)
+
+var (
+	errArchiveFormatDisabled = errors.New("archive format disabled")
+	errInvalidArchiveRequest = errors.New("invalid archive request")
+)
+
+// ArchiveFormats lists the archive formats offered by a route. It
+// implements flag.Value, accepting a comma-separated list of formats.
+type ArchiveFormats []string
+
+// DefaultArchiveFormats are offered by routes with no configured formats.
+var DefaultArchiveFormats = ArchiveFormats{ArchiveFormatTarGz, ArchiveFormatZip}
+
+func (a *ArchiveFormats) String() string {
+	return strings.Join(*a, ",")
+}
+
+// Set is flag.Value.Set
+func (a *ArchiveFormats) Set(v string) error {
+	formats := ArchiveFormats{}
+	for _, format := range strings.Split(v, ",") {
+		format = strings.TrimSpace(format)
+		switch format {
+		case "":
+		case ArchiveFormatTarGz, ArchiveFormatTar, ArchiveFormatZip, ArchiveFormatZipStore:
+			formats = append(formats, format)
+		default:
+			return fmt.Errorf("unknown archive format %q", format)
+		}
+	}
+	*a = formats
+	return nil
+}
+
+func (a ArchiveFormats) contains(format string) bool {
+	for _, f := range a {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveLink is an archive download offered in directory listings. Key
+// and Value are the query parameter selecting the format, empty if the
+// format is only reachable by its URL.
+type archiveLink struct {
+	Name  string
+	Label string
+	URL   *url.URL
+	Key   string
+	Value string
+}
+
+func (f *FileHandler) archiveFormats() ArchiveFormats {
+	if f.options.ArchiveFormats == nil {
+		return DefaultArchiveFormats
+	}
+	return f.options.ArchiveFormats
+}
+
+// archiveFormatEnabled reports whether the route offers format. Store-only
+// zip archives are also available as zip archives with level 0.
+func (f *FileHandler) archiveFormatEnabled(format string, opts archive.Options) bool {
+	formats := f.archiveFormats()
+	if format == ArchiveFormatZip && opts.Store && formats.contains(ArchiveFormatZipStore) {
+		return true
+	}
+	return formats.contains(format)
+}
+
+// archiveLinks returns the archive downloads offered in the listing of the
+// directory at u.
+func (f *FileHandler) archiveLinks(u url.URL) (out []archiveLink) {
+	for _, format := range f.archiveFormats() {
+		switch format {
+		case ArchiveFormatTarGz:
+			out = append(out, archiveLink{
+				Name:  ".tar.gz",
+				Label: ".tar.gz of all files",
+				URL:   getArchiveURL(u, tarGzKey, tarGzValue),
+				Key:   tarGzKey,
+				Value: tarGzValue,
+			})
+		case ArchiveFormatTar:
+			out = append(out, archiveLink{
+				Name:  ".tar",
+				Label: ".tar of all files",
+				URL:   getArchiveURL(u, tarKey, tarValue),
+				Key:   tarKey,
+				Value: tarValue,
+			})
+		case ArchiveFormatZip:
+			out = append(out, archiveLink{
+				Name:  ".zip",
+				Label: ".zip of all files",
+				URL:   getArchiveURL(u, zipKey, zipValue),
+				Key:   zipKey,
+				Value: zipValue,
+			})
+		case ArchiveFormatZipStore:
+			zipURL := getArchiveURL(u, zipKey, zipValue)
+			out = append(out, archiveLink{
+				Name:  ".zip (uncompressed)",
+				Label: ".zip of all files (uncompressed)",
+				URL:   getArchiveURL(*zipURL, levelKey, "0"),
+			})
+		}
+	}
+	return out
+}
+
+func (f *FileHandler) serveBusy(w http.ResponseWriter, r *http.Request) error {
+	retryAfter := int(math.Ceil(f.options.ArchiveRetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	return f.serveStatus(w, r, http.StatusServiceUnavailable)
+}
+
+// acquireArchiveSlot waits for a free slot in the route and global archive
+// limiters. The returned function releases both slots.
+func (f *FileHandler) acquireArchiveSlot(r *http.Request) (func(), error) {
+	route, global := f.options.RouteArchiveLimiter, f.options.ArchiveLimiter
+	if err := route.Acquire(r.Context()); err != nil {
+		return nil, err
+	}
+	if err := global.Acquire(r.Context()); err != nil {
+		route.Release()
+		return nil, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			global.Release()
+			route.Release()
+		})
+	}, nil
+}
+
+func (f *FileHandler) setArchiveContent(w http.ResponseWriter, r *http.Request, contentType, extension, path string) {
+	w.Header().Set("Content-Type", contentType)
+	name := filepath.Base(path) + extension
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename=%q`, name))
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// queryPatterns returns the patterns given in the query parameter key,
+// which may be repeated and may hold comma-separated lists.
+func queryPatterns(query url.Values, key string) (out []string) {
+	for _, v := range query[key] {
+		for _, pattern := range strings.Split(v, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				out = append(out, pattern)
+			}
+		}
+	}
+	return out
+}
+
+// archiveOptions returns the route's archive options, adjusted by the
+// request's query:
+//
+//	level=0 disables compression, level=1 to level=9 selects the level
+//	include=PATTERN restricts the archive to matching files
+//	exclude=PATTERN leaves out matching files and directories
+//	max-depth=N leaves out entries nested deeper than N levels
+func (f *FileHandler) archiveOptions(r *http.Request) (archive.Options, error) {
+	query := r.URL.Query()
+	opts := archive.Options{
+		SkipErrors: f.options.ArchiveSkipErrors,
+		Symlinks:   f.options.ArchiveSymlinks,
+		Level:      f.options.ArchiveLevel,
+		Include:    queryPatterns(query, includeKey),
+		Exclude:    append(queryPatterns(query, excludeKey), uploadTempPattern),
+
+		Reproducible:    f.options.ArchiveReproducible,
+		SourceDateEpoch: f.options.ArchiveSourceDateEpoch,
+		Checksums:       f.options.ArchiveChecksums,
+		Limits:          f.options.ArchiveLimits,
+		GzipWorkers:     f.options.ArchiveGzipWorkers,
+	}
+	if v := query.Get(levelKey); v != "" {
+		level, err := strconv.Atoi(v)
+		if err != nil || level < 0 || level > 9 {
+			return opts, fmt.Errorf("%w: level %q", errInvalidArchiveRequest, v)
+		}
+		opts.Level = level
+		opts.Store = level == 0
+	}
+	if v := query.Get(maxDepthKey); v != "" {
+		depth, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("%w: max depth %q", errInvalidArchiveRequest, v)
+		}
+		opts.MaxDepth = depth
+	}
+	if err := opts.Validate(); err != nil {
+		return opts, fmt.Errorf("%w: %v", errInvalidArchiveRequest, err)
+	}
+	return opts, nil
+}
+
+// writeArchive runs archiver on the request's context, logging archives cut
+// short because the client went away.
+//
+// Once part of the archive has been sent, an error can no longer be reported
+// with a status code. The connection is aborted instead, so that the client
+// sees a failed download rather than a truncated archive.
+func (f *FileHandler) writeArchive(w io.Writer, r *http.Request, osPath string, archiver archiverFunc, opts archive.Options) error {
+	cw := &countingWriter{w: w}
+	err := archiver(r.Context(), cw, osPath, opts)
+	if ctxErr := r.Context().Err(); ctxErr != nil {
+		log.Printf("[%s] %s archive of %q aborted after %d bytes: %v", f.path, r.RemoteAddr, osPath, cw.n, ctxErr)
+		return err
+	}
+	if err != nil && cw.n > 0 {
+		log.Printf("[%s] %s archive of %q failed after %d bytes: %v", f.path, r.RemoteAddr, osPath, cw.n, err)
+		panic(http.ErrAbortHandler)
+	}
+	return err
+}
+
+// selectedPaths returns the paths posted for an archive of selected files
+// in the directory at osPath, if any. Each must be a clean relative path naming an
+// existing file inside that directory.
+func (f *FileHandler) selectedPaths(w http.ResponseWriter, r *http.Request, osPath string) ([]string, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSelectionFormSize)
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidArchiveRequest, err)
+	}
+	var paths []string
+	for _, p := range r.PostForm[pathKey] {
+		p = strings.TrimSuffix(p, "/")
+		if p == "" || p == "." || path.IsAbs(p) || path.Clean(p) != p || p == ".." || strings.HasPrefix(p, "../") {
+			return nil, fmt.Errorf("%w: path %q", errInvalidArchiveRequest, p)
+		}
+		fullPath := filepath.Join(osPath, filepath.FromSlash(p))
+		if rel, err := filepath.Rel(osPath, fullPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+osPathSeparator) {
+			return nil, fmt.Errorf("%w: path %q", errInvalidArchiveRequest, p)
+		}
+		if _, err := os.Lstat(fullPath); err != nil {
+			return nil, fmt.Errorf("%w: path %q: %v", errInvalidArchiveRequest, p, err)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// serveArchive streams an archive of the file tree at osPath or, for POST
+// requests, of the selected entries of the directory at osPath. A password
+// posted along with the selection, or on its own for the whole tree,
+// encrypts a zip archive; it is never taken from the URL.
+func (f *FileHandler) serveArchive(w http.ResponseWriter, r *http.Request, osPath, format, contentType string, archiver archiverFunc) error {
+	opts, err := f.archiveOptions(r)
+	if err != nil {
+		return err
+	}
+	if r.Method == http.MethodPost {
+		if opts.Paths, err = f.selectedPaths(w, r, osPath); err != nil {
+			return err
+		}
+		opts.Password = r.PostForm.Get(passwordKey)
+		if len(opts.Paths) == 0 && opts.Password == "" {
+			return fmt.Errorf("%w: no paths selected", errInvalidArchiveRequest)
+		}
+		if opts.Password != "" && format != ArchiveFormatZip {
+			return fmt.Errorf("%w: only zip archives can be encrypted", errInvalidArchiveRequest)
+		}
+	}
+	if !f.archiveFormatEnabled(format, opts) {
+		return errArchiveFormatDisabled
+	}
+	if r.URL.Query().Get(splitKey) != "" {
+		return f.serveSplitArchive(w, r, osPath, format, archiver, opts)
+	}
+	// Fingerprinting and checking the limits walk the whole tree, so they
+	// wait for an archive slot like generating the archive does.
+	release, err := f.acquireArchiveSlot(r)
+	if err != nil {
+		return err
+	}
+	defer release()
+	var cacheKey string
+	// Encrypted archives are never cached: each is encrypted with a new
+	// salt, and neither it nor its password should be kept on disk.
+	if f.options.ArchiveCache != nil && opts.Password == "" {
+		fingerprint, err := archive.Fingerprint(r.Context(), osPath, opts)
+		if errors.Is(err, archive.ErrTooLarge) {
+			return err
+		}
+		if err != nil {
+			log.Printf("[%s] %s archive of %q not cached: %v", f.path, r.RemoteAddr, osPath, err)
+		} else {
+			cacheKey = archiveCacheKey(format, fingerprint)
+		}
+		if file, ok := f.options.ArchiveCache.Open(cacheKey); ok {
+			release()
+			defer file.Close()
+			f.setArchiveContent(w, r, contentType, "."+format, osPath)
+			return f.serveCachedArchive(w, r, file, archiveVersion(cacheKey, archivecache.Generation(file)))
+		}
+	}
+	if cacheKey == "" {
+		// The fingerprint walk has already checked the limits otherwise.
+		if err := archive.Check(r.Context(), osPath, opts); err != nil {
+			return err
+		}
+	}
+	f.setArchiveContent(w, r, contentType, "."+format, osPath)
+	if cacheKey != "" {
+		return f.writeCachedArchive(w, r, osPath, archiver, opts, cacheKey)
+	}
+	return f.writeArchive(w, r, osPath, archiver, opts)
+}
+
+// serveCachedArchive serves a previously generated archive like a regular
+// file, with Content-Length and support for range and conditional requests.
+func (f *FileHandler) serveCachedArchive(w http.ResponseWriter, r *http.Request, file *os.File, version string) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", strconv.Quote(version))
+	http.ServeContent(w, r, "", info.ModTime(), file)
+	return nil
+}
+
+// teeWriter writes to the client and, until its first error, to the
+// archive cache. Writes fail once writing to the client has failed.
+type teeWriter struct {
+	client    io.Writer
+	cache     io.Writer
+	n         int64
+	clientErr error
+	cacheErr  error
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	if t.cacheErr == nil {
+		_, t.cacheErr = t.cache.Write(p)
+	}
+	n, err := t.client.Write(p)
+	t.n += int64(n)
+	t.clientErr = err
+	return n, err
+}
+
+// writeCachedArchive streams a new archive to the client while storing it
+// in the archive cache. Generation stops if the client goes away, and the
+// partial archive is not cached.
+func (f *FileHandler) writeCachedArchive(w http.ResponseWriter, r *http.Request, osPath string, archiver archiverFunc, opts archive.Options, cacheKey string) error {
+	cacheWriter, err := f.options.ArchiveCache.Create(cacheKey)
+	if err != nil {
+		if err != archivecache.ErrInProgress {
+			log.Printf("[%s] %s archive of %q not cached: %v", f.path, r.RemoteAddr, osPath, err)
+		}
+		return f.writeArchive(w, r, osPath, archiver, opts)
+	}
+	w.Header().Set("ETag", strconv.Quote(archiveVersion(cacheKey, cacheWriter.Generation())))
+	tee := &teeWriter{client: w, cache: cacheWriter}
+	if err := archiver(r.Context(), tee, osPath, opts); err != nil {
+		cacheWriter.Abort()
+		if r.Context().Err() != nil || tee.clientErr != nil {
+			log.Printf("[%s] %s archive of %q aborted after %d bytes: %v", f.path, r.RemoteAddr, osPath, tee.n, err)
+			return err
+		}
+		if tee.n > 0 {
+			log.Printf("[%s] %s archive of %q failed after %d bytes: %v", f.path, r.RemoteAddr, osPath, tee.n, err)
+			panic(http.ErrAbortHandler)
+		}
+		return err
+	}
+	if tee.cacheErr != nil {
+		cacheWriter.Abort()
+		log.Printf("[%s] %s archive of %q not cached: %v", f.path, r.RemoteAddr, osPath, tee.cacheErr)
+	} else if err := cacheWriter.Commit(); err != nil {
+		log.Printf("[%s] %s archive of %q not cached: %v", f.path, r.RemoteAddr, osPath, err)
+	}
+	return nil
+}
+
+func (f *FileHandler) serveTarGz(w http.ResponseWriter, r *http.Request, osPath string) error {
+	return f.serveArchive(w, r, osPath, ArchiveFormatTarGz, tarGzContentType, f.tarGzArchiver)
+}
+
+func (f *FileHandler) serveTar(w http.ResponseWriter, r *http.Request, osPath string) error {
+	return f.serveArchive(w, r, osPath, ArchiveFormatTar, tarContentType, f.tarArchiver)
+}
+
+func (f *FileHandler) serveZip(w http.ResponseWriter, r *http.Request, osPath string) error {
+	return f.serveArchive(w, r, osPath, ArchiveFormatZip, zipContentType, f.zipArchiver)
+}
+
+func (f *FileHandler) serveArchiveError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == nil:
+	case err == limiter.ErrBusy:
+		log.Printf("[%s] %s archive refused: %v", f.path, r.RemoteAddr, err)
+		_ = f.serveBusy(w, r)
+	case err == errArchiveFormatDisabled, err == errArchiveSplitDisabled:
+		_ = f.serveStatus(w, r, http.StatusNotFound)
+	case errors.Is(err, errInvalidArchiveRequest):
+		log.Printf("[%s] %s %v", f.path, r.RemoteAddr, err)
+		_ = f.serveStatus(w, r, http.StatusBadRequest)
+	case errors.Is(err, archive.ErrTooLarge):
+		log.Printf("[%s] %s archive refused: %v", f.path, r.RemoteAddr, err)
+		_ = f.serveStatus(w, r, http.StatusRequestEntityTooLarge)
+	default:
+		_ = f.serveStatus(w, r, http.StatusInternalServerError)
+	}
+}
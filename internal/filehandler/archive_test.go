@@ -0,0 +1,606 @@
+package filehandler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgreben/httpfileserver/internal/archive"
+	"github.com/sgreben/httpfileserver/internal/archivecache"
+)
+
+func TestArchiveFormats_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       string
+		want    ArchiveFormats
+		wantErr bool
+	}{
+		{
+			name: "all formats",
+			v:    "tar.gz, tar,zip,zip-store",
+			want: ArchiveFormats{ArchiveFormatTarGz, ArchiveFormatTar, ArchiveFormatZip, ArchiveFormatZipStore},
+		},
+		{
+			name: "none",
+			v:    "",
+			want: ArchiveFormats{},
+		},
+		{
+			name:    "unknown format",
+			v:       "zip,rar",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ArchiveFormats
+			if err := got.Set(tt.v); (err != nil) != tt.wantErr {
+				t.Errorf("ArchiveFormats.Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ArchiveFormats.Set() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fileHandler_archiveOptions(t *testing.T) {
+	hidden := []string{uploadTempPattern}
+	tests := []struct {
+		name    string
+		options Options
+		query   string
+		want    archive.Options
+		wantErr bool
+	}{
+		{
+			name:    "route default",
+			options: Options{ArchiveLevel: 6},
+			query:   "",
+			want:    archive.Options{Level: 6, Exclude: hidden},
+		},
+		{
+			name:    "level",
+			options: Options{ArchiveLevel: 6},
+			query:   "?level=9",
+			want:    archive.Options{Level: 9, Exclude: hidden},
+		},
+		{
+			name:  "store",
+			query: "?level=0",
+			want:  archive.Options{Store: true, Exclude: hidden},
+		},
+		{
+			name:    "reproducible",
+			options: Options{ArchiveReproducible: true, ArchiveSourceDateEpoch: time.Unix(1500000000, 0)},
+			query:   "",
+			want:    archive.Options{Reproducible: true, SourceDateEpoch: time.Unix(1500000000, 0), Exclude: hidden},
+		},
+		{
+			name:    "checksums",
+			options: Options{ArchiveChecksums: true},
+			query:   "",
+			want:    archive.Options{Checksums: true, Exclude: hidden},
+		},
+		{
+			name:    "gzip workers",
+			options: Options{ArchiveGzipWorkers: 4},
+			query:   "",
+			want:    archive.Options{GzipWorkers: 4, Exclude: hidden},
+		},
+		{
+			name:    "out of range",
+			query:   "?level=10",
+			wantErr: true,
+		},
+		{
+			name:    "not a number",
+			query:   "?level=best",
+			wantErr: true,
+		},
+		{
+			name:  "filters",
+			query: "?include=*.log,*.txt&include=&exclude=node_modules&max-depth=2",
+			want: archive.Options{
+				Include:  []string{"*.log", "*.txt"},
+				Exclude:  []string{"node_modules", uploadTempPattern},
+				MaxDepth: 2,
+			},
+		},
+		{
+			name:    "bad pattern",
+			query:   "?exclude=%5B",
+			wantErr: true,
+		},
+		{
+			name:    "bad max depth",
+			query:   "?max-depth=-1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &FileHandler{options: tt.options}
+			got, err := f.archiveOptions(httptest.NewRequest(http.MethodGet, "/"+tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fileHandler.archiveOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fileHandler.archiveOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_fileHandler_archiveLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		formats ArchiveFormats
+		want    []string
+	}{
+		{
+			name:    "default",
+			formats: nil,
+			want:    []string{"/dir/?tar.gz=true", "/dir/?zip=true"},
+		},
+		{
+			name:    "configured",
+			formats: ArchiveFormats{ArchiveFormatTar, ArchiveFormatZipStore},
+			want:    []string{"/dir/?tar=true", "/dir/?level=0&zip=true"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &FileHandler{options: Options{ArchiveFormats: tt.formats}}
+			var got []string
+			for _, link := range f.archiveLinks(url.URL{Path: "/dir/"}) {
+				got = append(got, link.URL.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fileHandler.archiveLinks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileHandler_ServeHTTP_archiveFormats(t *testing.T) {
+	tests := []struct {
+		name            string
+		formats         ArchiveFormats
+		query           string
+		wantStatus      int
+		wantContentType string
+	}{
+		{
+			name:            "tar",
+			formats:         ArchiveFormats{ArchiveFormatTar},
+			query:           "?tar=true",
+			wantStatus:      http.StatusOK,
+			wantContentType: tarContentType,
+		},
+		{
+			name:       "disabled format",
+			formats:    ArchiveFormats{ArchiveFormatTar},
+			query:      "?zip=true",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:            "store-only zip",
+			formats:         ArchiveFormats{ArchiveFormatZipStore},
+			query:           "?zip=true&level=0",
+			wantStatus:      http.StatusOK,
+			wantContentType: zipContentType,
+		},
+		{
+			name:       "compressed zip with store-only zip",
+			formats:    ArchiveFormats{ArchiveFormatZipStore},
+			query:      "?zip=true&level=9",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "invalid level",
+			formats:    nil,
+			query:      "?tar.gz=true&level=11",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFileHandler("/", ".", false, Options{ArchiveFormats: tt.formats})
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+tt.query, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("fileHandler.ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantContentType != "" && w.Header().Get("Content-Type") != tt.wantContentType {
+				t.Errorf("fileHandler.ServeHTTP() content type = %q, want %q", w.Header().Get("Content-Type"), tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestFileHandler_ServeHTTP_selection(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "sub/c.txt"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		paths      []string
+		wantStatus int
+		want       []string
+	}{
+		{
+			name:       "files and directories",
+			paths:      []string{"a.txt", "sub/"},
+			wantStatus: http.StatusOK,
+			want:       []string{"a.txt", "sub/", "sub/c.txt"},
+		},
+		{
+			name:       "nothing selected",
+			paths:      nil,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "outside the directory",
+			paths:      []string{"../a.txt"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unclean path",
+			paths:      []string{"sub/../a.txt"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "absolute path",
+			paths:      []string{"/etc/passwd"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "missing file",
+			paths:      []string{"missing.txt"},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFileHandler("/", dir, false, Options{})
+			form := url.Values{pathKey: tt.paths}
+			r := httptest.NewRequest(http.MethodPost, "/?zip=true", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("fileHandler.ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			if err != nil {
+				t.Fatalf("fileHandler.ServeHTTP() invalid zip: %v", err)
+			}
+			var got []string
+			for _, f := range zr.File {
+				got = append(got, f.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fileHandler.ServeHTTP() entries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileHandler_ServeHTTP_archiveCache(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := archivecache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFileHandler("/", dir, false, Options{ArchiveCache: cache})
+
+	first := httptest.NewRecorder()
+	f.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/?tar.gz=true", nil))
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("fileHandler.ServeHTTP() first status = %d, ETag = %q", first.Code, etag)
+	}
+
+	second := httptest.NewRecorder()
+	f.ServeHTTP(second, httptest.NewRequest(http.MethodGet, "/?tar.gz=true", nil))
+	if !bytes.Equal(second.Body.Bytes(), first.Body.Bytes()) {
+		t.Errorf("fileHandler.ServeHTTP() cached archive differs from generated one")
+	}
+	if got, want := second.Header().Get("Content-Length"), strconv.Itoa(first.Body.Len()); got != want {
+		t.Errorf("fileHandler.ServeHTTP() cached Content-Length = %q, want %q", got, want)
+	}
+	if got := second.Header().Get("ETag"); got != etag {
+		t.Errorf("fileHandler.ServeHTTP() cached ETag = %q, want %q", got, etag)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/?tar.gz=true", nil)
+	r.Header.Set("Range", "bytes=10-")
+	r.Header.Set("If-Range", etag)
+	resumed := httptest.NewRecorder()
+	f.ServeHTTP(resumed, r)
+	if resumed.Code != http.StatusPartialContent {
+		t.Fatalf("fileHandler.ServeHTTP() resumed status = %d, want %d", resumed.Code, http.StatusPartialContent)
+	}
+	if !bytes.Equal(resumed.Body.Bytes(), first.Body.Bytes()[10:]) {
+		t.Errorf("fileHandler.ServeHTTP() resumed body differs")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed := httptest.NewRecorder()
+	f.ServeHTTP(changed, httptest.NewRequest(http.MethodGet, "/?tar.gz=true", nil))
+	if got := changed.Header().Get("ETag"); got == etag {
+		t.Errorf("fileHandler.ServeHTTP() ETag unchanged after the tree changed")
+	}
+}
+
+func TestFileHandler_ServeHTTP_archiveCacheRegenerated(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := archivecache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFileHandler("/", dir, false, Options{ArchiveCache: cache})
+
+	first := httptest.NewRecorder()
+	f.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/?tar.gz=true", nil))
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("fileHandler.ServeHTTP() first status = %d, ETag = %q", first.Code, etag)
+	}
+
+	// evict the archive by filling the cache with another one
+	w, err := cache.Create("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/?tar.gz=true", nil)
+	r.Header.Set("Range", "bytes=10-")
+	r.Header.Set("If-Range", etag)
+	regenerated := httptest.NewRecorder()
+	f.ServeHTTP(regenerated, r)
+	if regenerated.Code != http.StatusOK {
+		t.Errorf("fileHandler.ServeHTTP() regenerated status = %d, want %d", regenerated.Code, http.StatusOK)
+	}
+	if got := regenerated.Header().Get("ETag"); got == "" || got == etag {
+		t.Errorf("fileHandler.ServeHTTP() regenerated ETag = %q, first %q", got, etag)
+	}
+}
+
+func TestFileHandler_ServeHTTP_archiveCacheTooSmall(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), bytes.Repeat([]byte("content"), 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := t.TempDir()
+	cache, err := archivecache.New(cacheDir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	f := NewFileHandler("/", dir, false, Options{ArchiveCache: cache, ArchiveFormats: ArchiveFormats{ArchiveFormatTar}})
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?tar=true", nil))
+	if w.Code != http.StatusOK || w.Body.Len() < 7000 {
+		t.Fatalf("fileHandler.ServeHTTP() status = %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if entries, err := os.ReadDir(cacheDir); err != nil || len(entries) != 0 {
+		t.Errorf("archive cache holds %v, %v", entries, err)
+	}
+}
+
+func TestFileHandler_ServeHTTP_archiveCacheClientGone(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		w    http.ResponseWriter
+		r    *http.Request
+	}{
+		{
+			name: "canceled request",
+			w:    httptest.NewRecorder(),
+			r:    httptest.NewRequest(http.MethodGet, "/?tar=true", nil).WithContext(canceled),
+		},
+		{
+			name: "failing client",
+			w:    testResponseWriter{writeErr: errors.New("connection reset")},
+			r:    httptest.NewRequest(http.MethodGet, "/?tar=true", nil),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "a.txt"), bytes.Repeat([]byte("content"), 1000), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cacheDir := t.TempDir()
+			cache, err := archivecache.New(cacheDir, 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var calls int
+			f := NewFileHandler("/", dir, false, Options{ArchiveCache: cache, ArchiveFormats: ArchiveFormats{ArchiveFormatTar}})
+			f.tarArchiver = func(ctx context.Context, w io.Writer, osPath string, opts archive.Options) error {
+				for ; ctx.Err() == nil && calls < 100; calls++ {
+					if _, err := w.Write([]byte("data")); err != nil {
+						return err
+					}
+				}
+				return ctx.Err()
+			}
+			f.ServeHTTP(tt.w, tt.r)
+			if calls > 1 {
+				t.Errorf("archive written %d times after the client went away", calls)
+			}
+			if entries, err := os.ReadDir(cacheDir); err != nil || len(entries) != 0 {
+				t.Errorf("archive cache holds %v, %v", entries, err)
+			}
+		})
+	}
+}
+
+func TestFileHandler_ServeHTTP_archiveLimits(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache, err := archivecache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		options    Options
+		wantStatus int
+	}{
+		{
+			name:       "within limits",
+			options:    Options{ArchiveLimits: archive.Limits{Bytes: 14, Files: 2}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "too many files",
+			options:    Options{ArchiveLimits: archive.Limits{Files: 1}},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "too many bytes",
+			options:    Options{ArchiveLimits: archive.Limits{Bytes: 10}},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "too many files with cache",
+			options:    Options{ArchiveLimits: archive.Limits{Files: 1}, ArchiveCache: cache},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFileHandler("/", dir, false, tt.options)
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?zip=true", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("fileHandler.ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestFileHandler_ServeHTTP_encryptedZip(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := t.TempDir()
+	cache, err := archivecache.New(cacheDir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		target        string
+		form          url.Values
+		wantStatus    int
+		wantEncrypted bool
+	}{
+		{
+			name:          "whole directory",
+			method:        http.MethodPost,
+			target:        "/?zip=true",
+			form:          url.Values{passwordKey: {"secret"}},
+			wantStatus:    http.StatusOK,
+			wantEncrypted: true,
+		},
+		{
+			name:          "selection",
+			method:        http.MethodPost,
+			target:        "/?zip=true",
+			form:          url.Values{passwordKey: {"secret"}, pathKey: {"a.txt"}},
+			wantStatus:    http.StatusOK,
+			wantEncrypted: true,
+		},
+		{
+			name:       "tar.gz",
+			method:     http.MethodPost,
+			target:     "/?tar.gz=true",
+			form:       url.Values{passwordKey: {"secret"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:          "password in the URL",
+			method:        http.MethodGet,
+			target:        "/?zip=true&password=secret",
+			wantStatus:    http.StatusOK,
+			wantEncrypted: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFileHandler("/", dir, false, Options{ArchiveCache: cache})
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("fileHandler.ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			if err != nil {
+				t.Fatalf("fileHandler.ServeHTTP() invalid zip: %v", err)
+			}
+			if encrypted := zr.File[0].Flags&0x1 != 0; encrypted != tt.wantEncrypted {
+				t.Errorf("fileHandler.ServeHTTP() encrypted = %v, want %v", encrypted, tt.wantEncrypted)
+			}
+			if tt.wantEncrypted && w.Header().Get("ETag") != "" {
+				t.Errorf("fileHandler.ServeHTTP() cached an encrypted archive")
+			}
+		})
+	}
+}
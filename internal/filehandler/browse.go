@@ -0,0 +1,187 @@
+package filehandler
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sgreben/httpfileserver/internal/archivefs"
+)
+
+// browsedArchive finds the archive file a request browses into: osPath
+// itself if the URL names an archive with a trailing slash, or the nearest
+// archive among the parents of osPath if osPath does not exist. It returns
+// the archive's path and the slash-separated member path inside it.
+func (f *FileHandler) browsedArchive(r *http.Request, osPath string, info os.FileInfo, statErr error) (archivePath, member string, ok bool) {
+	if !f.options.BrowseArchives {
+		return "", "", false
+	}
+	if statErr == nil {
+		if info.Mode().IsRegular() && strings.HasSuffix(r.URL.Path, "/") && archivefs.DetectFormat(osPath) != "" {
+			return osPath, "", true
+		}
+		return "", "", false
+	}
+	root := filepath.Clean(f.path)
+	for p := osPath; p != root; {
+		parent := filepath.Dir(p)
+		if parent == p {
+			break
+		}
+		p = parent
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if info.Mode().IsRegular() && archivefs.DetectFormat(p) != "" {
+			rel, err := filepath.Rel(p, osPath)
+			if err != nil {
+				break
+			}
+			return p, filepath.ToSlash(rel), true
+		}
+		break
+	}
+	return "", "", false
+}
+
+// serveArchiveMember lists a directory inside an archive or streams one of
+// its files.
+func (f *FileHandler) serveArchiveMember(w http.ResponseWriter, r *http.Request, archivePath, member string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		_ = f.serveStatus(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	// Indexing a tar.gz file, and reading entries that are not stored in
+	// place, decompress as much as generating an archive does, so they wait
+	// for an archive slot too.
+	release := func() {}
+	defer func() { release() }()
+	if !f.options.ArchiveIndexCache.Has(archivePath) {
+		slot, err := f.acquireArchiveSlot(r)
+		if err != nil {
+			f.serveArchiveError(w, r, err)
+			return
+		}
+		release = slot
+	}
+	ix, err := f.options.ArchiveIndexCache.Index(archivePath)
+	release()
+	if err != nil {
+		log.Printf("[%s] %s: %v", f.path, archivePath, err)
+		_ = f.serveStatus(w, r, http.StatusUnprocessableEntity)
+		return
+	}
+	e, ok := ix.Lookup(member)
+	if !ok {
+		_ = f.serveStatus(w, r, http.StatusNotFound)
+		return
+	}
+	trailingSlash := strings.HasSuffix(r.URL.Path, "/")
+	switch {
+	case e.IsDir() && !trailingSlash:
+		u := *r.URL
+		u.Path += "/"
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	case e.IsDir():
+		err := f.serveArchiveDir(w, r, archivePath, ix, e)
+		if err != nil {
+			_ = f.serveStatus(w, r, http.StatusInternalServerError)
+		}
+	case trailingSlash:
+		_ = f.serveStatus(w, r, http.StatusNotFound)
+	case e.Compressed():
+		slot, err := f.acquireArchiveSlot(r)
+		if err != nil {
+			f.serveArchiveError(w, r, err)
+			return
+		}
+		release = slot
+		f.serveArchiveFile(w, r, ix, e)
+	default:
+		f.serveArchiveFile(w, r, ix, e)
+	}
+}
+
+func (f *FileHandler) serveArchiveDir(w http.ResponseWriter, r *http.Request, archivePath string, ix *archivefs.Index, dir *archivefs.Entry) error {
+	entries := append([]*archivefs.Entry(nil), ix.ReadDir(dir.Name)...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].IsDir() && !entries[j].IsDir()
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return directoryListingTemplate.Execute(w, directoryListingData{
+		Title: func() string {
+			relPath, _ := filepath.Rel(f.path, archivePath)
+			return path.Join(filepath.ToSlash(filepath.Join(filepath.Base(f.path), relPath)), dir.Name)
+		}(),
+		Files: func() (out []directoryListingFileData) {
+			for _, e := range entries {
+				name := e.Base()
+				if e.IsDir() {
+					name += "/"
+				}
+				out = append(out, directoryListingFileData{
+					Path:  e.Base(),
+					Name:  name,
+					IsDir: e.IsDir(),
+					Size:  fileSizeBytes(e.Size),
+					URL: func() *url.URL {
+						url := *r.URL
+						url.RawQuery = ""
+						url.Path = path.Join(url.Path, name)
+						if e.IsDir() {
+							url.Path += "/"
+						}
+						return &url
+					}(),
+				})
+			}
+			return out
+		}(),
+	})
+}
+
+// serveArchiveFile streams a file inside an archive, with range requests
+// for files stored uncompressed.
+func (f *FileHandler) serveArchiveFile(w http.ResponseWriter, r *http.Request, ix *archivefs.Index, e *archivefs.Entry) {
+	rc, err := ix.Open(e)
+	if errors.Is(err, archivefs.ErrUnsupported) {
+		_ = f.serveStatus(w, r, http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] %s: %v", f.path, e.Name, err)
+		_ = f.serveStatus(w, r, http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, e.Base(), e.ModTime, rs)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(e.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(e.Size, 10))
+	if !e.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", e.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Printf("[%s] %s: %v", f.path, e.Name, err)
+	}
+}
@@ -0,0 +1,173 @@
+package filehandler
+
+import (
+	stdzip "archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sgreben/httpfileserver/internal/archivefs"
+	"github.com/sgreben/httpfileserver/internal/limiter"
+)
+
+func TestFileHandler_ServeHTTP_browseArchive(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	zw := stdzip.NewWriter(&buf)
+	for name, method := range map[string]uint16{"docs/readme.txt": stdzip.Store, "docs/big.txt": stdzip.Deflate} {
+		w, err := zw.CreateHeader(&stdzip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("contents of " + name))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "release.zip"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := archivefs.NewCache(64)
+	f := NewFileHandler("/", dir, false, Options{BrowseArchives: true, ArchiveIndexCache: cache})
+	full := limiter.New("test-browse-busy", 1, 0, 0)
+	if err := full.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer full.Release()
+	busy := NewFileHandler("/", dir, false, Options{BrowseArchives: true, ArchiveIndexCache: cache, ArchiveLimiter: full})
+
+	tests := []struct {
+		name       string
+		handler    *FileHandler
+		method     string
+		target     string
+		header     http.Header
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "archive itself",
+			target:     "/release.zip",
+			wantStatus: http.StatusOK,
+			wantBody:   "PK",
+		},
+		{
+			name:       "archive root",
+			target:     "/release.zip/",
+			wantStatus: http.StatusOK,
+			wantBody:   `<a href="/release.zip/docs/">docs/`,
+		},
+		{
+			name:       "directory",
+			target:     "/release.zip/docs/",
+			wantStatus: http.StatusOK,
+			wantBody:   `<a href="/release.zip/docs/readme.txt">readme.txt`,
+		},
+		{
+			name:       "directory without slash",
+			target:     "/release.zip/docs",
+			wantStatus: http.StatusMovedPermanently,
+		},
+		{
+			name:       "stored file",
+			target:     "/release.zip/docs/readme.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   "contents of docs/readme.txt",
+		},
+		{
+			name:       "stored file range",
+			target:     "/release.zip/docs/readme.txt",
+			header:     http.Header{"Range": {"bytes=12-"}},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "docs/readme.txt",
+		},
+		{
+			name:       "compressed file",
+			target:     "/release.zip/docs/big.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   "contents of docs/big.txt",
+		},
+		{
+			name:       "missing member",
+			target:     "/release.zip/docs/missing.txt",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "file with slash",
+			target:     "/release.zip/docs/readme.txt/",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "post",
+			method:     http.MethodPost,
+			target:     "/release.zip/docs/",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "stored file while archives are busy",
+			handler:    busy,
+			target:     "/release.zip/docs/readme.txt",
+			wantStatus: http.StatusOK,
+			wantBody:   "contents of docs/readme.txt",
+		},
+		{
+			name:       "compressed file while archives are busy",
+			handler:    busy,
+			target:     "/release.zip/docs/big.txt",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "uncached index while archives are busy",
+			handler:    NewFileHandler("/", dir, false, Options{BrowseArchives: true, ArchiveIndexCache: archivefs.NewCache(64), ArchiveLimiter: full}),
+			target:     "/release.zip/docs/",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "browsing disabled",
+			handler:    NewFileHandler("/", dir, false, Options{}),
+			target:     "/release.zip/docs/readme.txt",
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, method := tt.handler, tt.method
+			if handler == nil {
+				handler = f
+			}
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, tt.target, nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("fileHandler.ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("fileHandler.ServeHTTP() body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestFileHandler_ServeHTTP_browseBrokenArchive(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.tar.gz"), []byte("not gzip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFileHandler("/", dir, false, Options{BrowseArchives: true})
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/broken.tar.gz/", nil))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("fileHandler.ServeHTTP() status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,105 @@
+package filehandler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxRenameAttempts bounds the numbered names tried by ConflictRename.
+const maxRenameAttempts = 10000
+
+// ConflictPolicy decides what happens to an upload whose name is already
+// taken. It implements flag.Value.
+type ConflictPolicy string
+
+const (
+	// ConflictOverwrite atomically replaces the existing file. It is the
+	// default.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictReject refuses the upload with 409 Conflict.
+	ConflictReject ConflictPolicy = "reject"
+	// ConflictRename stores the upload under the first free name of the
+	// form "name (1).ext".
+	ConflictRename ConflictPolicy = "rename"
+)
+
+func (p ConflictPolicy) String() string {
+	if p == "" {
+		return string(ConflictOverwrite)
+	}
+	return string(p)
+}
+
+// Set is flag.Value.Set
+func (p *ConflictPolicy) Set(v string) error {
+	switch policy := ConflictPolicy(v); policy {
+	case ConflictOverwrite, ConflictReject, ConflictRename:
+		*p = policy
+		return nil
+	default:
+		return fmt.Errorf("unknown conflict policy %q, want one of %q, %q or %q", v, ConflictOverwrite, ConflictReject, ConflictRename)
+	}
+}
+
+// commit moves the complete upload tmp to name in dir, returning the name
+// it was stored under.
+func (p ConflictPolicy) commit(tmp, dir, name string) (string, error) {
+	switch p {
+	case ConflictReject:
+		err := linkNoReplace(tmp, filepath.Join(dir, name))
+		if os.IsExist(err) {
+			return "", fmt.Errorf("%w: %s exists", errUploadConflict, name)
+		}
+		return name, err
+	case ConflictRename:
+		for i := 0; i < maxRenameAttempts; i++ {
+			candidate := numberedName(name, i)
+			err := linkNoReplace(tmp, filepath.Join(dir, candidate))
+			if !os.IsExist(err) {
+				return candidate, err
+			}
+		}
+		return "", fmt.Errorf("%w: no free name for %s", errUploadConflict, name)
+	default:
+		dst := filepath.Join(dir, name)
+		if info, err := os.Lstat(dst); err == nil && info.IsDir() {
+			return "", fmt.Errorf("%w: %s is a directory", errUploadConflict, name)
+		}
+		return name, os.Rename(tmp, dst)
+	}
+}
+
+// linkNoReplace makes dst a name of the file tmp, failing with an error
+// satisfying os.IsExist if dst exists. Hard links make the check atomic;
+// where they are not supported, dst is checked before renaming.
+func linkNoReplace(tmp, dst string) error {
+	err := os.Link(tmp, dst)
+	if err == nil || os.IsExist(err) {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return os.ErrExist
+	}
+	return os.Rename(tmp, dst)
+}
+
+// numberedName returns name for i == 0 and "base (i).ext" otherwise,
+// keeping compound extensions such as .tar.gz and names of dotfiles
+// intact.
+func numberedName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if inner := filepath.Ext(base); inner == ".tar" {
+		ext = inner + ext
+		base = strings.TrimSuffix(base, inner)
+	}
+	if base == "" {
+		base, ext = name, ""
+	}
+	return fmt.Sprintf("%s (%d)%s", base, i, ext)
+}
@@ -0,0 +1,202 @@
+package filehandler
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	// uploadChecksumKey is the form field holding the digest of the file
+	// sent in the next part of a multipart upload.
+	uploadChecksumKey     = "checksum"
+	maxUploadChecksumSize = 1 << 10
+
+	digestSHA256 = "sha-256"
+)
+
+var errDigestMismatch = fmt.Errorf("%w: digest mismatch", errInvalidUpload)
+
+// digestAlgorithms are the digest algorithms uploads can be verified with,
+// by the names used in Digest and Content-Digest headers.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"md5":        md5.New,
+	digestSHA256: sha256.New,
+	"sha-512":    sha512.New,
+}
+
+// uploadDigest is a digest of an uploaded file sent by the client.
+type uploadDigest struct {
+	algorithm string
+	sum       []byte
+}
+
+// digestAlgorithm returns the name of the digest algorithm called name, or
+// "" if it is not supported. The dash may be left out, as in "sha256".
+func digestAlgorithm(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if strings.HasPrefix(name, "sha") && !strings.HasPrefix(name, "sha-") {
+		name = "sha-" + strings.TrimPrefix(name, "sha")
+	}
+	if _, ok := digestAlgorithms[name]; !ok {
+		return ""
+	}
+	return name
+}
+
+func newUploadDigest(algorithm string, sum []byte) (uploadDigest, error) {
+	if len(sum) != digestAlgorithms[algorithm]().Size() {
+		return uploadDigest{}, fmt.Errorf("%w: %s digest of %d bytes", errInvalidUpload, algorithm, len(sum))
+	}
+	return uploadDigest{algorithm: algorithm, sum: sum}, nil
+}
+
+// parseDigestHeaders returns the digests of an uploaded file sent in the
+// Content-MD5, Digest (RFC 3230), Content-Digest and Repr-Digest (RFC 9530)
+// headers h. Digests of unsupported algorithms are ignored.
+func parseDigestHeaders(h http.Header) ([]uploadDigest, error) {
+	var digests []uploadDigest
+	if v := h.Get("Content-MD5"); v != "" {
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("%w: Content-MD5 %q", errInvalidUpload, v)
+		}
+		d, err := newUploadDigest("md5", sum)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, d)
+	}
+	for _, key := range []string{"Digest", "Content-Digest", "Repr-Digest"} {
+		for _, v := range h.Values(key) {
+			for _, item := range strings.Split(v, ",") {
+				i := strings.Index(item, "=")
+				if i < 0 {
+					return nil, fmt.Errorf("%w: %s %q", errInvalidUpload, key, item)
+				}
+				algorithm := digestAlgorithm(item[:i])
+				if algorithm == "" {
+					continue
+				}
+				// RFC 9530 wraps the base64 value in colons and may add
+				// parameters
+				value := strings.TrimSpace(item[i+1:])
+				if j := strings.Index(value, ";"); j >= 0 && key != "Digest" {
+					value = value[:j]
+				}
+				sum, err := base64.StdEncoding.DecodeString(strings.Trim(value, ":"))
+				if err != nil {
+					return nil, fmt.Errorf("%w: %s %q", errInvalidUpload, key, item)
+				}
+				d, err := newUploadDigest(algorithm, sum)
+				if err != nil {
+					return nil, err
+				}
+				digests = append(digests, d)
+			}
+		}
+	}
+	return digests, nil
+}
+
+// parseChecksum parses the value of a checksum form field: a hex digest,
+// optionally prefixed by its algorithm and a colon as in "sha256:9f86d0...".
+// Without a prefix, the algorithm is told by the digest's length. Anything
+// after the digest, such as the file name in sha256sum output, is ignored.
+func parseChecksum(value string) (uploadDigest, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return uploadDigest{}, fmt.Errorf("%w: empty %s", errInvalidUpload, uploadChecksumKey)
+	}
+	value = fields[0]
+	algorithm, digest := "", value
+	if i := strings.Index(value, ":"); i >= 0 {
+		if algorithm = digestAlgorithm(value[:i]); algorithm == "" {
+			return uploadDigest{}, fmt.Errorf("%w: unsupported %s algorithm %q", errInvalidUpload, uploadChecksumKey, value[:i])
+		}
+		digest = value[i+1:]
+	}
+	sum, err := hex.DecodeString(digest)
+	if err != nil {
+		return uploadDigest{}, fmt.Errorf("%w: %s %q", errInvalidUpload, uploadChecksumKey, value)
+	}
+	if algorithm == "" {
+		for name, h := range digestAlgorithms {
+			if h().Size() == len(sum) {
+				algorithm = name
+			}
+		}
+		if algorithm == "" {
+			return uploadDigest{}, fmt.Errorf("%w: %s %q", errInvalidUpload, uploadChecksumKey, value)
+		}
+	}
+	return newUploadDigest(algorithm, sum)
+}
+
+// readChecksumField reads the checksum form field r.
+func readChecksumField(r io.Reader) (uploadDigest, error) {
+	value, err := ioutil.ReadAll(io.LimitReader(r, maxUploadChecksumSize))
+	if err != nil {
+		return uploadDigest{}, err
+	}
+	return parseChecksum(string(value))
+}
+
+// digestVerifier hashes an uploaded file as it is written, always with
+// SHA-256 and also with the algorithms of the digests the client sent.
+type digestVerifier struct {
+	want   []uploadDigest
+	hashes map[string]hash.Hash
+}
+
+func newDigestVerifier(want []uploadDigest) *digestVerifier {
+	v := &digestVerifier{want: want, hashes: map[string]hash.Hash{digestSHA256: sha256.New()}}
+	for _, d := range want {
+		if v.hashes[d.algorithm] == nil {
+			v.hashes[d.algorithm] = digestAlgorithms[d.algorithm]()
+		}
+	}
+	return v
+}
+
+func (v *digestVerifier) Write(p []byte) (int, error) {
+	for _, h := range v.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// verify checks the data written against the digests the client sent.
+func (v *digestVerifier) verify(name string) error {
+	for _, d := range v.want {
+		if sum := v.hashes[d.algorithm].Sum(nil); !bytes.Equal(sum, d.sum) {
+			return fmt.Errorf("%w: %s: %s sent %x, computed %x", errDigestMismatch, name, d.algorithm, d.sum, sum)
+		}
+	}
+	return nil
+}
+
+// sha256 returns the hex SHA-256 digest of the data written.
+func (v *digestVerifier) sha256() string {
+	return hex.EncodeToString(v.hashes[digestSHA256].Sum(nil))
+}
+
+// header returns a Repr-Digest header value listing the digests computed.
+func (v *digestVerifier) header() string {
+	var items []string
+	for _, algorithm := range []string{"md5", digestSHA256, "sha-512"} {
+		if h := v.hashes[algorithm]; h != nil {
+			items = append(items, algorithm+"=:"+base64.StdEncoding.EncodeToString(h.Sum(nil))+":")
+		}
+	}
+	return strings.Join(items, ", ")
+}
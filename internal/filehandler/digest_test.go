@@ -0,0 +1,99 @@
+package filehandler
+
+import (
+	"encoding/hex"
+	"net/http"
+	"testing"
+)
+
+func Test_parseChecksum(t *testing.T) {
+	const sha256Alpha = "8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8"
+	tests := []struct {
+		name          string
+		value         string
+		wantAlgorithm string
+		wantErr       bool
+	}{
+		{name: "prefixed", value: "sha256:" + sha256Alpha, wantAlgorithm: "sha-256"},
+		{name: "prefixed with dash", value: "SHA-256:" + sha256Alpha, wantAlgorithm: "sha-256"},
+		{name: "by length", value: sha256Alpha, wantAlgorithm: "sha-256"},
+		{name: "md5 by length", value: "2c1743a391305fbf367df8e4f069f9f9", wantAlgorithm: "md5"},
+		{name: "sha256sum output", value: sha256Alpha + "  a.txt\n", wantAlgorithm: "sha-256"},
+		{name: "wrong length", value: "md5:" + sha256Alpha, wantErr: true},
+		{name: "unknown length", value: "cafe", wantErr: true},
+		{name: "unsupported algorithm", value: "crc32:cafebabe", wantErr: true},
+		{name: "not hex", value: "sha256:xyz", wantErr: true},
+		{name: "empty", value: " ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseChecksum(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseChecksum() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.algorithm != tt.wantAlgorithm {
+				t.Errorf("parseChecksum() algorithm = %q, want %q", got.algorithm, tt.wantAlgorithm)
+			}
+		})
+	}
+}
+
+func Test_parseDigestHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  http.Header
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "Content-MD5",
+			header: http.Header{"Content-Md5": {"LBdDo5EwX782ffjk8Gn5+Q=="}},
+			want:   []string{"md5 2c1743a391305fbf367df8e4f069f9f9"},
+		},
+		{
+			name:   "Digest",
+			header: http.Header{"Digest": {"SHA=x,SHA-256=jtP2rWhblZ6tcCJRjhr3bNgW+OjsfM3aHtQBjo8iI/g="}},
+			want:   []string{"sha-256 8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8"},
+		},
+		{
+			name:   "Content-Digest",
+			header: http.Header{"Content-Digest": {"sha-256=:jtP2rWhblZ6tcCJRjhr3bNgW+OjsfM3aHtQBjo8iI/g=:, unixsum=:MTIz:"}},
+			want:   []string{"sha-256 8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8"},
+		},
+		{
+			name:    "wrong length",
+			header:  http.Header{"Content-Digest": {"sha-512=:jtP2rWhblZ6tcCJRjhr3bNgW+OjsfM3aHtQBjo8iI/g=:"}},
+			wantErr: true,
+		},
+		{
+			name:    "not base64",
+			header:  http.Header{"Content-Md5": {"2c1743a391305fbf367df8e4f069f9f9"}},
+			wantErr: true,
+		},
+		{
+			name:    "no value",
+			header:  http.Header{"Digest": {"sha-256"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digests, err := parseDigestHeaders(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDigestHeaders() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, d := range digests {
+				got = append(got, d.algorithm+" "+hex.EncodeToString(d.sum))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseDigestHeaders() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("parseDigestHeaders() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -3,7 +3,6 @@ package filehandler
 import (
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"math"
 	"net/http"
@@ -13,8 +12,15 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/sgreben/httpfileserver/internal/archive"
+	"github.com/sgreben/httpfileserver/internal/archivecache"
+	"github.com/sgreben/httpfileserver/internal/archivefs"
+	"github.com/sgreben/httpfileserver/internal/limiter"
 	"github.com/sgreben/httpfileserver/internal/targz"
+	"github.com/sgreben/httpfileserver/internal/tus"
+	"github.com/sgreben/httpfileserver/internal/webdav"
 	"github.com/sgreben/httpfileserver/internal/zip"
 )
 
@@ -23,36 +29,63 @@ const (
 	tarGzValue       = "true"
 	tarGzContentType = "application/x-tar+gzip"
 
+	tarKey         = "tar"
+	tarValue       = "true"
+	tarContentType = "application/x-tar"
+
 	zipKey         = "zip"
 	zipValue       = "true"
 	zipContentType = "application/zip"
 
+	levelKey    = "level"
+	includeKey  = "include"
+	excludeKey  = "exclude"
+	maxDepthKey = "max-depth"
+
+	pathKey              = "path"
+	passwordKey          = "password"
+	maxSelectionFormSize = 1 << 20
+
 	osPathSeparator = string(filepath.Separator)
 )
 
+// pageHeadTemplateText is the head shared by all HTML pages.
+const pageHeadTemplateText = `{{ define "head" }}<head>
+	<title>{{ .Title }}</title>
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<style>body{font-family: sans-serif;}td{padding:.5em;}a{display:block;}tbody tr:nth-child(odd){background:#eee;}.number{text-align:right}.text{text-align:left;word-break:break-all;}canvas,table{width:100%;max-width:100%;}.dropping{outline:.3em dashed #888;outline-offset:-.3em;}#upload-list a{display:inline;}#upload-list progress{margin:0 .5em;}#upload-list .failed{color:#b00;}</style>
+</head>{{ end }}`
+
 const directoryListingTemplateText = `
 <html>
 <meta name="google" content="notranslate"/>
-<head>
-	<title>{{ .Title }}</title>
-	<meta name="viewport" content="width=device-width, initial-scale=1">
-	<style>body{font-family: sans-serif;}td{padding:.5em;}a{display:block;}tbody tr:nth-child(odd){background:#eee;}.number{text-align:right}.text{text-align:left;word-break:break-all;}canvas,table{width:100%;max-width:100%;}</style>
-</head>
+{{ template "head" . }}
 <body>
 <h1>{{ .Title }}</h1>
-{{ if or .Files .AllowUpload }}
+{{ if or .Files .AllowUpload .Manage }}
+{{- $columns := 4 }}{{ if .Manage }}{{ $columns = 5 }}{{ end }}
 <table>
 	<thead>
+		<th></th>
 		<th></th>
 		<th colspan=2 class=number>Size (bytes)</th>
+		{{- if .Manage }}
+		<th></th>
+		{{- end }}
 	</thead>
 	<tbody>
 	{{- if .Files }}
-	<tr><td colspan=3><a href="{{ .TarGzURL }}">.tar.gz of all files</a></td></tr>
-	<tr><td colspan=3><a href="{{ .ZipURL }}">.zip of all files</a></td></tr>
+	{{- range .Archives }}
+	<tr><td colspan={{ $columns }}><a href="{{ .URL }}">{{ .Label }}</a></td></tr>
+	{{- end }}
+	{{- if .Archives }}
+	<tr><td colspan={{ $columns }}><form method="get"><input name="include" placeholder="include, e.g. *.log"/> <input name="exclude" placeholder="exclude, e.g. node_modules"/> <input name="max-depth" type="number" min="1" placeholder="max depth"/>{{ range .Archives }}{{ if .Key }} <button name="{{ .Key }}" value="{{ .Value }}">{{ .Name }}</button>{{ end }}{{ end }}</form></td></tr>
+	<tr><td colspan={{ $columns }}><form id="selection" method="post">Selected files as{{ range .Archives }} <button formaction="{{ .URL }}">{{ .Name }}</button>{{ end }}{{ if .AllowEncryption }} <input name="password" type="password" autocomplete="new-password" placeholder="zip password (optional)"/>{{ end }}</form></td></tr>
+	{{- end }}
 	{{- end }}
 	{{- range .Files }}
 	<tr>
+		<td>{{ if $.Archives }}<input type="checkbox" name="path" value="{{ .Path }}" form="selection"/>{{ end }}</td>
 		{{ if (not .IsDir) }}
 		<td class=text><a href="{{ .URL.String }}">{{ .Name }}</td>
 		<td class=number>{{.Size.String }}</td>
@@ -60,14 +93,263 @@ const directoryListingTemplateText = `
 		{{ else }}
 		<td colspan=3 class=text><a href="{{ .URL.String }}">{{ .Name }}</td>
 		{{ end }}
+		{{- if $.Manage }}
+		<td><form method="post" action="{{ .URL.Path }}?manage=move"><input name="to" placeholder="new name or /path/" required/> <button>Move</button> <button formaction="{{ .URL.Path }}?manage=delete" formnovalidate onclick="return confirm('Delete ' + {{ .Name }} + '?')">Delete</button>{{ if .IsDir }}<input type="hidden" name="recursive" value="true"/>{{ end }}</form></td>
+		{{- end }}
 	</tr>
 	{{- end }}
+	{{- if .Manage }}
+	<tr><td colspan={{ $columns }}><form method="post" action="?manage=mkdir"><input name="name" placeholder="new folder" required/> <button>Create folder</button></form></td></tr>
+	{{- end }}
 	{{- if .AllowUpload }}
-	<tr><td colspan=3><form method="post" enctype="multipart/form-data"><input required name="file" type="file"/><input value="Upload" type="submit"/></form></td></tr>
+	<tr><td colspan={{ $columns }}><form id="upload" method="post" enctype="multipart/form-data">Files <input name="file" type="file" multiple/> or a folder <input name="file" type="file" multiple webkitdirectory/> <input value="Upload" type="submit"/><span id="upload-drop" hidden>, or drop them onto this page</span></form><ul id="upload-list" hidden></ul><a id="upload-reload" href="" hidden>Reload the listing</a></td></tr>
 	{{- end }}
 	</tbody>
 </table>
 {{ end }}
+{{- if .AllowUpload }}
+<script>
+(function () {
+	var form = document.getElementById("upload"), list = document.getElementById("upload-list"), reload = document.getElementById("upload-reload");
+	var resumable = {{ .Resumable }}, chunkSize = 8 << 20, tus = {"Tus-Resumable": "1.0.0"};
+	var queue = [], running = false;
+	function headers(extra) {
+		return Object.assign({}, tus, extra);
+	}
+	// send makes a request for item, reporting the bytes of body sent so far.
+	function send(item, method, url, headers, body, progress) {
+		return new Promise(function (resolve, reject) {
+			if (item.cancelled) {
+				reject(new Error("cancelled"));
+				return;
+			}
+			var xhr = new XMLHttpRequest();
+			item.xhr = xhr;
+			xhr.open(method, url);
+			for (var name in headers) {
+				xhr.setRequestHeader(name, headers[name]);
+			}
+			if (progress) {
+				xhr.upload.onprogress = function (event) {
+					progress(event.loaded, event.total);
+				};
+			}
+			xhr.onload = function () {
+				if (xhr.status >= 200 && xhr.status < 300) {
+					resolve(xhr);
+				} else {
+					reject(new Error(xhr.responseText.trim() || xhr.status + " " + xhr.statusText));
+				}
+			};
+			xhr.onerror = function () {
+				reject(new Error("network error"));
+			};
+			xhr.onabort = function () {
+				reject(new Error("cancelled"));
+			};
+			xhr.send(body);
+		});
+	}
+	async function uploadForm(item) {
+		var data = new FormData();
+		data.append("file", item.file, item.name);
+		var xhr = await send(item, "POST", location.pathname, {"Accept": "application/json"}, data, function (loaded, total) {
+			item.show(total ? loaded / total : 1);
+		});
+		return JSON.parse(xhr.responseText)[0];
+	}
+	async function uploadResumable(item) {
+		var file = item.file, xhr;
+		var key = "tus " + location.pathname + " " + item.name + " " + file.size + " " + file.lastModified;
+		var url = localStorage.getItem(key), offset = -1;
+		try {
+			if (url) {
+				try {
+					xhr = await send(item, "HEAD", url, headers({}));
+					offset = Number(xhr.getResponseHeader("Upload-Offset"));
+				} catch (err) {
+					if (item.cancelled) {
+						throw err;
+					}
+				}
+			}
+			if (offset < 0) {
+				xhr = await send(item, "POST", location.pathname, headers({
+					"Upload-Length": String(file.size),
+					"Upload-Metadata": "filename " + btoa(unescape(encodeURIComponent(item.name)))
+				}));
+				url = xhr.getResponseHeader("Location");
+				offset = 0;
+				localStorage.setItem(key, url);
+			}
+			while (offset < file.size) {
+				var start = offset;
+				item.show(start / file.size);
+				xhr = await send(item, "PATCH", url, headers({
+					"Content-Type": "application/offset+octet-stream",
+					"Upload-Offset": String(offset)
+				}), file.slice(offset, offset + chunkSize), function (loaded) {
+					item.show((start + loaded) / file.size);
+				});
+				offset = Number(xhr.getResponseHeader("Upload-Offset"));
+			}
+		} catch (err) {
+			if (item.cancelled && url) {
+				localStorage.removeItem(key);
+				fetch(url, {method: "DELETE", headers: tus});
+			}
+			throw err;
+		}
+		localStorage.removeItem(key);
+		// the header is the escaped path of the stored file below this
+		// directory, so its segments after ours name the file
+		var stored = xhr.getResponseHeader("Content-Location") || "";
+		return {
+			storedAs: stored.split("/").slice(location.pathname.split("/").length - 1).map(decodeURIComponent).join("/"),
+			url: stored
+		};
+	}
+	async function run() {
+		if (running) {
+			return;
+		}
+		running = true;
+		while (queue.length) {
+			var item = queue.shift();
+			if (item.cancelled) {
+				continue;
+			}
+			try {
+				item.done(await (resumable ? uploadResumable(item) : uploadForm(item)));
+				reload.hidden = false;
+			} catch (err) {
+				item.fail(item.cancelled ? "cancelled" : err.message + (resumable ? " (upload it again to resume)" : ""));
+			}
+		}
+		running = false;
+	}
+	// newItem adds a row for file to the list, showing its progress and,
+	// once done, its result.
+	function newItem(file, name) {
+		var item = {file: file, name: name, cancelled: false};
+		var row = document.createElement("li"), bar = document.createElement("progress");
+		var status = document.createElement("span"), cancel = document.createElement("button");
+		bar.max = 1;
+		bar.value = 0;
+		status.textContent = "waiting";
+		cancel.type = "button";
+		cancel.textContent = "Cancel";
+		cancel.onclick = function () {
+			item.cancelled = true;
+			if (item.xhr) {
+				item.xhr.abort();
+			} else {
+				item.fail("cancelled");
+			}
+		};
+		row.append(name, bar, status, " ", cancel);
+		item.show = function (fraction) {
+			bar.value = fraction;
+			status.textContent = Math.floor(100 * fraction) + "%";
+		};
+		item.done = function (result) {
+			var link = document.createElement("a");
+			link.href = result.url;
+			link.textContent = result.storedAs || name;
+			bar.remove();
+			cancel.remove();
+			status.textContent = ": stored as ";
+			status.append(link);
+		};
+		item.fail = function (message) {
+			bar.remove();
+			cancel.remove();
+			status.textContent = ": " + message;
+			row.className = "failed";
+		};
+		list.append(row);
+		list.hidden = false;
+		return item;
+	}
+	function add(file, name) {
+		queue.push(newItem(file, name));
+		run();
+	}
+	function readEntries(reader) {
+		return new Promise(function (resolve, reject) {
+			reader.readEntries(resolve, reject);
+		});
+	}
+	// addEntry queues a dropped file, or the files in a dropped folder.
+	async function addEntry(entry, prefix) {
+		if (entry.isFile) {
+			add(await new Promise(function (resolve, reject) {
+				entry.file(resolve, reject);
+			}), prefix + entry.name);
+			return;
+		}
+		var reader = entry.createReader(), entries;
+		while ((entries = await readEntries(reader)).length) {
+			for (var child of entries) {
+				await addEntry(child, prefix + entry.name + "/");
+			}
+		}
+	}
+	function dragsFiles(event) {
+		return Array.prototype.indexOf.call(event.dataTransfer.types, "Files") >= 0;
+	}
+	document.addEventListener("dragover", function (event) {
+		if (dragsFiles(event)) {
+			event.preventDefault();
+			event.dataTransfer.dropEffect = "copy";
+			document.body.classList.add("dropping");
+		}
+	});
+	document.addEventListener("dragleave", function (event) {
+		if (!event.relatedTarget) {
+			document.body.classList.remove("dropping");
+		}
+	});
+	document.addEventListener("drop", async function (event) {
+		if (!dragsFiles(event)) {
+			return;
+		}
+		event.preventDefault();
+		document.body.classList.remove("dropping");
+		// The dropped items are only available while the event is handled.
+		var entries = [], files = Array.from(event.dataTransfer.files);
+		for (var dropped of event.dataTransfer.items) {
+			var entry = dropped.webkitGetAsEntry && dropped.webkitGetAsEntry();
+			if (entry) {
+				entries.push(entry);
+			}
+		}
+		if (!entries.length) {
+			files.forEach(function (file) {
+				add(file, file.name);
+			});
+		}
+		for (var entry of entries) {
+			try {
+				await addEntry(entry, "");
+			} catch (err) {
+				newItem(null, entry.name).fail(err.message);
+			}
+		}
+	});
+	form.addEventListener("submit", function (event) {
+		event.preventDefault();
+		for (var input of form.querySelectorAll("input[type=file]")) {
+			for (var file of input.files) {
+				add(file, file.webkitRelativePath || file.name);
+			}
+		}
+		form.reset();
+	});
+	document.getElementById("upload-drop").hidden = false;
+})();
+</script>
+{{- end }}
 </body>
 </html>
 `
@@ -98,6 +380,7 @@ func (f fileSizeBytes) String() string {
 }
 
 type directoryListingFileData struct {
+	Path  string
 	Name  string
 	Size  fileSizeBytes
 	IsDir bool
@@ -106,25 +389,104 @@ type directoryListingFileData struct {
 
 type directoryListingData struct {
 	Title       string
-	ZipURL      *url.URL
-	TarGzURL    *url.URL
+	Archives    []archiveLink
 	Files       []directoryListingFileData
 	AllowUpload bool
+	// Resumable makes the upload form send files in resumable chunks.
+	Resumable bool
+	// AllowEncryption offers a password field for encrypted zip archives.
+	AllowEncryption bool
+	// Manage offers buttons to create directories, and to delete and move
+	// files.
+	Manage bool
+}
+
+// Options are the optional settings of a FileHandler.
+type Options struct {
+	// ArchiveLimiter bounds archive generation across all routes and is
+	// shared between handlers.
+	ArchiveLimiter *limiter.Limiter
+	// RouteArchiveLimiter bounds archive generation for this route only.
+	RouteArchiveLimiter *limiter.Limiter
+	// ArchiveRetryAfter is advertised to clients refused an archive slot.
+	ArchiveRetryAfter time.Duration
+	// ArchiveSkipErrors leaves unreadable files out of archives, listing
+	// them in an ERRORS.txt entry, instead of failing the download.
+	ArchiveSkipErrors bool
+	// ArchiveSymlinks decides whether symbolic links are followed, stored
+	// as links or left out of archives.
+	ArchiveSymlinks archive.SymlinkPolicy
+	// ArchiveFormats are the archive downloads offered, DefaultArchiveFormats
+	// if nil.
+	ArchiveFormats ArchiveFormats
+	// ArchiveLevel is the default compression level from 1 (fastest) to 9
+	// (smallest), 0 for the compressor's default.
+	ArchiveLevel int
+	// ArchiveCache, if set, keeps generated archives so that repeated
+	// downloads of an unchanged tree are served like regular files.
+	ArchiveCache *archivecache.Cache
+	// ArchiveReproducible makes archives of unchanged trees byte-for-byte
+	// identical.
+	ArchiveReproducible bool
+	// ArchiveSourceDateEpoch, if set, clamps modification times in archives.
+	ArchiveSourceDateEpoch time.Time
+	// ArchiveChecksums adds a SHA256SUMS entry to archives.
+	ArchiveChecksums bool
+	// ArchiveLimits bound the size of the trees archives are generated for.
+	ArchiveLimits archive.Limits
+	// ArchiveGzipWorkers, if greater than 1, compresses .tar.gz archives
+	// on this many goroutines.
+	ArchiveGzipWorkers int
+	// BrowseArchives lists zip and tar files as directories when their
+	// URLs end in a slash, and serves the files inside them.
+	BrowseArchives bool
+	// ArchiveIndexCache, if set, keeps the indexes of browsed archives.
+	ArchiveIndexCache *archivefs.Cache
+	// UploadLimits bound the size of uploaded files and upload requests.
+	UploadLimits UploadLimits
+	// UploadConflict decides what happens to uploads whose name is taken.
+	UploadConflict ConflictPolicy
+	// UploadCreateDirs lets PUT uploads create missing parent directories.
+	UploadCreateDirs bool
+	// UploadPolicy restricts the names and types of uploaded files.
+	UploadPolicy UploadPolicy
+	// UploadStore, if set, keeps partial uploads of the tus resumable
+	// upload protocol.
+	UploadStore *tus.Store
+	// Manage lets clients authenticated with ManageAuth create directories
+	// and delete and move files within the route's path.
+	Manage bool
+	// ManageAuth are the credentials management requests authenticate with.
+	ManageAuth Credentials
+	// WebDAV serves the route to WebDAV clients. Writing follows the
+	// permissions of uploads and management requests.
+	WebDAV bool
+	// WebDAVLocks, if set, keeps the locks of WebDAV clients and is shared
+	// between handlers.
+	WebDAVLocks *webdav.Locks
 }
 
 type FileHandler struct {
 	route       string
 	path        string
 	allowUpload bool
+	options     Options
 
-	tarArchiver func(io.Writer, string) error
-	zipArchiver func(io.Writer, string) error
+	tarGzArchiver archiverFunc
+	tarArchiver   archiverFunc
+	zipArchiver   archiverFunc
 }
 
 var (
-	directoryListingTemplate = template.Must(template.New("").Parse(directoryListingTemplateText))
+	directoryListingTemplate = newPageTemplate(directoryListingTemplateText)
 )
 
+// newPageTemplate parses an HTML page template using the shared head.
+func newPageTemplate(text string) *template.Template {
+	t := template.Must(template.New("").Parse(pageHeadTemplateText))
+	return template.Must(t.Parse(text))
+}
+
 func getArchiveURL(url url.URL, archiveKey, archiveValue string) *url.URL {
 	q := url.Query()
 	q.Set(archiveKey, archiveValue)
@@ -141,20 +503,13 @@ func (f *FileHandler) serveStatus(w http.ResponseWriter, r *http.Request, status
 	return nil
 }
 
-func (f *FileHandler) setArchiveContent(w http.ResponseWriter, r *http.Request, contentType, extension, path string) {
-	w.Header().Set("Content-Type", contentType)
-	name := filepath.Base(path) + extension
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename=%q`, name))
-}
-
-func (f *FileHandler) serveTarGz(w http.ResponseWriter, r *http.Request, osPath string) error {
-	f.setArchiveContent(w, r, tarGzContentType, ".tar.gz", osPath)
-	return f.tarArchiver(w, osPath)
-}
-
-func (f *FileHandler) serveZip(w http.ResponseWriter, r *http.Request, osPath string) error {
-	f.setArchiveContent(w, r, zipContentType, ".zip", osPath)
-	return f.zipArchiver(w, osPath)
+// serveStatusError responds with status and the reason err, for errors the
+// client can correct.
+func (f *FileHandler) serveStatusError(w http.ResponseWriter, r *http.Request, status int, err error) error {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, werr := fmt.Fprintf(w, "%s: %v\n", http.StatusText(status), err)
+	return werr
 }
 
 func (f *FileHandler) serveDir(w http.ResponseWriter, r *http.Request, osPath string) error {
@@ -162,10 +517,16 @@ func (f *FileHandler) serveDir(w http.ResponseWriter, r *http.Request, osPath st
 	if err != nil {
 		return err
 	}
-	files, err := d.Readdir(-1)
+	all, err := d.Readdir(-1)
 	if err != nil {
 		return err
 	}
+	files := all[:0]
+	for _, file := range all {
+		if !isUploadTemp(file.Name()) {
+			files = append(files, file)
+		}
+	}
 	sort.Slice(files, func(i, j int) bool {
 		var rsp bool
 		isDirA := files[i].IsDir()
@@ -185,12 +546,14 @@ func (f *FileHandler) serveDir(w http.ResponseWriter, r *http.Request, osPath st
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	return directoryListingTemplate.Execute(w, directoryListingData{
 		AllowUpload: f.allowUpload,
+		Resumable:   f.allowUpload && f.options.UploadStore != nil,
+		Manage:      f.options.Manage,
 		Title: func() string {
 			relPath, _ := filepath.Rel(f.path, osPath)
 			return filepath.Join(filepath.Base(f.path), relPath)
 		}(),
-		TarGzURL: getArchiveURL(*r.URL, tarGzKey, tarGzValue),
-		ZipURL:   getArchiveURL(*r.URL, zipKey, zipValue),
+		Archives:        f.archiveLinks(*r.URL),
+		AllowEncryption: f.archiveFormats().contains(ArchiveFormatZip),
 		Files: func() (out []directoryListingFileData) {
 			for _, d := range files {
 				name := d.Name()
@@ -198,6 +561,7 @@ func (f *FileHandler) serveDir(w http.ResponseWriter, r *http.Request, osPath st
 					name += osPathSeparator
 				}
 				fileData := directoryListingFileData{
+					Path:  d.Name(),
 					Name:  name,
 					IsDir: d.IsDir(),
 					Size:  fileSizeBytes(d.Size()),
@@ -217,33 +581,6 @@ func (f *FileHandler) serveDir(w http.ResponseWriter, r *http.Request, osPath st
 	})
 }
 
-func (f *FileHandler) serveUploadTo(w http.ResponseWriter, r *http.Request, osPath string) error {
-	if err := r.ParseForm(); err != nil {
-		return err
-	}
-	in, h, err := r.FormFile("file")
-	if err == http.ErrMissingFile {
-		w.Header().Set("Location", r.URL.String())
-		w.WriteHeader(http.StatusSeeOther)
-	}
-	if err != nil {
-		return err
-	}
-	outPath := filepath.Join(osPath, filepath.Base(h.Filename))
-	out, err := os.OpenFile(outPath, os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	if _, err := io.Copy(out, in); err != nil {
-		return err
-	}
-	w.Header().Set("Location", r.URL.String())
-	w.WriteHeader(http.StatusSeeOther)
-	return nil
-}
-
 func (f *FileHandler) urlPathToOSPath(urlPath string) string {
 	if !strings.HasPrefix(urlPath, "/") {
 		urlPath = "/" + urlPath
@@ -261,7 +598,45 @@ func (f *FileHandler) urlPathToOSPath(urlPath string) string {
 func (f *FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[%s] %s %s %s", f.path, r.RemoteAddr, r.Method, r.URL.String())
 	osPath := f.urlPathToOSPath(r.URL.Path)
+	if isUploadTemp(osPath) {
+		// Uploads in progress are neither served nor replaced.
+		_ = f.serveStatus(w, r, http.StatusNotFound)
+		return
+	}
+	if f.allowUpload && f.options.UploadStore != nil && isTusRequest(r) {
+		err := f.serveTus(w, r, osPath)
+		f.serveTusError(w, r, err)
+		return
+	}
+	if f.servesWebDAV(r) {
+		err := f.serveWebDAV(w, r, osPath)
+		f.serveWebDAVError(w, r, err)
+		return
+	}
+	if f.options.Manage && isManageRequest(r) {
+		err := f.serveManage(w, r, osPath)
+		f.serveManageError(w, r, err)
+		return
+	}
+	if r.Method == http.MethodPut {
+		if !f.allowUpload {
+			allow := "GET, HEAD"
+			if f.options.Manage {
+				allow += ", POST"
+			}
+			w.Header().Set("Allow", allow)
+			_ = f.serveStatus(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		err := f.servePut(w, r, osPath, f.options.UploadConflict)
+		f.serveUploadError(w, r, err)
+		return
+	}
 	info, err := os.Stat(osPath)
+	if archivePath, member, ok := f.browsedArchive(r, osPath, info, err); ok {
+		f.serveArchiveMember(w, r, archivePath, member)
+		return
+	}
 	switch {
 	case os.IsNotExist(err):
 		_ = f.serveStatus(w, r, http.StatusNotFound)
@@ -271,19 +646,16 @@ func (f *FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		_ = f.serveStatus(w, r, http.StatusInternalServerError)
 	case r.URL.Query().Get(zipKey) != "":
 		err := f.serveZip(w, r, osPath)
-		if err != nil {
-			_ = f.serveStatus(w, r, http.StatusInternalServerError)
-		}
+		f.serveArchiveError(w, r, err)
 	case r.URL.Query().Get(tarGzKey) != "":
 		err := f.serveTarGz(w, r, osPath)
-		if err != nil {
-			_ = f.serveStatus(w, r, http.StatusInternalServerError)
-		}
+		f.serveArchiveError(w, r, err)
+	case r.URL.Query().Get(tarKey) != "":
+		err := f.serveTar(w, r, osPath)
+		f.serveArchiveError(w, r, err)
 	case f.allowUpload && info.IsDir() && r.Method == http.MethodPost:
 		err := f.serveUploadTo(w, r, osPath)
-		if err != nil {
-			_ = f.serveStatus(w, r, http.StatusInternalServerError)
-		}
+		f.serveUploadError(w, r, err)
 	case info.IsDir():
 		err := f.serveDir(w, r, osPath)
 		if err != nil {
@@ -302,13 +674,15 @@ func (f *FileHandler) GetPath() string {
 	return f.path
 }
 
-func NewFileHandler(route, path string, allowUpload bool) *FileHandler {
+func NewFileHandler(route, path string, allowUpload bool, options Options) *FileHandler {
 	return &FileHandler{
 		route:       route,
 		path:        path,
 		allowUpload: allowUpload,
+		options:     options,
 
-		tarArchiver: targz.TarGz,
-		zipArchiver: zip.Zip,
+		tarGzArchiver: targz.TarGz,
+		tarArchiver:   targz.Tar,
+		zipArchiver:   zip.Zip,
 	}
 }
@@ -1,15 +1,22 @@
 package filehandler
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/sgreben/httpfileserver/internal/archive"
+	"github.com/sgreben/httpfileserver/internal/archivecache"
+	"github.com/sgreben/httpfileserver/internal/limiter"
 	"github.com/sgreben/httpfileserver/internal/targz"
+	"github.com/sgreben/httpfileserver/internal/tus"
 	"github.com/sgreben/httpfileserver/internal/zip"
 )
 
@@ -109,7 +116,7 @@ func Test_fileHandler_serveStatus(t *testing.T) {
 
 func Test_fileHandler_serveTarGz(t *testing.T) {
 	type fields struct {
-		tarArchiver func(io.Writer, string) error
+		tarGzArchiver archiverFunc
 	}
 	type args struct {
 		w    http.ResponseWriter
@@ -127,12 +134,13 @@ func Test_fileHandler_serveTarGz(t *testing.T) {
 		{
 			name: "success",
 			fields: fields{
-				tarArchiver: func(w io.Writer, path string) error {
+				tarGzArchiver: func(ctx context.Context, w io.Writer, path string, opts archive.Options) error {
 					return nil
 				},
 			},
 			args: args{
 				w:    httptest.NewRecorder(),
+				r:    httptest.NewRequest(http.MethodGet, "http://target.example", nil),
 				path: "path/to/testfile",
 			},
 			wantErr:                false,
@@ -143,7 +151,7 @@ func Test_fileHandler_serveTarGz(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			f := &FileHandler{
-				tarArchiver: tt.fields.tarArchiver,
+				tarGzArchiver: tt.fields.tarGzArchiver,
 			}
 			if err := f.serveTarGz(tt.args.w, tt.args.r, tt.args.path); (err != nil) != tt.wantErr {
 				t.Errorf("fileHandler.serveTarGz() error = %v, wantErr %v", err, tt.wantErr)
@@ -162,7 +170,7 @@ func Test_fileHandler_serveTarGz(t *testing.T) {
 
 func Test_fileHandler_serveZip(t *testing.T) {
 	type fields struct {
-		zipArchiver func(io.Writer, string) error
+		zipArchiver archiverFunc
 	}
 	type args struct {
 		w      http.ResponseWriter
@@ -180,12 +188,13 @@ func Test_fileHandler_serveZip(t *testing.T) {
 		{
 			name: "success",
 			fields: fields{
-				zipArchiver: func(w io.Writer, path string) error {
+				zipArchiver: func(ctx context.Context, w io.Writer, path string, opts archive.Options) error {
 					return nil
 				},
 			},
 			args: args{
 				w:      httptest.NewRecorder(),
+				r:      httptest.NewRequest(http.MethodGet, "http://target.example", nil),
 				osPath: "path/to/testfile",
 			},
 			wantErr:                false,
@@ -232,17 +241,17 @@ func Test_newFileHandler(t *testing.T) {
 				allowUpload: true,
 			},
 			want: &FileHandler{
-				route:       "testroute",
-				path:        "testpath",
-				allowUpload: true,
-				tarArchiver: targz.TarGz,
-				zipArchiver: zip.Zip,
+				route:         "testroute",
+				path:          "testpath",
+				allowUpload:   true,
+				tarGzArchiver: targz.TarGz,
+				zipArchiver:   zip.Zip,
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := NewFileHandler(tt.args.route, tt.args.path, tt.args.allowUpload)
+			got := NewFileHandler(tt.args.route, tt.args.path, tt.args.allowUpload, Options{})
 			if got.route != tt.want.route {
 				t.Errorf("newFileHandler().route got %v, want %v", got.route, tt.want.route)
 			}
@@ -252,6 +261,9 @@ func Test_newFileHandler(t *testing.T) {
 			if got.allowUpload != tt.want.allowUpload {
 				t.Errorf("newFileHandler().allowUpload got %v, want %v", got.allowUpload, tt.want.allowUpload)
 			}
+			if got.tarGzArchiver == nil {
+				t.Errorf("newFileHandler().tarGzArchiver set to nil")
+			}
 			if got.tarArchiver == nil {
 				t.Errorf("newFileHandler().tarArchiver set to nil")
 			}
@@ -263,12 +275,17 @@ func Test_newFileHandler(t *testing.T) {
 }
 
 func Test_fileHandler_serveDir(t *testing.T) {
+	store, err := tus.NewStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
 	type fields struct {
-		route       string
-		path        string
-		allowUpload bool
-		tarArchiver func(io.Writer, string) error
-		zipArchiver func(io.Writer, string) error
+		route         string
+		path          string
+		allowUpload   bool
+		options       Options
+		tarGzArchiver archiverFunc
+		zipArchiver   archiverFunc
 	}
 	type args struct {
 		w      http.ResponseWriter
@@ -276,10 +293,12 @@ func Test_fileHandler_serveDir(t *testing.T) {
 		osPath string
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
+		name        string
+		fields      fields
+		args        args
+		wantErr     bool
+		wantBody    []string
+		notWantBody []string
 	}{
 		{
 			name:    "os.Open() error",
@@ -295,21 +314,77 @@ func Test_fileHandler_serveDir(t *testing.T) {
 				r:      httptest.NewRequest(http.MethodGet, "http://target.example", nil),
 				osPath: ".",
 			},
+			wantErr:     false,
+			notWantBody: []string{`<form id="upload"`, "<script>"},
+		},
+		{
+			name:   "with uploads",
+			fields: fields{allowUpload: true},
+			args: args{
+				w:      httptest.NewRecorder(),
+				r:      httptest.NewRequest(http.MethodGet, "http://target.example", nil),
+				osPath: ".",
+			},
 			wantErr: false,
+			wantBody: []string{
+				// the form works without scripts
+				`<form id="upload" method="post" enctype="multipart/form-data">Files <input name="file" type="file" multiple/>`,
+				`<input name="file" type="file" multiple webkitdirectory/> <input value="Upload" type="submit"/>`,
+				`<span id="upload-drop" hidden>`,
+				`<ul id="upload-list" hidden></ul>`,
+				`<a id="upload-reload" href="" hidden>`,
+				"<script>",
+				"var resumable =  false ,",
+				`document.addEventListener("drop", `,
+				"xhr.upload.onprogress = ",
+				`document.createElement("progress")`,
+			},
+		},
+		{
+			name:   "with resumable uploads",
+			fields: fields{allowUpload: true, options: Options{UploadStore: store}},
+			args: args{
+				w:      httptest.NewRecorder(),
+				r:      httptest.NewRequest(http.MethodGet, "http://target.example", nil),
+				osPath: ".",
+			},
+			wantErr: false,
+			wantBody: []string{
+				`<form id="upload"`,
+				"var resumable =  true ,",
+				// stored names are shown decoded and linked as sent
+				`.map(decodeURIComponent).join("/"),`,
+				"url: stored\n",
+			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			f := &FileHandler{
-				route:       tt.fields.route,
-				path:        tt.fields.path,
-				allowUpload: tt.fields.allowUpload,
-				tarArchiver: tt.fields.tarArchiver,
-				zipArchiver: tt.fields.zipArchiver,
+				route:         tt.fields.route,
+				path:          tt.fields.path,
+				allowUpload:   tt.fields.allowUpload,
+				options:       tt.fields.options,
+				tarGzArchiver: tt.fields.tarGzArchiver,
+				zipArchiver:   tt.fields.zipArchiver,
 			}
 			if err := f.serveDir(tt.args.w, tt.args.r, tt.args.osPath); (err != nil) != tt.wantErr {
 				t.Errorf("fileHandler.serveDir() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if len(tt.wantBody)+len(tt.notWantBody) == 0 {
+				return
+			}
+			body := tt.args.w.(*httptest.ResponseRecorder).Body.String()
+			for _, want := range tt.wantBody {
+				if !strings.Contains(body, want) {
+					t.Errorf("fileHandler.serveDir() body lacks %q", want)
+				}
+			}
+			for _, notWant := range tt.notWantBody {
+				if strings.Contains(body, notWant) {
+					t.Errorf("fileHandler.serveDir() body contains %q", notWant)
+				}
+			}
 		})
 	}
 }
@@ -463,3 +538,172 @@ func TestFileHandler_GetPath(t *testing.T) {
 		})
 	}
 }
+
+func TestFileHandler_ServeHTTP_archiveBusy(t *testing.T) {
+	cache, err := archivecache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name           string
+		query          string
+		options        Options
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{
+			name:  "global limiter full",
+			query: "?zip=true",
+			options: Options{
+				ArchiveLimiter:    limiter.New("test-busy-global", 1, 0, 0),
+				ArchiveRetryAfter: 5 * time.Second,
+			},
+			wantStatus:     http.StatusServiceUnavailable,
+			wantRetryAfter: "5",
+		},
+		{
+			name:  "route limiter full",
+			query: "?tar.gz=true",
+			options: Options{
+				RouteArchiveLimiter: limiter.New("test-busy-route", 1, 0, 0),
+			},
+			wantStatus:     http.StatusServiceUnavailable,
+			wantRetryAfter: "1",
+		},
+		{
+			name:  "limits checked within a slot",
+			query: "?zip=true",
+			options: Options{
+				ArchiveLimiter: limiter.New("test-busy-limits", 1, 0, 0),
+				ArchiveLimits:  archive.Limits{Files: 1},
+			},
+			wantStatus:     http.StatusServiceUnavailable,
+			wantRetryAfter: "1",
+		},
+		{
+			name:  "fingerprint within a slot",
+			query: "?zip=true",
+			options: Options{
+				ArchiveLimiter: limiter.New("test-busy-fingerprint", 1, 0, 0),
+				ArchiveLimits:  archive.Limits{Files: 1},
+				ArchiveCache:   cache,
+			},
+			wantStatus:     http.StatusServiceUnavailable,
+			wantRetryAfter: "1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFileHandler("/", ".", false, tt.options)
+			release, err := f.acquireArchiveSlot(httptest.NewRequest(http.MethodGet, "/", nil))
+			if err != nil {
+				t.Fatalf("fileHandler.acquireArchiveSlot() error = %v", err)
+			}
+			defer release()
+
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+tt.query, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("fileHandler.ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("fileHandler.ServeHTTP() Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func Test_fileHandler_writeArchive(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		archiver archiverFunc
+		wantErr  error
+	}{
+		{
+			name:     "tar.gz success",
+			ctx:      context.Background(),
+			archiver: targz.TarGz,
+			wantErr:  nil,
+		},
+		{
+			name:     "tar.gz canceled",
+			ctx:      canceled,
+			archiver: targz.TarGz,
+			wantErr:  context.Canceled,
+		},
+		{
+			name:     "zip success",
+			ctx:      context.Background(),
+			archiver: zip.Zip,
+			wantErr:  nil,
+		},
+		{
+			name:     "zip canceled",
+			ctx:      canceled,
+			archiver: zip.Zip,
+			wantErr:  context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &FileHandler{}
+			r := httptest.NewRequest(http.MethodGet, "http://target.example", nil).WithContext(tt.ctx)
+			if err := f.writeArchive(io.Discard, r, ".", tt.archiver, archive.Options{}); err != tt.wantErr {
+				t.Errorf("fileHandler.writeArchive() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileHandler_ServeHTTP_archiveError(t *testing.T) {
+	tests := []struct {
+		name        string
+		archiver    archiverFunc
+		wantStatus  int
+		wantReadErr bool
+	}{
+		{
+			name: "error before output",
+			archiver: func(ctx context.Context, w io.Writer, path string, opts archive.Options) error {
+				return errors.New("test error")
+			},
+			wantStatus:  http.StatusInternalServerError,
+			wantReadErr: false,
+		},
+		{
+			name: "error mid-stream",
+			archiver: func(ctx context.Context, w io.Writer, path string, opts archive.Options) error {
+				if _, err := w.Write(make([]byte, 64*1024)); err != nil {
+					return err
+				}
+				return errors.New("test error")
+			},
+			wantStatus:  http.StatusOK,
+			wantReadErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFileHandler("/", ".", false, Options{})
+			f.zipArchiver = tt.archiver
+			server := httptest.NewServer(f)
+			defer server.Close()
+
+			rsp, err := http.Get(server.URL + "/?zip=true")
+			if err != nil {
+				t.Fatalf("http.Get() error = %v", err)
+			}
+			defer rsp.Body.Close()
+			if rsp.StatusCode != tt.wantStatus {
+				t.Errorf("fileHandler.ServeHTTP() status = %d, want %d", rsp.StatusCode, tt.wantStatus)
+			}
+			if _, err := io.ReadAll(rsp.Body); (err != nil) != tt.wantReadErr {
+				t.Errorf("fileHandler.ServeHTTP() body read error = %v, wantReadErr %v", err, tt.wantReadErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,411 @@
+package filehandler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	manageKey    = "manage"
+	manageMkdir  = "mkdir"
+	manageDelete = "delete"
+	manageMove   = "move"
+	manageRealm  = "http-file-server"
+
+	maxManageRequestSize = 1 << 16
+	jsonContentType      = "application/json"
+)
+
+var (
+	errUnauthorized   = errors.New("authentication required")
+	errInvalidManage  = errors.New("invalid request")
+	errManageConflict = errors.New("conflict")
+	errOutsideRoot    = errors.New("path outside the route")
+	errCrossOrigin    = errors.New("cross-origin request")
+)
+
+// Credentials are a user name and password for HTTP basic authentication.
+// It implements flag.Value, as USER:PASSWORD.
+type Credentials struct {
+	User     string
+	Password string
+}
+
+func (c Credentials) String() string {
+	if c.User == "" {
+		return ""
+	}
+	return c.User + ":****"
+}
+
+// Set is flag.Value.Set
+func (c *Credentials) Set(v string) error {
+	i := strings.Index(v, ":")
+	if i <= 0 || i == len(v)-1 {
+		return errors.New("want USER:PASSWORD")
+	}
+	c.User, c.Password = v[:i], v[i+1:]
+	return nil
+}
+
+// authorized reports whether r authenticates with the credentials c. Zero
+// credentials authorize nobody.
+func (c Credentials) authorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok || c.User == "" {
+		return false
+	}
+	// comparing digests keeps the time taken independent of the lengths
+	userSum, wantUser := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(c.User))
+	passwordSum, wantPassword := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(c.Password))
+	return subtle.ConstantTimeCompare(userSum[:], wantUser[:])&subtle.ConstantTimeCompare(passwordSum[:], wantPassword[:]) == 1
+}
+
+// manageRequest holds the parameters of a management request, sent as
+// form values or as a JSON object.
+type manageRequest struct {
+	// Name is the name of the directory to create.
+	Name string `json:"name"`
+	// To is the new name, or the URL path to move to. A path ending in a
+	// slash names the directory to move into.
+	To string `json:"to"`
+	// Recursive allows deleting non-empty directories.
+	Recursive bool `json:"recursive"`
+}
+
+// manageResult is the JSON response to a management request.
+type manageResult struct {
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// isManageRequest reports whether r asks to create, delete or move files.
+func isManageRequest(r *http.Request) bool {
+	return r.Method == http.MethodDelete || r.Method == http.MethodPost && r.URL.Query().Get(manageKey) != ""
+}
+
+// wantsJSON reports whether the response to r should be JSON.
+func wantsJSON(r *http.Request) bool {
+	t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return t == jsonContentType || strings.Contains(r.Header.Get("Accept"), jsonContentType)
+}
+
+func readManageRequest(r *http.Request) (manageRequest, error) {
+	var req manageRequest
+	r.Body = http.MaxBytesReader(nil, r.Body, maxManageRequestSize)
+	if t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); t == jsonContentType {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return req, fmt.Errorf("%w: %v", errInvalidManage, err)
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return req, fmt.Errorf("%w: %v", errInvalidManage, err)
+		}
+		return req, nil
+	}
+	if err := r.ParseForm(); err != nil {
+		return req, fmt.Errorf("%w: %v", errInvalidManage, err)
+	}
+	req.Name = r.Form.Get("name")
+	req.To = r.Form.Get("to")
+	if v := r.Form.Get("recursive"); v != "" {
+		recursive, err := strconv.ParseBool(v)
+		if err != nil {
+			return req, fmt.Errorf("%w: recursive=%q", errInvalidManage, v)
+		}
+		req.Recursive = recursive
+	}
+	return req, nil
+}
+
+// serveManage creates directories, and deletes and moves files and
+// directories, within the route's path. Requests must authenticate with
+// the route's management credentials.
+func (f *FileHandler) serveManage(w http.ResponseWriter, r *http.Request, osPath string) error {
+	if !sameOrigin(r) {
+		// browsers send the credentials along with forms of other sites
+		return errCrossOrigin
+	}
+	if err := f.authorizeManage(w, r); err != nil {
+		return err
+	}
+	action := r.URL.Query().Get(manageKey)
+	if r.Method == http.MethodDelete {
+		action = manageDelete
+	}
+	req, err := readManageRequest(r)
+	if err != nil {
+		return err
+	}
+	if err := f.confined(osPath); err != nil {
+		return err
+	}
+	// forms are sent from the listing of the directory holding the target,
+	// or of the target directory itself for mkdir
+	back := path.Dir(strings.TrimSuffix(r.URL.Path, "/"))
+	switch action {
+	case manageMkdir:
+		target, err := f.manageMkdir(osPath, req.Name)
+		if err != nil {
+			return err
+		}
+		log.Printf("[%s] %s created %s", f.path, r.RemoteAddr, target)
+		return f.serveManageResult(w, r, http.StatusCreated, f.osPathToURLPath(target)+"/", r.URL.Path)
+	case manageDelete:
+		if err := f.manageDelete(osPath, req.Recursive); err != nil {
+			return err
+		}
+		log.Printf("[%s] %s deleted %s", f.path, r.RemoteAddr, osPath)
+		return f.serveManageResult(w, r, http.StatusOK, "", back)
+	case manageMove:
+		target, err := f.manageMove(osPath, req.To)
+		if err != nil {
+			return err
+		}
+		log.Printf("[%s] %s moved %s to %s", f.path, r.RemoteAddr, osPath, target)
+		urlPath := f.osPathToURLPath(target)
+		if info, err := os.Stat(target); err == nil && info.IsDir() {
+			urlPath += "/"
+		}
+		return f.serveManageResult(w, r, http.StatusCreated, urlPath, back)
+	default:
+		return fmt.Errorf("%w: unknown action %q", errInvalidManage, action)
+	}
+}
+
+// sameOrigin reports whether r was sent by a page of this server, by the
+// Origin header or, failing that, the Referer header browsers send with
+// forms. Requests with neither, as sent by command-line clients, are not
+// forged by other sites.
+func sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	u, err := url.Parse(source)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// authorizeManage checks that r authenticates with the management
+// credentials, asking the client for them otherwise.
+func (f *FileHandler) authorizeManage(w http.ResponseWriter, r *http.Request) error {
+	if !f.options.ManageAuth.authorized(r) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", manageRealm))
+		return errUnauthorized
+	}
+	return nil
+}
+
+// serveManageResult responds to a successful management request: with
+// 204 No Content to DELETE, with the resulting path as JSON to API
+// clients, and with a redirect to the directory listing at back otherwise.
+func (f *FileHandler) serveManageResult(w http.ResponseWriter, r *http.Request, status int, urlPath, back string) error {
+	if urlPath != "" {
+		w.Header().Set("Location", (&url.URL{Path: urlPath}).String())
+	}
+	switch {
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	case wantsJSON(r):
+		w.Header().Set("Content-Type", jsonContentType)
+		w.WriteHeader(status)
+		return json.NewEncoder(w).Encode(manageResult{Path: urlPath})
+	default:
+		if !strings.HasSuffix(back, "/") {
+			back += "/"
+		}
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return nil
+	}
+}
+
+// serveManageError responds to a failed management request with a status
+// matching err.
+func (f *FileHandler) serveManageError(w http.ResponseWriter, r *http.Request, err error) {
+	var status int
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, errUnauthorized):
+		status = http.StatusUnauthorized
+	case errors.Is(err, errInvalidManage), errors.Is(err, errInvalidUpload):
+		status = http.StatusBadRequest
+	case errors.Is(err, errOutsideRoot), errors.Is(err, errCrossOrigin), os.IsPermission(err):
+		status = http.StatusForbidden
+	case errors.Is(err, errManageConflict), errors.Is(err, errUploadConflict):
+		status = http.StatusConflict
+	case os.IsNotExist(err):
+		status = http.StatusNotFound
+	default:
+		status = http.StatusInternalServerError
+	}
+	log.Printf("[%s] %s %s %s: %v", f.path, r.RemoteAddr, r.Method, r.URL.Path, err)
+	if status == http.StatusInternalServerError || os.IsPermission(err) || os.IsNotExist(err) {
+		// errors from the file system name server paths
+		err = errors.New(strings.ToLower(http.StatusText(status)))
+	}
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", jsonContentType)
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(manageResult{Error: err.Error()})
+		return
+	}
+	_ = f.serveStatusError(w, r, status, err)
+}
+
+// confined checks that osPath lies within the route's path once the
+// symbolic links in its parent directories are resolved. The last element
+// is not resolved, so that links themselves can be deleted and moved.
+func (f *FileHandler) confined(osPath string) error {
+	root, err := filepath.EvalSymlinks(f.path)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(osPath))
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, filepath.Join(dir, filepath.Base(osPath)))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+osPathSeparator) {
+		return fmt.Errorf("%w: %s", errOutsideRoot, filepath.Base(osPath))
+	}
+	return nil
+}
+
+// isRoot reports whether osPath is the route's path.
+func (f *FileHandler) isRoot(osPath string) bool {
+	return filepath.Clean(osPath) == filepath.Clean(f.path)
+}
+
+// osPathToURLPath returns the URL path of osPath, which must lie within
+// the route's path.
+func (f *FileHandler) osPathToURLPath(osPath string) string {
+	rel, _ := filepath.Rel(f.path, osPath)
+	return path.Join("/", f.route, filepath.ToSlash(rel))
+}
+
+// manageName checks a new file or directory name against the upload
+// policy.
+func (f *FileHandler) manageName(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("%w: name %q", errInvalidManage, name)
+	}
+	return f.options.UploadPolicy.checkElement(name)
+}
+
+// manageMkdir creates the directory name in the directory dir.
+func (f *FileHandler) manageMkdir(dir, name string) (string, error) {
+	name, err := f.manageName(name)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("%w: not a directory", errManageConflict)
+	}
+	target := filepath.Join(dir, name)
+	if err := os.Mkdir(target, dirMode); err != nil {
+		if os.IsExist(err) {
+			return "", fmt.Errorf("%w: %s exists", errManageConflict, name)
+		}
+		return "", err
+	}
+	return target, nil
+}
+
+// manageDelete deletes the file or directory osPath. Non-empty
+// directories are only deleted if recursive is set.
+func (f *FileHandler) manageDelete(osPath string, recursive bool) error {
+	if f.isRoot(osPath) {
+		return fmt.Errorf("%w: the route's root cannot be deleted", errInvalidManage)
+	}
+	info, err := os.Lstat(osPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return os.Remove(osPath)
+	}
+	if !recursive {
+		d, err := os.Open(osPath)
+		if err != nil {
+			return err
+		}
+		_, err = d.Readdirnames(1)
+		d.Close()
+		if err != io.EOF {
+			if err == nil {
+				err = fmt.Errorf("%w: directory %s is not empty", errManageConflict, info.Name())
+			}
+			return err
+		}
+	}
+	return os.RemoveAll(osPath)
+}
+
+// manageMove moves the file or directory osPath to, which is a new name
+// within the same directory or a URL path within the route. It returns the
+// new path.
+func (f *FileHandler) manageMove(osPath, to string) (string, error) {
+	if f.isRoot(osPath) {
+		return "", fmt.Errorf("%w: the route's root cannot be moved", errInvalidManage)
+	}
+	if _, err := os.Lstat(osPath); err != nil {
+		return "", err
+	}
+	var target string
+	switch {
+	case !strings.Contains(to, "/"):
+		name, err := f.manageName(to)
+		if err != nil {
+			return "", err
+		}
+		target = filepath.Join(filepath.Dir(osPath), name)
+	case strings.HasPrefix(to, "/"):
+		route := strings.TrimSuffix("/"+strings.Trim(f.route, "/"), "/")
+		if !strings.HasPrefix(to, route+"/") {
+			return "", fmt.Errorf("%w: %s", errOutsideRoot, to)
+		}
+		dir, name := path.Split(to)
+		if name == "" {
+			name = filepath.Base(osPath)
+		}
+		name, err := f.manageName(name)
+		if err != nil {
+			return "", err
+		}
+		target = filepath.Join(f.urlPathToOSPath(dir), name)
+	default:
+		return "", fmt.Errorf("%w: to %q is neither a name nor an absolute path", errInvalidManage, to)
+	}
+	if info, err := os.Stat(filepath.Dir(target)); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("%w: directory of %s does not exist", errManageConflict, to)
+	}
+	if err := f.confined(target); err != nil {
+		return "", err
+	}
+	if target == osPath || strings.HasPrefix(target, osPath+osPathSeparator) {
+		return "", fmt.Errorf("%w: cannot move %s into itself", errInvalidManage, filepath.Base(osPath))
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return "", fmt.Errorf("%w: %s exists", errManageConflict, filepath.Base(target))
+	}
+	return target, os.Rename(osPath, target)
+}
@@ -0,0 +1,426 @@
+package filehandler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var testManageAuth = Credentials{User: "admin", Password: "secret"}
+
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		isDir := strings.HasSuffix(name, "/")
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if isDir {
+			if err := os.Mkdir(name, 0700); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.WriteFile(name, []byte(body), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileHandler_serveManage(t *testing.T) {
+	existing := map[string]string{
+		"a.txt":        "alpha",
+		"docs/b.txt":   "beta",
+		"docs/c/d.txt": "delta",
+		"empty/":       "",
+	}
+	tests := []struct {
+		name         string
+		route        string
+		method       string
+		target       string
+		body         string
+		contentType  string
+		header       map[string]string
+		auth         *Credentials
+		wantStatus   int
+		wantLocation string
+		wantBody     string
+		wantFiles    map[string]string
+		wantDirs     []string
+	}{
+		{
+			name:       "unauthenticated",
+			method:     http.MethodDelete,
+			target:     "/a.txt",
+			auth:       &Credentials{},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong password",
+			method:     http.MethodDelete,
+			target:     "/a.txt",
+			auth:       &Credentials{User: "admin", Password: "guess"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "delete file",
+			method:     http.MethodDelete,
+			target:     "/a.txt",
+			wantStatus: http.StatusNoContent,
+			wantFiles:  map[string]string{"docs/b.txt": "beta", "docs/c/d.txt": "delta"},
+		},
+		{
+			name:       "delete empty directory",
+			method:     http.MethodDelete,
+			target:     "/empty/",
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "delete non-empty directory",
+			method:     http.MethodDelete,
+			target:     "/docs/",
+			wantStatus: http.StatusConflict,
+			wantDirs:   []string{"docs/c"},
+		},
+		{
+			name:       "delete directory recursively",
+			method:     http.MethodDelete,
+			target:     "/docs/?recursive=true",
+			wantStatus: http.StatusNoContent,
+			wantFiles:  map[string]string{"a.txt": "alpha"},
+		},
+		{
+			name:         "delete from the listing",
+			method:       http.MethodPost,
+			target:       "/docs/c/?manage=delete",
+			body:         "recursive=true",
+			contentType:  "application/x-www-form-urlencoded",
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/docs/",
+			wantFiles:    map[string]string{"a.txt": "alpha", "docs/b.txt": "beta"},
+		},
+		{
+			name:       "delete root",
+			method:     http.MethodDelete,
+			target:     "/",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "delete missing file",
+			method:     http.MethodDelete,
+			target:     "/z.txt",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:         "mkdir from the listing",
+			method:       http.MethodPost,
+			target:       "/docs/?manage=mkdir",
+			body:         "name=new",
+			contentType:  "application/x-www-form-urlencoded",
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/docs/",
+			wantDirs:     []string{"docs/new"},
+		},
+		{
+			name:         "mkdir from the listing with origin",
+			method:       http.MethodPost,
+			target:       "/docs/?manage=mkdir",
+			body:         "name=new",
+			contentType:  "application/x-www-form-urlencoded",
+			header:       map[string]string{"Origin": "http://example.com", "Referer": "http://example.com/docs/"},
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/docs/",
+			wantDirs:     []string{"docs/new"},
+		},
+		{
+			name:        "cross-origin form",
+			method:      http.MethodPost,
+			target:      "/?manage=delete",
+			body:        "recursive=true",
+			contentType: "application/x-www-form-urlencoded",
+			header:      map[string]string{"Origin": "http://attacker.example"},
+			wantStatus:  http.StatusForbidden,
+		},
+		{
+			name:        "cross-origin form by referer",
+			method:      http.MethodPost,
+			target:      "/docs/?manage=delete",
+			body:        "recursive=true",
+			contentType: "application/x-www-form-urlencoded",
+			header:      map[string]string{"Referer": "http://attacker.example/page"},
+			wantStatus:  http.StatusForbidden,
+		},
+		{
+			name:        "opaque origin",
+			method:      http.MethodPost,
+			target:      "/?manage=mkdir",
+			body:        "name=new",
+			contentType: "application/x-www-form-urlencoded",
+			header:      map[string]string{"Origin": "null"},
+			wantStatus:  http.StatusForbidden,
+		},
+		{
+			name:        "mkdir",
+			method:      http.MethodPost,
+			target:      "/?manage=mkdir",
+			body:        `{"name":"new"}`,
+			contentType: "application/json",
+			wantStatus:  http.StatusCreated,
+			wantBody:    `{"path":"/new/"}`,
+			wantDirs:    []string{"new"},
+		},
+		{
+			name:        "mkdir existing",
+			method:      http.MethodPost,
+			target:      "/?manage=mkdir",
+			body:        `{"name":"docs"}`,
+			contentType: "application/json",
+			wantStatus:  http.StatusConflict,
+			wantBody:    `{"error":"conflict: docs exists"}`,
+		},
+		{
+			name:        "mkdir path",
+			method:      http.MethodPost,
+			target:      "/?manage=mkdir",
+			body:        `{"name":"../new"}`,
+			contentType: "application/json",
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "unknown action",
+			method:      http.MethodPost,
+			target:      "/?manage=chmod",
+			contentType: "application/json",
+			body:        `{}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:         "rename",
+			method:       http.MethodPost,
+			target:       "/docs/b.txt?manage=move",
+			body:         "to=e.txt",
+			contentType:  "application/x-www-form-urlencoded",
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/docs/",
+			wantFiles:    map[string]string{"a.txt": "alpha", "docs/e.txt": "beta", "docs/c/d.txt": "delta"},
+		},
+		{
+			name:        "move into directory",
+			method:      http.MethodPost,
+			target:      "/a.txt?manage=move",
+			body:        `{"to":"/docs/c/"}`,
+			contentType: "application/json",
+			wantStatus:  http.StatusCreated,
+			wantBody:    `{"path":"/docs/c/a.txt"}`,
+			wantFiles:   map[string]string{"docs/c/a.txt": "alpha", "docs/b.txt": "beta", "docs/c/d.txt": "delta"},
+		},
+		{
+			name:        "move directory",
+			method:      http.MethodPost,
+			target:      "/docs/c/?manage=move",
+			body:        `{"to":"/empty/e"}`,
+			contentType: "application/json",
+			wantStatus:  http.StatusCreated,
+			wantBody:    `{"path":"/empty/e/"}`,
+			wantFiles:   map[string]string{"a.txt": "alpha", "docs/b.txt": "beta", "empty/e/d.txt": "delta"},
+		},
+		{
+			name:        "move within route",
+			route:       "/srv/",
+			method:      http.MethodPost,
+			target:      "/srv/a.txt?manage=move",
+			body:        `{"to":"/srv/docs/a.txt"}`,
+			contentType: "application/json",
+			wantStatus:  http.StatusCreated,
+			wantBody:    `{"path":"/srv/docs/a.txt"}`,
+			wantFiles:   map[string]string{"docs/a.txt": "alpha", "docs/b.txt": "beta", "docs/c/d.txt": "delta"},
+		},
+		{
+			name:        "move out of route",
+			route:       "/srv/",
+			method:      http.MethodPost,
+			target:      "/srv/a.txt?manage=move",
+			body:        `{"to":"/tmp/a.txt"}`,
+			contentType: "application/json",
+			wantStatus:  http.StatusForbidden,
+		},
+		{
+			name:        "move onto existing file",
+			method:      http.MethodPost,
+			target:      "/a.txt?manage=move",
+			body:        `{"to":"/docs/b.txt"}`,
+			contentType: "application/json",
+			wantStatus:  http.StatusConflict,
+		},
+		{
+			name:        "move into missing directory",
+			method:      http.MethodPost,
+			target:      "/a.txt?manage=move",
+			body:        `{"to":"/new/a.txt"}`,
+			contentType: "application/json",
+			wantStatus:  http.StatusConflict,
+		},
+		{
+			name:        "move into itself",
+			method:      http.MethodPost,
+			target:      "/docs/?manage=move",
+			body:        `{"to":"/docs/c/"}`,
+			contentType: "application/json",
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "move root",
+			method:      http.MethodPost,
+			target:      "/?manage=move",
+			body:        `{"to":"root"}`,
+			contentType: "application/json",
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, existing)
+			route := tt.route
+			if route == "" {
+				route = "/"
+			}
+			f := NewFileHandler(route, dir, false, Options{Manage: true, ManageAuth: testManageAuth})
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			auth := testManageAuth
+			if tt.auth != nil {
+				auth = *tt.auth
+			}
+			if auth.User != "" {
+				r.SetBasicAuth(auth.User, auth.Password)
+			}
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("fileHandler.ServeHTTP() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("fileHandler.ServeHTTP() sent no WWW-Authenticate header")
+			}
+			if tt.wantLocation != "" && w.Header().Get("Location") != tt.wantLocation {
+				t.Errorf("fileHandler.ServeHTTP() Location = %q, want %q", w.Header().Get("Location"), tt.wantLocation)
+			}
+			if tt.wantBody != "" && strings.TrimSpace(w.Body.String()) != tt.wantBody {
+				t.Errorf("fileHandler.ServeHTTP() body = %q, want %q", w.Body, tt.wantBody)
+			}
+			wantFiles := tt.wantFiles
+			if wantFiles == nil {
+				wantFiles = map[string]string{"a.txt": "alpha", "docs/b.txt": "beta", "docs/c/d.txt": "delta"}
+			}
+			got := readTree(dir)
+			if len(got) != len(wantFiles) {
+				t.Errorf("files = %v, want %v", got, wantFiles)
+			}
+			for name, body := range wantFiles {
+				if got[name] != body {
+					t.Errorf("files = %v, want %v", got, wantFiles)
+				}
+			}
+			for _, name := range tt.wantDirs {
+				if info, err := os.Stat(filepath.Join(dir, name)); err != nil || !info.IsDir() {
+					t.Errorf("directory %s missing: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFileHandler_serveManage_symlink(t *testing.T) {
+	outside := t.TempDir()
+	writeTree(t, outside, map[string]string{"secret.txt": "secret"})
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "alpha"})
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skip(err)
+	}
+	f := NewFileHandler("/", dir, false, Options{Manage: true, ManageAuth: testManageAuth})
+	for _, target := range []string{"/link/secret.txt", "/a.txt?manage=move"} {
+		r := httptest.NewRequest(http.MethodDelete, target, nil)
+		if strings.Contains(target, "manage=") {
+			r = httptest.NewRequest(http.MethodPost, target, strings.NewReader("to=/link/a.txt"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		r.SetBasicAuth(testManageAuth.User, testManageAuth.Password)
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("fileHandler.ServeHTTP(%s) status = %d, want %d", target, w.Code, http.StatusForbidden)
+		}
+	}
+	if got := readTree(outside); got["secret.txt"] != "secret" || len(got) != 1 {
+		t.Errorf("files outside the route = %v", got)
+	}
+	// the link itself may be deleted
+	r := httptest.NewRequest(http.MethodDelete, "/link", nil)
+	r.SetBasicAuth(testManageAuth.User, testManageAuth.Password)
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent || len(readTree(outside)) != 1 {
+		t.Errorf("fileHandler.ServeHTTP() status = %d deleting the link", w.Code)
+	}
+}
+
+func TestFileHandler_serveManage_disabled(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "alpha"})
+	f := NewFileHandler("/", dir, true, Options{ManageAuth: testManageAuth})
+	r := httptest.NewRequest(http.MethodDelete, "/a.txt", nil)
+	r.SetBasicAuth(testManageAuth.User, testManageAuth.Password)
+	f.ServeHTTP(httptest.NewRecorder(), r)
+	if got := readTree(dir); len(got) != 1 {
+		t.Errorf("files = %v", got)
+	}
+}
+
+func TestFileHandler_ServeHTTP_manageListingColumns(t *testing.T) {
+	rowPattern := regexp.MustCompile(`(?s)<(?:thead|tr)>(.*?)</(?:thead|tr)>`)
+	cellPattern := regexp.MustCompile(`<t[dh](?: colspan=(\d+))?[ >]`)
+	for _, manage := range []bool{false, true} {
+		t.Run(strconv.FormatBool(manage), func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, map[string]string{"a.txt": "alpha", "docs/": ""})
+			f := NewFileHandler("/", dir, true, Options{Manage: manage, ManageAuth: testManageAuth})
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			want := 4
+			if manage {
+				want = 5
+			}
+			rows := rowPattern.FindAllStringSubmatch(w.Body.String(), -1)
+			if len(rows) == 0 {
+				t.Fatalf("listing has no rows:\n%s", w.Body)
+			}
+			for _, row := range rows {
+				columns := 0
+				for _, cell := range cellPattern.FindAllStringSubmatch(row[1], -1) {
+					span := 1
+					if cell[1] != "" {
+						span, _ = strconv.Atoi(cell[1])
+					}
+					columns += span
+				}
+				if columns != want {
+					t.Errorf("row spans %d columns, want %d: %s", columns, want, row[1])
+				}
+			}
+		})
+	}
+}
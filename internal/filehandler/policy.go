@@ -0,0 +1,270 @@
+package filehandler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// sniffLen is the number of bytes content types are sniffed from.
+const sniffLen = 512
+
+var errUploadType = errors.New("upload type not accepted")
+
+// List is a comma-separated list of lower-case values. It implements
+// flag.Value.
+type List []string
+
+func (l *List) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set is flag.Value.Set
+func (l *List) Set(v string) error {
+	list := List{}
+	for _, value := range strings.Split(v, ",") {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			list = append(list, value)
+		}
+	}
+	*l = list
+	return nil
+}
+
+// NamePolicy decides what happens to uploaded files with names that are
+// unsafe to store or to use on other systems. It implements flag.Value.
+type NamePolicy string
+
+const (
+	// NamesKeep stores files under the names sent. It is the default.
+	NamesKeep NamePolicy = "keep"
+	// NamesReject refuses files with unsafe names with 400 Bad Request.
+	NamesReject NamePolicy = "reject"
+	// NamesSanitize replaces the unsafe parts of names.
+	NamesSanitize NamePolicy = "sanitize"
+)
+
+func (p NamePolicy) String() string {
+	if p == "" {
+		return string(NamesKeep)
+	}
+	return string(p)
+}
+
+// Set is flag.Value.Set
+func (p *NamePolicy) Set(v string) error {
+	switch policy := NamePolicy(v); policy {
+	case NamesKeep, NamesReject, NamesSanitize:
+		*p = policy
+		return nil
+	default:
+		return fmt.Errorf("unknown name policy %q, want one of %q, %q or %q", v, NamesKeep, NamesReject, NamesSanitize)
+	}
+}
+
+// UploadPolicy restricts the names and contents of uploaded files. The
+// zero value accepts everything. Rules are named after the route options
+// setting them, which are quoted in the errors of refused uploads.
+type UploadPolicy struct {
+	// AllowExtensions, if not empty, are the only file name extensions
+	// accepted, such as ".pdf" or ".tar.gz".
+	AllowExtensions List
+	// DenyExtensions are the file name extensions refused.
+	DenyExtensions List
+	// AllowTypes, if not empty, are the only media types accepted, as
+	// sniffed from the files' content. Entries may end in "/*" to match
+	// all subtypes.
+	AllowTypes List
+	// DenyTypes are the sniffed media types refused.
+	DenyTypes List
+	// Names decides what happens to unsafe names.
+	Names NamePolicy
+	// MaxNameLength limits each element of uploaded paths to this many
+	// bytes, 0 for no limit.
+	MaxNameLength int
+	// DenyDotfiles refuses paths with elements starting with a dot.
+	DenyDotfiles bool
+}
+
+// windowsForbidden are the characters not allowed in Windows file names,
+// besides control characters and the slash.
+const windowsForbidden = `<>:"\|?*`
+
+// reservedName reports whether name is a device name reserved by Windows,
+// with or without an extension.
+func reservedName(name string) bool {
+	base := strings.ToUpper(strings.TrimRight(strings.SplitN(name, ".", 2)[0], " "))
+	switch base {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	if len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) {
+		return base[3] >= '1' && base[3] <= '9'
+	}
+	return false
+}
+
+// unsafeRune reports whether r may not appear in portable file names.
+func unsafeRune(r rune) bool {
+	return r == utf8.RuneError || unicode.IsControl(r) || strings.ContainsRune(windowsForbidden, r)
+}
+
+// checkElement checks a single path element against the policy, returning
+// the sanitized element or an error naming the violated rule.
+func (p UploadPolicy) checkElement(elem string) (string, error) {
+	if p.DenyDotfiles && strings.HasPrefix(elem, ".") {
+		return "", fmt.Errorf("%w: %q starts with a dot (upload-deny-dotfiles)", errInvalidUpload, elem)
+	}
+	switch p.Names {
+	case NamesReject:
+		if err := unsafeName(elem); err != nil {
+			return "", fmt.Errorf("%w: %q %v (upload-names)", errInvalidUpload, elem, err)
+		}
+	case NamesSanitize:
+		elem = sanitizeName(elem, p.MaxNameLength)
+	}
+	if max := p.MaxNameLength; max > 0 && len(elem) > max {
+		return "", fmt.Errorf("%w: %q is longer than %d bytes (upload-max-name-length)", errInvalidUpload, elem, max)
+	}
+	return elem, nil
+}
+
+// unsafeName returns why name is unsafe, or nil.
+func unsafeName(name string) error {
+	if !utf8.ValidString(name) {
+		return errors.New("is not valid UTF-8")
+	}
+	for _, r := range name {
+		if unsafeRune(r) {
+			return fmt.Errorf("contains the character %U", r)
+		}
+	}
+	switch {
+	case reservedName(name):
+		return errors.New("is a reserved device name")
+	case strings.HasSuffix(name, ".") || strings.HasSuffix(name, " "):
+		return errors.New("ends with a dot or space")
+	case !norm.NFC.IsNormalString(name):
+		return errors.New("is not in Unicode normalization form C")
+	}
+	return nil
+}
+
+// sanitizeName makes name safe: unsafe characters are replaced by
+// underscores, the name is brought into Unicode normalization form C,
+// trailing dots and spaces are dropped, reserved names are prefixed by an underscore and names longer
+// than max bytes are shortened, keeping their extension.
+func sanitizeName(name string, max int) string {
+	name = strings.Map(func(r rune) rune {
+		if unsafeRune(r) {
+			return '_'
+		}
+		return r
+	}, strings.ToValidUTF8(name, "_"))
+	name = strings.TrimRight(norm.NFC.String(name), ". ")
+	if name == "" || reservedName(name) {
+		name = "_" + name
+	}
+	if max > 0 && len(name) > max {
+		ext := path.Ext(name)
+		if len(ext) >= max {
+			ext = ""
+		}
+		base := name[:max-len(ext)]
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+		name = base + ext
+	}
+	return name
+}
+
+// extension returns the entry of extensions name ends with, if any.
+func extension(extensions List, name string) (string, bool) {
+	name = strings.ToLower(name)
+	for _, ext := range extensions {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if strings.HasSuffix(name, ext) {
+			return ext, true
+		}
+	}
+	return "", false
+}
+
+// checkName checks the slash-separated relative path of an upload against
+// the policy, returning the path to store the file at.
+func (p UploadPolicy) checkName(rel string) (string, error) {
+	elems := strings.Split(rel, "/")
+	for i, elem := range elems {
+		var err error
+		if elems[i], err = p.checkElement(elem); err != nil {
+			return "", err
+		}
+	}
+	rel = strings.Join(elems, "/")
+	name := path.Base(rel)
+	if ext, ok := extension(p.DenyExtensions, name); ok {
+		return "", fmt.Errorf("%w: %s: extension %q is denied (upload-deny-ext)", errUploadType, rel, ext)
+	}
+	if _, ok := extension(p.AllowExtensions, name); len(p.AllowExtensions) > 0 && !ok {
+		return "", fmt.Errorf("%w: %s: extension %q is not allowed (upload-allow-ext)", errUploadType, rel, path.Ext(name))
+	}
+	return rel, nil
+}
+
+// matchType reports whether the media type t is one of types.
+func matchType(types List, t string) bool {
+	for _, pattern := range types {
+		if pattern == t || strings.HasSuffix(pattern, "/*") && strings.HasPrefix(t, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkType checks the media type sniffed from head, the start of the
+// file called name, against the policy.
+func (p UploadPolicy) checkType(name string, head []byte) error {
+	if len(p.AllowTypes) == 0 && len(p.DenyTypes) == 0 {
+		return nil
+	}
+	t := http.DetectContentType(head)
+	if i := strings.Index(t, ";"); i >= 0 {
+		t = t[:i]
+	}
+	if matchType(p.DenyTypes, t) {
+		return fmt.Errorf("%w: %s: type %s is denied (upload-deny-type)", errUploadType, name, t)
+	}
+	if len(p.AllowTypes) > 0 && !matchType(p.AllowTypes, t) {
+		return fmt.Errorf("%w: %s: type %s is not allowed (upload-allow-type)", errUploadType, name, t)
+	}
+	return nil
+}
+
+// sniffUpload checks the media type of the file read from in against the
+// policy, returning a reader of the whole file.
+func (p UploadPolicy) sniffUpload(name string, in io.Reader) (io.Reader, error) {
+	if len(p.AllowTypes) == 0 && len(p.DenyTypes) == 0 {
+		return in, nil
+	}
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(in, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	if err := p.checkType(name, head); err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(head), in), nil
+}
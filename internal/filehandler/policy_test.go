@@ -0,0 +1,159 @@
+package filehandler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUploadPolicy_checkName(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  UploadPolicy
+		rel     string
+		want    string
+		wantErr error
+		wantMsg string
+	}{
+		{name: "zero policy", rel: "docs/.env\x01", want: "docs/.env\x01"},
+		{
+			name:    "dotfile",
+			policy:  UploadPolicy{DenyDotfiles: true},
+			rel:     "docs/.env",
+			wantErr: errInvalidUpload,
+			wantMsg: "upload-deny-dotfiles",
+		},
+		{
+			name:    "dot directory",
+			policy:  UploadPolicy{DenyDotfiles: true},
+			rel:     ".git/config",
+			wantErr: errInvalidUpload,
+		},
+		{
+			name:    "control character",
+			policy:  UploadPolicy{Names: NamesReject},
+			rel:     "a\nb.txt",
+			wantErr: errInvalidUpload,
+			wantMsg: "U+000A (upload-names)",
+		},
+		{
+			name:    "reserved name",
+			policy:  UploadPolicy{Names: NamesReject},
+			rel:     "docs/com1.txt",
+			wantErr: errInvalidUpload,
+			wantMsg: "reserved",
+		},
+		{
+			name:    "decomposed letters",
+			policy:  UploadPolicy{Names: NamesReject},
+			rel:     "cafe\u0301.txt",
+			wantErr: errInvalidUpload,
+			wantMsg: "normalization form C",
+		},
+		{
+			name:    "decomposed Cyrillic letters",
+			policy:  UploadPolicy{Names: NamesReject},
+			rel:     "\u0438\u0306\u043e\u0434.txt",
+			wantErr: errInvalidUpload,
+			wantMsg: "normalization form C",
+		},
+		{
+			name:    "decomposed Hangul syllables",
+			policy:  UploadPolicy{Names: NamesReject},
+			rel:     "\u1112\u1161\u11ab.txt",
+			wantErr: errInvalidUpload,
+			wantMsg: "normalization form C",
+		},
+		{name: "safe name", policy: UploadPolicy{Names: NamesReject}, rel: "docs/café.txt", want: "docs/café.txt"},
+		{
+			name:   "sanitized",
+			policy: UploadPolicy{Names: NamesSanitize},
+			rel:    "a<b>/CON.tar.gz/cafe\u0301 \x7f?.",
+			want:   "a_b_/_CON.tar.gz/café __",
+		},
+		{
+			name:   "sanitized other scripts and mark orders",
+			policy: UploadPolicy{Names: NamesSanitize},
+			rel:    "A\u030angstro\u0308m/\u0435\u0308\u0436/\u1112\u1161\u11ab/a\u0302\u0323.txt",
+			want:   "\u00c5ngstr\u00f6m/\u0451\u0436/\ud55c/\u1ead.txt",
+		},
+		{
+			name:   "sanitized Vietnamese",
+			policy: UploadPolicy{Names: NamesSanitize},
+			rel:    "Vie\u0323\u0302t.txt",
+			want:   "Việt.txt",
+		},
+		{
+			name:    "too long",
+			policy:  UploadPolicy{MaxNameLength: 8},
+			rel:     "report-2020.pdf",
+			wantErr: errInvalidUpload,
+			wantMsg: "upload-max-name-length",
+		},
+		{
+			name:   "shortened",
+			policy: UploadPolicy{Names: NamesSanitize, MaxNameLength: 8},
+			rel:    "éééé.pdf",
+			want:   "éé.pdf",
+		},
+		{
+			name:    "denied extension",
+			policy:  UploadPolicy{DenyExtensions: List{"exe", ".php"}},
+			rel:     "setup.EXE",
+			wantErr: errUploadType,
+			wantMsg: `".exe" is denied (upload-deny-ext)`,
+		},
+		{
+			name:   "allowed extension",
+			policy: UploadPolicy{AllowExtensions: List{".pdf", ".tar.gz"}},
+			rel:    "build.tar.gz",
+			want:   "build.tar.gz",
+		},
+		{
+			name:    "extension not allowed",
+			policy:  UploadPolicy{AllowExtensions: List{".pdf", ".tar.gz"}},
+			rel:     "build.gz",
+			wantErr: errUploadType,
+			wantMsg: "upload-allow-ext",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.checkName(tt.rel)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("UploadPolicy.checkName() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("UploadPolicy.checkName() error = %v, want %q", err, tt.wantMsg)
+			}
+			if got != tt.want {
+				t.Errorf("UploadPolicy.checkName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadPolicy_checkType(t *testing.T) {
+	const html = "<!DOCTYPE html><html></html>"
+	png := "\x89PNG\r\n\x1a\n"
+	tests := []struct {
+		name    string
+		policy  UploadPolicy
+		content string
+		wantErr bool
+	}{
+		{name: "zero policy", content: html},
+		{name: "allowed subtype", policy: UploadPolicy{AllowTypes: List{"image/*"}}, content: png},
+		{name: "not allowed", policy: UploadPolicy{AllowTypes: List{"image/*", "application/pdf"}}, content: html, wantErr: true},
+		{name: "denied", policy: UploadPolicy{DenyTypes: List{"text/html"}}, content: html, wantErr: true},
+		{name: "not denied", policy: UploadPolicy{DenyTypes: List{"text/html"}}, content: "plain text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.checkType("a", []byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UploadPolicy.checkType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
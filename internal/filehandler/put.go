@@ -0,0 +1,88 @@
+package filehandler
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// servePut stores the request body as the file osPath, as sent by
+// curl -T, verifying it against the digests in the request headers, and
+// resolves conflicts with an existing file by policy. It
+// responds 201 Created for new files and 204 No Content for replaced ones,
+// with the stored file's URL and content digests.
+func (f *FileHandler) servePut(w http.ResponseWriter, r *http.Request, osPath string, policy ConflictPolicy) error {
+	dir, name := filepath.Split(osPath)
+	if strings.HasSuffix(r.URL.Path, "/") || name == "" || osPath == filepath.Clean(f.path) {
+		return fmt.Errorf("%w: PUT needs a file name", errInvalidUpload)
+	}
+	sent := name
+	name, err := f.options.UploadPolicy.checkName(name)
+	if err != nil {
+		return err
+	}
+	osPath = filepath.Join(dir, name)
+	limits := f.options.UploadLimits
+	for _, max := range []int64{limits.FileSize, limits.RequestSize} {
+		if max > 0 && r.ContentLength > max {
+			return fmt.Errorf("%w: %s of %d bytes exceeds limit", errUploadTooLarge, name, r.ContentLength)
+		}
+	}
+	if limits.RequestSize > 0 {
+		r.Body = &limitedBody{ReadCloser: r.Body, n: limits.RequestSize}
+	}
+	if err := f.makePutDirs(dir); err != nil {
+		return err
+	}
+	_, err = os.Lstat(osPath)
+	existed := err == nil
+
+	digests, err := parseDigestHeaders(r.Header)
+	if err != nil {
+		return err
+	}
+	v := newDigestVerifier(digests)
+	stored, _, err := f.storeUpload(dir, name, r.Body, v, policy)
+	if err != nil {
+		return err
+	}
+	location := *r.URL
+	location.RawQuery = ""
+	location.Path = path.Join(path.Dir(r.URL.Path), stored)
+	w.Header().Set("Location", location.String())
+	w.Header().Set("ETag", strconv.Quote(v.sha256()))
+	w.Header().Set("Repr-Digest", v.header())
+	if existed && stored == sent {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, uploadResult{Name: sent, StoredAs: stored, SHA256: v.sha256()})
+	return nil
+}
+
+// makePutDirs makes sure the directory dir of a PUT upload exists,
+// creating it below the route's path if the route allows it.
+func (f *FileHandler) makePutDirs(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%w: %s is not a directory", errUploadConflict, filepath.Base(dir))
+		}
+		return nil
+	}
+	if !f.options.UploadCreateDirs {
+		return fmt.Errorf("%w: directory %s does not exist", errUploadConflict, filepath.Base(dir))
+	}
+	root := filepath.Clean(f.path)
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return err
+	}
+	_, err = makeUploadDirs(root, filepath.ToSlash(rel))
+	return err
+}
@@ -0,0 +1,228 @@
+package filehandler
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFileHandler_servePut(t *testing.T) {
+	tests := []struct {
+		name         string
+		allowUpload  bool
+		options      Options
+		target       string
+		body         string
+		header       map[string]string
+		existing     map[string]string
+		wantStatus   int
+		wantLocation string
+		wantAllow    string
+		wantFiles    map[string]string
+	}{
+		{
+			name:         "new file",
+			allowUpload:  true,
+			target:       "/inbox/build.tar.gz",
+			body:         "data",
+			existing:     map[string]string{"inbox/old.txt": "old"},
+			wantStatus:   http.StatusCreated,
+			wantLocation: "/inbox/build.tar.gz",
+			wantFiles:    map[string]string{"inbox/old.txt": "old", "inbox/build.tar.gz": "data"},
+		},
+		{
+			name:         "replace file",
+			allowUpload:  true,
+			target:       "/old.txt",
+			body:         "new",
+			existing:     map[string]string{"old.txt": "older"},
+			wantStatus:   http.StatusNoContent,
+			wantLocation: "/old.txt",
+			wantFiles:    map[string]string{"old.txt": "new"},
+		},
+		{
+			name:       "uploads disabled",
+			target:     "/a.txt",
+			body:       "data",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantAllow:  "GET, HEAD",
+			wantFiles:  map[string]string{},
+		},
+		{
+			name:       "uploads disabled with manage",
+			options:    Options{Manage: true},
+			target:     "/a.txt",
+			body:       "data",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantAllow:  "GET, HEAD, POST",
+			wantFiles:  map[string]string{},
+		},
+		{
+			name:        "missing directory",
+			allowUpload: true,
+			target:      "/inbox/a.txt",
+			body:        "data",
+			wantStatus:  http.StatusConflict,
+			wantFiles:   map[string]string{},
+		},
+		{
+			name:         "create directories",
+			allowUpload:  true,
+			options:      Options{UploadCreateDirs: true},
+			target:       "/inbox/2020/a.txt",
+			body:         "data",
+			wantStatus:   http.StatusCreated,
+			wantLocation: "/inbox/2020/a.txt",
+			wantFiles:    map[string]string{"inbox/2020/a.txt": "data"},
+		},
+		{
+			name:        "file in the way",
+			allowUpload: true,
+			options:     Options{UploadCreateDirs: true},
+			target:      "/inbox/a.txt",
+			body:        "data",
+			existing:    map[string]string{"inbox": "file"},
+			wantStatus:  http.StatusConflict,
+			wantFiles:   map[string]string{"inbox": "file"},
+		},
+		{
+			name:        "reject existing",
+			allowUpload: true,
+			options:     Options{UploadConflict: ConflictReject},
+			target:      "/a.txt",
+			body:        "new",
+			existing:    map[string]string{"a.txt": "old"},
+			wantStatus:  http.StatusConflict,
+			wantFiles:   map[string]string{"a.txt": "old"},
+		},
+		{
+			name:         "rename existing",
+			allowUpload:  true,
+			options:      Options{UploadConflict: ConflictRename},
+			target:       "/a.txt",
+			body:         "new",
+			existing:     map[string]string{"a.txt": "old"},
+			wantStatus:   http.StatusCreated,
+			wantLocation: "/a%20%281%29.txt",
+			wantFiles:    map[string]string{"a.txt": "old", "a (1).txt": "new"},
+		},
+		{
+			name:        "too large",
+			allowUpload: true,
+			options:     Options{UploadLimits: UploadLimits{FileSize: 3}},
+			target:      "/a.txt",
+			body:        "data",
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantFiles:   map[string]string{},
+		},
+		{
+			name:         "matching digests",
+			allowUpload:  true,
+			target:       "/a.txt",
+			body:         "data",
+			header:       map[string]string{"Content-MD5": "jXd/OF09/siBXSD3SWAm3A==", "Content-Digest": "sha-512=:d8fOml2GuzhtRDu5Y5D6oSBjMVhpnIhEwwsTqwv5J2C35EFq6jl9uRtKwOXdVrjvfksGYWKrH9wIgxnObe/Idg==:"},
+			wantStatus:   http.StatusCreated,
+			wantLocation: "/a.txt",
+			wantFiles:    map[string]string{"a.txt": "data"},
+		},
+		{
+			name:        "digest mismatch",
+			allowUpload: true,
+			target:      "/a.txt",
+			body:        "data",
+			header:      map[string]string{"Digest": "SHA=x, SHA-256=jtP2rWhblZ6tcCJRjhr3bNgW+OjsfM3aHtQBjo8iI/g="},
+			existing:    map[string]string{"a.txt": "old"},
+			wantStatus:  http.StatusBadRequest,
+			wantFiles:   map[string]string{"a.txt": "old"},
+		},
+		{
+			name:        "malformed digest",
+			allowUpload: true,
+			target:      "/a.txt",
+			body:        "data",
+			header:      map[string]string{"Content-MD5": "data"},
+			wantStatus:  http.StatusBadRequest,
+			wantFiles:   map[string]string{},
+		},
+		{
+			name:         "sanitized name",
+			allowUpload:  true,
+			options:      Options{UploadPolicy: UploadPolicy{Names: NamesSanitize}},
+			target:       "/a%3F.txt",
+			body:         "data",
+			wantStatus:   http.StatusCreated,
+			wantLocation: "/a_.txt",
+			wantFiles:    map[string]string{"a_.txt": "data"},
+		},
+		{
+			name:        "extension not allowed",
+			allowUpload: true,
+			options:     Options{UploadPolicy: UploadPolicy{AllowExtensions: List{".tar.gz"}}},
+			target:      "/a.txt",
+			body:        "data",
+			wantStatus:  http.StatusUnsupportedMediaType,
+			wantFiles:   map[string]string{},
+		},
+		{
+			name:        "directory",
+			allowUpload: true,
+			target:      "/inbox/",
+			body:        "data",
+			existing:    map[string]string{"inbox/a.txt": "old"},
+			wantStatus:  http.StatusBadRequest,
+			wantFiles:   map[string]string{"inbox/a.txt": "old"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, body := range tt.existing {
+				os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700)
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f := NewFileHandler("/", dir, tt.allowUpload, tt.options)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, tt.target, strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			f.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("fileHandler.ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("fileHandler.ServeHTTP() Location = %q, want %q", got, tt.wantLocation)
+			}
+			if got := w.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("fileHandler.ServeHTTP() Allow = %q, want %q", got, tt.wantAllow)
+			}
+			if tt.wantLocation != "" {
+				sum := sha256.Sum256([]byte(tt.body))
+				if got, want := w.Header().Get("ETag"), strconv.Quote(hex.EncodeToString(sum[:])); got != want {
+					t.Errorf("fileHandler.ServeHTTP() ETag = %q, want %q", got, want)
+				}
+				if got, want := w.Header().Get("Repr-Digest"), "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":"; !strings.Contains(got, want) {
+					t.Errorf("fileHandler.ServeHTTP() Repr-Digest = %q, want %q", got, want)
+				}
+			}
+			got := readTree(dir)
+			if len(got) != len(tt.wantFiles) {
+				t.Errorf("stored files = %v, want %v", got, tt.wantFiles)
+			}
+			for name, body := range tt.wantFiles {
+				if got[name] != body {
+					t.Errorf("%s = %q, want %q", name, got[name], body)
+				}
+			}
+		})
+	}
+}
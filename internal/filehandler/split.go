@@ -0,0 +1,250 @@
+package filehandler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sgreben/httpfileserver/internal/archive"
+	"github.com/sgreben/httpfileserver/internal/archivecache"
+	"github.com/sgreben/httpfileserver/internal/bytesize"
+	"github.com/sgreben/httpfileserver/internal/limiter"
+)
+
+const (
+	splitKey     = "split"
+	partKey      = "part"
+	manifestPart = "manifest"
+
+	minPartSize = bytesize.MB
+)
+
+var errArchiveSplitDisabled = errors.New("split archives need the archive cache")
+
+// splitArchive describes an archive split into parts of partSize bytes,
+// the last of which may be shorter.
+type splitArchive struct {
+	name     string
+	size     int64
+	partSize int64
+}
+
+func (s splitArchive) parts() int {
+	if s.size == 0 {
+		return 1
+	}
+	return int((s.size + s.partSize - 1) / s.partSize)
+}
+
+// partName returns the file name of part n, counting from 1.
+func (s splitArchive) partName(n int) string {
+	return fmt.Sprintf("%s.part%03d", s.name, n)
+}
+
+// partSection returns the offset and length of part n in the archive.
+func (s splitArchive) partSection(n int) (offset, length int64) {
+	offset = int64(n-1) * s.partSize
+	length = s.partSize
+	if rest := s.size - offset; rest < length {
+		length = rest
+	}
+	return offset, length
+}
+
+// manifest returns the reassembly manifest: instructions in comment lines,
+// followed by the SHA-256 digests of the parts and of the whole archive in
+// the format read by sha256sum -c.
+func (s splitArchive) manifest(archive io.ReaderAt) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s (%d bytes) split into %d parts of at most %s\n", s.name, s.size, s.parts(), bytesize.Size(s.partSize))
+	fmt.Fprintf(&b, "# reassemble with: cat %s.part* > %s\n", s.name, s.name)
+	fmt.Fprintf(&b, "# verify with: sha256sum -c --ignore-missing %s.manifest\n", s.name)
+	whole := sha256.New()
+	for n := 1; n <= s.parts(); n++ {
+		offset, length := s.partSection(n)
+		part := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(part, whole), io.NewSectionReader(archive, offset, length)); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "%s  %s\n", hex.EncodeToString(part.Sum(nil)), s.partName(n))
+	}
+	fmt.Fprintf(&b, "%s  %s\n", hex.EncodeToString(whole.Sum(nil)), s.name)
+	return []byte(b.String()), nil
+}
+
+// archiveCacheKey returns the key an archive in format of a tree with the
+// given fingerprint is cached under.
+func archiveCacheKey(format, fingerprint string) string {
+	return strings.ReplaceAll(format, ".", "") + "-" + fingerprint
+}
+
+// archiveVersion identifies the bytes of a generation of the cached
+// archive for cacheKey. Unless archives are reproducible, generating the
+// archive of an unchanged tree again gives other bytes, so ETags and
+// derived cache entries must not be keyed by the tree alone.
+func archiveVersion(cacheKey, generation string) string {
+	return cacheKey + "." + generation
+}
+
+// cachedArchive returns the cached archive of the tree at osPath, generating
+// it first if needed.
+func (f *FileHandler) cachedArchive(r *http.Request, osPath, format string, archiver archiverFunc, opts archive.Options) (*os.File, string, error) {
+	cache := f.options.ArchiveCache
+	release, err := f.acquireArchiveSlot(r)
+	if err != nil {
+		return nil, "", err
+	}
+	defer release()
+	fingerprint, err := archive.Fingerprint(r.Context(), osPath, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	cacheKey := archiveCacheKey(format, fingerprint)
+	if file, ok := cache.Open(cacheKey); ok {
+		return file, archiveVersion(cacheKey, archivecache.Generation(file)), nil
+	}
+	cacheWriter, err := cache.Create(cacheKey)
+	if err == archivecache.ErrInProgress {
+		// Another request is generating the archive; the client may retry
+		// once it is done.
+		return nil, "", limiter.ErrBusy
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if err := archiver(r.Context(), cacheWriter, osPath, opts); err != nil {
+		cacheWriter.Abort()
+		return nil, "", err
+	}
+	if err := cacheWriter.Commit(); err != nil {
+		return nil, "", err
+	}
+	file, ok := cache.Open(cacheKey)
+	if !ok {
+		return nil, "", fmt.Errorf("archive of %q does not fit in the archive cache", osPath)
+	}
+	return file, archiveVersion(cacheKey, archivecache.Generation(file)), nil
+}
+
+// serveSplitArchive serves the archive of the tree at osPath split into
+// parts of the size given by the split query parameter: a listing of the
+// parts, a part selected by the part query parameter, or the reassembly
+// manifest. The archive is generated once into the archive cache, so parts
+// have a Content-Length and can be resumed.
+func (f *FileHandler) serveSplitArchive(w http.ResponseWriter, r *http.Request, osPath, format string, archiver archiverFunc, opts archive.Options) error {
+	query := r.URL.Query()
+	var partSize bytesize.Size
+	if err := partSize.Set(query.Get(splitKey)); err != nil || partSize < minPartSize {
+		return fmt.Errorf("%w: split %q, want at least %s", errInvalidArchiveRequest, query.Get(splitKey), minPartSize)
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return fmt.Errorf("%w: split archives of selections", errInvalidArchiveRequest)
+	}
+	if f.options.ArchiveCache == nil {
+		return errArchiveSplitDisabled
+	}
+	file, version, err := f.cachedArchive(r, osPath, format, archiver, opts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	s := splitArchive{
+		name:     filepath.Base(osPath) + "." + format,
+		size:     info.Size(),
+		partSize: int64(partSize),
+	}
+	switch part := query.Get(partKey); part {
+	case "":
+		manifest, err := f.splitManifest(file, version, s)
+		if err != nil {
+			return err
+		}
+		return f.serveSplitListing(w, r, s, int64(len(manifest)))
+	case manifestPart:
+		manifest, err := f.splitManifest(file, version, s)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename=%q`, s.name+".manifest"))
+		http.ServeContent(w, r, "", info.ModTime(), strings.NewReader(string(manifest)))
+		return nil
+	default:
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 1 || n > s.parts() {
+			return fmt.Errorf("%w: part %q of %d", errInvalidArchiveRequest, part, s.parts())
+		}
+		offset, length := s.partSection(n)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename=%q`, s.partName(n)))
+		w.Header().Set("ETag", strconv.Quote(fmt.Sprintf("%s-%d-%d", version, s.partSize, n)))
+		http.ServeContent(w, r, "", info.ModTime(), io.NewSectionReader(file, offset, length))
+		return nil
+	}
+}
+
+// splitManifest returns the manifest of s, which is kept in the archive
+// cache next to the archive since it takes a pass over the whole archive.
+func (f *FileHandler) splitManifest(file *os.File, version string, s splitArchive) ([]byte, error) {
+	cache := f.options.ArchiveCache
+	manifestKey := fmt.Sprintf("%s-split%d", version, s.partSize)
+	if cached, ok := cache.Open(manifestKey); ok {
+		defer cached.Close()
+		return io.ReadAll(cached)
+	}
+	manifest, err := s.manifest(file)
+	if err != nil {
+		return nil, err
+	}
+	if cacheWriter, err := cache.Create(manifestKey); err == nil {
+		if _, err := cacheWriter.Write(manifest); err != nil {
+			cacheWriter.Abort()
+		} else {
+			_ = cacheWriter.Commit()
+		}
+	}
+	return manifest, nil
+}
+
+// serveSplitListing lists the parts of s and its manifest like the files
+// of a directory.
+func (f *FileHandler) serveSplitListing(w http.ResponseWriter, r *http.Request, s splitArchive, manifestSize int64) error {
+	partURL := func(part string) *url.URL {
+		u := *r.URL
+		query := u.Query()
+		query.Set(partKey, part)
+		u.RawQuery = query.Encode()
+		return &u
+	}
+	var files []directoryListingFileData
+	for n := 1; n <= s.parts(); n++ {
+		_, length := s.partSection(n)
+		files = append(files, directoryListingFileData{
+			Name: s.partName(n),
+			Size: fileSizeBytes(length),
+			URL:  partURL(strconv.Itoa(n)),
+		})
+	}
+	files = append(files, directoryListingFileData{
+		Name: s.name + ".manifest",
+		Size: fileSizeBytes(manifestSize),
+		URL:  partURL(manifestPart),
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return directoryListingTemplate.Execute(w, directoryListingData{
+		Title: s.name,
+		Files: files,
+	})
+}
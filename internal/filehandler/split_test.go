@@ -0,0 +1,165 @@
+package filehandler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sgreben/httpfileserver/internal/archivecache"
+)
+
+func Test_splitArchive_parts(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int64
+		wantParts   int
+		wantLastLen int64
+	}{
+		{name: "empty", size: 0, wantParts: 1, wantLastLen: 0},
+		{name: "single part", size: 7, wantParts: 1, wantLastLen: 7},
+		{name: "exact multiple", size: 20, wantParts: 2, wantLastLen: 10},
+		{name: "remainder", size: 21, wantParts: 3, wantLastLen: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := splitArchive{name: "a.tar.gz", size: tt.size, partSize: 10}
+			if got := s.parts(); got != tt.wantParts {
+				t.Fatalf("splitArchive.parts() = %d, want %d", got, tt.wantParts)
+			}
+			offset, length := s.partSection(s.parts())
+			if want := int64(tt.wantParts-1) * 10; offset != want || length != tt.wantLastLen {
+				t.Errorf("splitArchive.partSection() = %d, %d, want %d, %d", offset, length, want, tt.wantLastLen)
+			}
+		})
+	}
+	if got := (splitArchive{name: "a.tar.gz"}).partName(12); got != "a.tar.gz.part012" {
+		t.Errorf("splitArchive.partName() = %q", got)
+	}
+}
+
+func Test_splitArchive_manifest(t *testing.T) {
+	data := []byte("0123456789abc")
+	s := splitArchive{name: "a.tar", size: int64(len(data)), partSize: 10}
+	got, err := s.manifest(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("splitArchive.manifest() error = %v", err)
+	}
+	sum := func(b []byte) string {
+		h := sha256.Sum256(b)
+		return hex.EncodeToString(h[:])
+	}
+	for _, want := range []string{
+		"# reassemble with: cat a.tar.part* > a.tar\n",
+		sum(data[:10]) + "  a.tar.part001\n",
+		sum(data[10:]) + "  a.tar.part002\n",
+		sum(data) + "  a.tar\n",
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("splitArchive.manifest() = %q, want line %q", got, want)
+		}
+	}
+}
+
+func TestFileHandler_ServeHTTP_splitArchive(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 2*minPartSize+1000)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data.bin"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := archivecache.New(t.TempDir(), 1<<30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFileHandler("/", dir, false, Options{ArchiveFormats: ArchiveFormats{ArchiveFormatTar}, ArchiveCache: cache})
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		return w
+	}
+
+	listing := get("/?tar=true&split=1M", nil)
+	if listing.Code != http.StatusOK {
+		t.Fatalf("listing status = %d, want %d", listing.Code, http.StatusOK)
+	}
+	for _, name := range []string{"part001", "part002", "part003", ".manifest"} {
+		if !strings.Contains(listing.Body.String(), name) {
+			t.Errorf("listing does not link %q", name)
+		}
+	}
+	if strings.Contains(listing.Body.String(), "part004") {
+		t.Errorf("listing links a fourth part")
+	}
+
+	var joined []byte
+	for _, part := range []string{"1", "2", "3"} {
+		w := get("/?tar=true&split=1M&part="+part, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("part %s status = %d, want %d", part, w.Code, http.StatusOK)
+		}
+		joined = append(joined, w.Body.Bytes()...)
+	}
+	whole := get("/?tar=true", nil)
+	if !bytes.Equal(joined, whole.Body.Bytes()) {
+		t.Errorf("joined parts (%d bytes) differ from the archive (%d bytes)", len(joined), whole.Body.Len())
+	}
+
+	manifest := get("/?tar=true&split=1M&part=manifest", nil)
+	sum := sha256.Sum256(joined)
+	if !strings.Contains(manifest.Body.String(), hex.EncodeToString(sum[:])+"  "+filepath.Base(dir)+".tar\n") {
+		t.Errorf("manifest = %q, want digest of the whole archive", manifest.Body.String())
+	}
+
+	resumed := get("/?tar=true&split=1M&part=2", http.Header{"Range": {"bytes=10-"}})
+	if resumed.Code != http.StatusPartialContent || !bytes.Equal(resumed.Body.Bytes(), joined[minPartSize+10:2*minPartSize]) {
+		t.Errorf("resumed part status = %d, %d bytes", resumed.Code, resumed.Body.Len())
+	}
+
+	tests := []struct {
+		name       string
+		handler    *FileHandler
+		target     string
+		wantStatus int
+	}{
+		{
+			name:       "part size too small",
+			handler:    f,
+			target:     "/?tar=true&split=1K",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no such part",
+			handler:    f,
+			target:     "/?tar=true&split=1M&part=4",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "no cache",
+			handler:    NewFileHandler("/", dir, false, Options{}),
+			target:     "/?tar.gz=true&split=1M",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("fileHandler.ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package filehandler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sgreben/httpfileserver/internal/tus"
+)
+
+const (
+	tusResumableHeader   = "Tus-Resumable"
+	tusVersion           = "1.0.0"
+	tusExtensions        = "creation,expiration,termination,checksum"
+	tusUploadKey         = "tus-upload"
+	tusOffsetContentType = "application/offset+octet-stream"
+
+	// tusChecksumAlgorithms are the Upload-Checksum algorithms accepted,
+	// by their names in the tus checksum extension.
+	tusChecksumAlgorithms = "md5,sha256,sha512"
+)
+
+var (
+	errTusVersion     = errors.New("unsupported tus version")
+	errTusContentType = errors.New("PATCH needs Content-Type " + tusOffsetContentType)
+	errTusChecksum    = errors.New("upload checksum mismatch")
+)
+
+// statusTusChecksumMismatch is the status of PATCH requests whose data
+// does not match their Upload-Checksum.
+const statusTusChecksumMismatch = 460
+
+// isTusRequest reports whether r is a request of the tus resumable upload
+// protocol rather than a plain HTTP request.
+func isTusRequest(r *http.Request) bool {
+	return r.Header.Get(tusResumableHeader) != "" || r.URL.Query().Get(tusUploadKey) != ""
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated
+// pairs of a key and a base64-encoded value, the value being optional.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 0:
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("%w: metadata %q: %v", errInvalidUpload, fields[0], err)
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("%w: metadata %q", errInvalidUpload, pair)
+		}
+	}
+	return metadata, nil
+}
+
+// parseTusChecksum decodes an Upload-Checksum header: the name of the
+// algorithm and the base64-encoded digest, separated by a space.
+func parseTusChecksum(header string) (uploadDigest, error) {
+	fields := strings.Fields(header)
+	if len(fields) != 2 {
+		return uploadDigest{}, fmt.Errorf("%w: Upload-Checksum %q", errInvalidUpload, header)
+	}
+	algorithm := digestAlgorithm(fields[0])
+	if algorithm == "" {
+		return uploadDigest{}, fmt.Errorf("%w: unsupported Upload-Checksum algorithm %q", errInvalidUpload, fields[0])
+	}
+	sum, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return uploadDigest{}, fmt.Errorf("%w: Upload-Checksum %q", errInvalidUpload, header)
+	}
+	return newUploadDigest(algorithm, sum)
+}
+
+// tusFileName returns the file name sent in upload metadata, under the key
+// used by tus-js-client or the one used by Uppy.
+func tusFileName(metadata map[string]string) string {
+	if name := metadata["filename"]; name != "" {
+		return name
+	}
+	return metadata["name"]
+}
+
+// tusOwner identifies the route of f in the upload store, which is shared
+// by all routes, so that uploads are only continued on the route and
+// directory they were created for.
+func (f *FileHandler) tusOwner() string {
+	return f.route + "=" + f.path
+}
+
+// tusStoredLocation returns the escaped URL of the file a completed upload
+// to the directory at r's URL was stored as.
+func tusStoredLocation(r *http.Request, stored string) string {
+	return (&url.URL{Path: path.Join(r.URL.Path, stored)}).String()
+}
+
+func setTusExpires(w http.ResponseWriter, u tus.Upload) {
+	w.Header().Set("Upload-Expires", u.Expires.Format(http.TimeFormat))
+}
+
+// serveTus serves the tus protocol for resumable uploads into the
+// directory osPath. Uploads are created by POST to the directory, and
+// addressed by the directory URL with a tus-upload query parameter.
+func (f *FileHandler) serveTus(w http.ResponseWriter, r *http.Request, osPath string) error {
+	w.Header().Set(tusResumableHeader, tusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Checksum-Algorithm", tusChecksumAlgorithms)
+		if max := f.options.UploadLimits.FileSize; max > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(max, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	if r.Header.Get(tusResumableHeader) != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		return fmt.Errorf("%w: %q", errTusVersion, r.Header.Get(tusResumableHeader))
+	}
+	id := r.URL.Query().Get(tusUploadKey)
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		return f.serveTusCreate(w, r, osPath)
+	case id == "":
+		return fmt.Errorf("%w: %s without %s", errInvalidUpload, r.Method, tusUploadKey)
+	case r.Method == http.MethodHead:
+		u, err := f.options.UploadStore.Get(id, f.tusOwner())
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		setTusExpires(w, u)
+		w.WriteHeader(http.StatusOK)
+		return nil
+	case r.Method == http.MethodPatch:
+		return f.serveTusPatch(w, r, id)
+	case r.Method == http.MethodDelete:
+		if err := f.options.UploadStore.Terminate(id, f.tusOwner()); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	default:
+		w.Header().Set("Allow", "OPTIONS, POST, HEAD, PATCH, DELETE")
+		_ = f.serveStatus(w, r, http.StatusMethodNotAllowed)
+		return nil
+	}
+}
+
+func (f *FileHandler) serveTusCreate(w http.ResponseWriter, r *http.Request, osPath string) error {
+	if info, err := os.Stat(osPath); err != nil || !info.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", errUploadConflict, path.Base(r.URL.Path))
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return fmt.Errorf("%w: Upload-Length %q", errInvalidUpload, r.Header.Get("Upload-Length"))
+	}
+	if max := f.options.UploadLimits.FileSize; max > 0 && length > max {
+		return fmt.Errorf("%w: upload of %d bytes exceeds limit", errUploadTooLarge, length)
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		return err
+	}
+	rel, err := uploadPath(tusFileName(metadata))
+	if err != nil {
+		return err
+	}
+	if rel, err = f.options.UploadPolicy.checkName(rel); err != nil {
+		return err
+	}
+	if checksum, ok := metadata[uploadChecksumKey]; ok {
+		if _, err := parseChecksum(checksum); err != nil {
+			return err
+		}
+	}
+	if f.options.UploadConflict == ConflictReject {
+		if _, err := os.Lstat(filepath.Join(osPath, filepath.FromSlash(rel))); err == nil {
+			return fmt.Errorf("%w: %s exists", errUploadConflict, rel)
+		}
+	}
+	u, err := f.options.UploadStore.Create(length, metadata, f.tusOwner(), osPath)
+	if err != nil {
+		return err
+	}
+	location := *r.URL
+	location.RawQuery = tusUploadKey + "=" + u.ID
+	w.Header().Set("Location", location.String())
+	setTusExpires(w, u)
+	if u.Complete() {
+		stored, v, err := f.completeTusUpload(u)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Location", tusStoredLocation(r, stored))
+		w.Header().Set("ETag", strconv.Quote(v.sha256()))
+		w.Header().Set("Repr-Digest", v.header())
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (f *FileHandler) serveTusPatch(w http.ResponseWriter, r *http.Request, id string) error {
+	if r.Header.Get("Content-Type") != tusOffsetContentType {
+		return errTusContentType
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return fmt.Errorf("%w: Upload-Offset %q", errInvalidUpload, r.Header.Get("Upload-Offset"))
+	}
+	if max := f.options.UploadLimits.RequestSize; max > 0 {
+		if r.ContentLength > max {
+			return fmt.Errorf("%w: request of %d bytes exceeds limit", errUploadTooLarge, r.ContentLength)
+		}
+		r.Body = &limitedBody{ReadCloser: r.Body, n: max}
+	}
+	var body io.Reader = r.Body
+	var verify func() error
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		d, err := parseTusChecksum(header)
+		if err != nil {
+			return err
+		}
+		v := newDigestVerifier([]uploadDigest{d})
+		body = io.TeeReader(r.Body, v)
+		verify = func() error {
+			if err := v.verify("request"); err != nil {
+				return fmt.Errorf("%w: %v", errTusChecksum, err)
+			}
+			return nil
+		}
+	}
+	u, err := f.options.UploadStore.Append(id, f.tusOwner(), offset, body, verify)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	if !u.Complete() {
+		setTusExpires(w, u)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	stored, v, err := f.completeTusUpload(u)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Location", tusStoredLocation(r, stored))
+	w.Header().Set("ETag", strconv.Quote(v.sha256()))
+	w.Header().Set("Repr-Digest", v.header())
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// completeTusUpload moves a complete upload from the staging directory to
+// its destination according to the conflict policy, returning the
+// slash-separated path it was stored under and the digests of its data.
+func (f *FileHandler) completeTusUpload(u tus.Upload) (string, *digestVerifier, error) {
+	store := f.options.UploadStore
+	defer store.Remove(u.ID)
+	rel, dir, err := f.uploadTarget(u.Dir, tusFileName(u.Metadata))
+	if err != nil {
+		return "", nil, err
+	}
+	parent, name := path.Split(rel)
+	staged := store.DataPath(u.ID)
+	v, err := f.checkStaged(staged, rel, u.Metadata[uploadChecksumKey])
+	if err != nil {
+		return "", nil, err
+	}
+	stored, err := f.options.UploadConflict.commit(staged, dir, name)
+	if errors.Is(err, syscall.EXDEV) {
+		// the staging directory is on another file system
+		in, openErr := os.Open(staged)
+		if openErr != nil {
+			return "", nil, openErr
+		}
+		defer in.Close()
+		stored, _, err = f.storeUpload(dir, name, in, newDigestVerifier(nil), f.options.UploadConflict)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return parent + stored, v, nil
+}
+
+// checkStaged checks the staged data of an upload against the upload
+// policy's media types and the checksum sent in its metadata, if any,
+// returning the digests of the data.
+func (f *FileHandler) checkStaged(staged, name, checksum string) (*digestVerifier, error) {
+	in, err := os.Open(staged)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(in, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if err := f.options.UploadPolicy.checkType(name, head[:n]); err != nil {
+		return nil, err
+	}
+	var want []uploadDigest
+	if checksum != "" {
+		d, err := parseChecksum(checksum)
+		if err != nil {
+			return nil, err
+		}
+		want = append(want, d)
+	}
+	v := newDigestVerifier(want)
+	v.Write(head[:n])
+	if _, err := io.Copy(v, in); err != nil {
+		return nil, err
+	}
+	return v, v.verify(name)
+}
+
+// serveTusError responds to a failed tus request with a status matching
+// err.
+func (f *FileHandler) serveTusError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, tus.ErrNotFound):
+		_ = f.serveStatus(w, r, http.StatusNotFound)
+	case errors.Is(err, tus.ErrOffsetMismatch):
+		log.Printf("[%s] %s %v", f.path, r.RemoteAddr, err)
+		_ = f.serveStatus(w, r, http.StatusConflict)
+	case errors.Is(err, tus.ErrLocked):
+		_ = f.serveStatus(w, r, http.StatusLocked)
+	case errors.Is(err, tus.ErrTooLong):
+		log.Printf("[%s] %s %v", f.path, r.RemoteAddr, err)
+		_ = f.serveStatus(w, r, http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errTusVersion):
+		_ = f.serveStatus(w, r, http.StatusPreconditionFailed)
+	case errors.Is(err, errTusContentType):
+		_ = f.serveStatus(w, r, http.StatusUnsupportedMediaType)
+	case errors.Is(err, errTusChecksum):
+		log.Printf("[%s] %s %v", f.path, r.RemoteAddr, err)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(statusTusChecksumMismatch)
+		fmt.Fprintln(w, "Checksum Mismatch")
+	default:
+		f.serveUploadError(w, r, err)
+	}
+}
@@ -0,0 +1,475 @@
+package filehandler
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgreben/httpfileserver/internal/tus"
+)
+
+func newTusHandler(t *testing.T, dir string, options Options) *FileHandler {
+	t.Helper()
+	store, err := tus.NewStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.UploadStore = store
+	return NewFileHandler("/", dir, true, options)
+}
+
+func newTusRequest(method, target string, body string, header map[string]string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set(tusResumableHeader, tusVersion)
+	for k, v := range header {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func tusMetadata(name string) string {
+	return "filename " + base64.StdEncoding.EncodeToString([]byte(name))
+}
+
+func TestFileHandler_serveTus(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "inbox"), 0700)
+	f := newTusHandler(t, dir, Options{})
+
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, newTusRequest(http.MethodOptions, "/inbox/", "", nil))
+	if w.Code != http.StatusNoContent || w.Header().Get("Tus-Version") != tusVersion || w.Header().Get("Tus-Extension") != tusExtensions || w.Header().Get("Tus-Checksum-Algorithm") != tusChecksumAlgorithms {
+		t.Fatalf("OPTIONS = %d %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, newTusRequest(http.MethodPost, "/inbox/", "", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": tusMetadata("docs/a.txt"),
+	}))
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusCreated || !strings.HasPrefix(location, "/inbox/?"+tusUploadKey+"=") {
+		t.Fatalf("POST = %d, Location %q", w.Code, location)
+	}
+
+	patch := func(offset int, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, newTusRequest(http.MethodPatch, location, body, map[string]string{
+			"Content-Type":  tusOffsetContentType,
+			"Upload-Offset": strconv.Itoa(offset),
+		}))
+		return w
+	}
+	if w := patch(0, "01234"); w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("PATCH = %d, Upload-Offset %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if w := patch(0, "01234"); w.Code != http.StatusConflict {
+		t.Errorf("PATCH at a stale offset = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, newTusRequest(http.MethodHead, location, "", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "5" || w.Header().Get("Upload-Length") != "10" {
+		t.Fatalf("HEAD = %d %v", w.Code, w.Header())
+	}
+	if got := readTree(filepath.Join(dir, "inbox")); len(got) != 0 {
+		t.Errorf("incomplete upload visible as %v", got)
+	}
+
+	if w := patch(5, "56789x"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("PATCH beyond the length = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	w = patch(5, "56789")
+	if w.Code != http.StatusNoContent || w.Header().Get("Content-Location") != "/inbox/docs/a.txt" {
+		t.Fatalf("PATCH = %d, Content-Location %q", w.Code, w.Header().Get("Content-Location"))
+	}
+	if got, want := w.Header().Get("Repr-Digest"), "sha-256=:hNiYd/DUBB77a/kaFvAkjy/Vc+avBcGflr7bn4gveII=:"; got != want {
+		t.Errorf("PATCH Repr-Digest = %q, want %q", got, want)
+	}
+	if got := readTree(dir); len(got) != 1 || got["inbox/docs/a.txt"] != "0123456789" {
+		t.Errorf("stored files = %v", got)
+	}
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, newTusRequest(http.MethodHead, location, "", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("HEAD of a completed upload = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestFileHandler_serveTus_contentLocation(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "a.txt", want: "/a.txt"},
+		{name: "a b.txt", want: "/a%20b.txt"},
+		{name: "100%.txt", want: "/100%25.txt"},
+		{name: "résumé.txt", want: "/r%C3%A9sum%C3%A9.txt"},
+		{name: "docs/#1.txt", want: "/docs/%231.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTusHandler(t, t.TempDir(), Options{})
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, newTusRequest(http.MethodPost, "/", "", map[string]string{
+				"Upload-Length":   "1",
+				"Upload-Metadata": tusMetadata(tt.name),
+			}))
+			location := w.Header().Get("Location")
+			w = httptest.NewRecorder()
+			f.ServeHTTP(w, newTusRequest(http.MethodPatch, location, "x", map[string]string{
+				"Content-Type":  tusOffsetContentType,
+				"Upload-Offset": "0",
+			}))
+			if got := w.Header().Get("Content-Location"); w.Code != http.StatusNoContent || got != tt.want {
+				t.Errorf("PATCH = %d, Content-Location %q, want %q", w.Code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileHandler_serveTus_contentLocationBelowListing(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"données/": ""})
+	f := newTusHandler(t, dir, Options{})
+	// as in the location of the listing the upload form runs on
+	listing := (&url.URL{Path: "/données/"}).String()
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, newTusRequest(http.MethodPost, listing, "", map[string]string{
+		"Upload-Length":   "1",
+		"Upload-Metadata": tusMetadata("sub/résumé #1.txt"),
+	}))
+	location := w.Header().Get("Location")
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, newTusRequest(http.MethodPatch, location, "x", map[string]string{
+		"Content-Type":  tusOffsetContentType,
+		"Upload-Offset": "0",
+	}))
+	stored := w.Header().Get("Content-Location")
+	if w.Code != http.StatusNoContent || !strings.HasPrefix(stored, listing) {
+		t.Fatalf("PATCH = %d, Content-Location %q, want it below %q", w.Code, stored, listing)
+	}
+	segments := strings.Split(strings.TrimPrefix(stored, listing), "/")
+	for i, segment := range segments {
+		segments[i], _ = url.PathUnescape(segment)
+	}
+	if got, want := strings.Join(segments, "/"), "sub/résumé #1.txt"; got != want {
+		t.Errorf("Content-Location %q names %q, want %q", stored, got, want)
+	}
+	if got := readTree(dir); got["données/sub/résumé #1.txt"] != "x" {
+		t.Errorf("stored files = %v", got)
+	}
+}
+
+func TestFileHandler_serveTus_checksum(t *testing.T) {
+	tests := []struct {
+		name       string
+		checksum   string
+		wantStatus int
+		wantFiles  map[string]string
+	}{
+		{
+			name:       "match",
+			checksum:   "sha256:8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8",
+			wantStatus: http.StatusNoContent,
+			wantFiles:  map[string]string{"a.txt": "alpha"},
+		},
+		{
+			name:       "mismatch",
+			checksum:   "sha256:f144a6907dc4284d1f9fe6a7d9b9ff53c02c1d07ba68f24d413d7ff7f757a782",
+			wantStatus: http.StatusBadRequest,
+			wantFiles:  map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			f := newTusHandler(t, dir, Options{})
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, newTusRequest(http.MethodPost, "/", "", map[string]string{
+				"Upload-Length":   "5",
+				"Upload-Metadata": tusMetadata("a.txt") + ",checksum " + base64.StdEncoding.EncodeToString([]byte(tt.checksum)),
+			}))
+			location := w.Header().Get("Location")
+			w = httptest.NewRecorder()
+			f.ServeHTTP(w, newTusRequest(http.MethodPatch, location, "alpha", map[string]string{
+				"Content-Type":  tusOffsetContentType,
+				"Upload-Offset": "0",
+			}))
+			if w.Code != tt.wantStatus {
+				t.Errorf("PATCH status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := readTree(dir); len(got) != len(tt.wantFiles) || got["a.txt"] != tt.wantFiles["a.txt"] {
+				t.Errorf("stored files = %v, want %v", got, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestFileHandler_serveTus_uploadChecksum(t *testing.T) {
+	tests := []struct {
+		name       string
+		checksum   string
+		wantStatus int
+		wantOffset string
+	}{
+		{
+			name:       "match",
+			checksum:   "sha256 jtP2rWhblZ6tcCJRjhr3bNgW+OjsfM3aHtQBjo8iI/g=",
+			wantStatus: http.StatusNoContent,
+			wantOffset: "5",
+		},
+		{
+			name:       "md5",
+			checksum:   "md5 LBdDo5EwX782ffjk8Gn5+Q==",
+			wantStatus: http.StatusNoContent,
+			wantOffset: "5",
+		},
+		{
+			name:       "mismatch",
+			checksum:   "sha256 hNiYd/DUBB77a/kaFvAkjy/Vc+avBcGflr7bn4gveII=",
+			wantStatus: statusTusChecksumMismatch,
+			wantOffset: "0",
+		},
+		{
+			name:       "unsupported algorithm",
+			checksum:   "crc32 AAAAAA==",
+			wantStatus: http.StatusBadRequest,
+			wantOffset: "0",
+		},
+		{
+			name:       "malformed",
+			checksum:   "sha256",
+			wantStatus: http.StatusBadRequest,
+			wantOffset: "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTusHandler(t, t.TempDir(), Options{})
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, newTusRequest(http.MethodPost, "/", "", map[string]string{
+				"Upload-Length":   "10",
+				"Upload-Metadata": tusMetadata("a.txt"),
+			}))
+			location := w.Header().Get("Location")
+			w = httptest.NewRecorder()
+			f.ServeHTTP(w, newTusRequest(http.MethodPatch, location, "alpha", map[string]string{
+				"Content-Type":    tusOffsetContentType,
+				"Upload-Offset":   "0",
+				"Upload-Checksum": tt.checksum,
+			}))
+			if w.Code != tt.wantStatus {
+				t.Errorf("PATCH status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			w = httptest.NewRecorder()
+			f.ServeHTTP(w, newTusRequest(http.MethodHead, location, "", nil))
+			if got := w.Header().Get("Upload-Offset"); got != tt.wantOffset {
+				t.Errorf("Upload-Offset = %q, want %q", got, tt.wantOffset)
+			}
+		})
+	}
+}
+
+func TestFileHandler_serveTus_terminate(t *testing.T) {
+	dir := t.TempDir()
+	f := newTusHandler(t, dir, Options{})
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, newTusRequest(http.MethodPost, "/", "", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": tusMetadata("a.txt"),
+	}))
+	location := w.Header().Get("Location")
+	for _, want := range []int{http.StatusNoContent, http.StatusNotFound} {
+		w = httptest.NewRecorder()
+		f.ServeHTTP(w, newTusRequest(http.MethodDelete, location, "", nil))
+		if w.Code != want {
+			t.Errorf("DELETE = %d, want %d", w.Code, want)
+		}
+	}
+
+	// an upload whose data is complete is being moved into place by the
+	// request that completed it
+	u, err := f.options.UploadStore.Create(5, map[string]string{"filename": "b.txt"}, f.tusOwner(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.options.UploadStore.Append(u.ID, f.tusOwner(), 0, strings.NewReader("bravo"), nil); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	f.ServeHTTP(w, newTusRequest(http.MethodDelete, "/?"+tusUploadKey+"="+u.ID, "", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a completing upload = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if _, err := os.Stat(f.options.UploadStore.DataPath(u.ID)); err != nil {
+		t.Errorf("DELETE removed the data of a completing upload: %v", err)
+	}
+}
+
+func TestFileHandler_serveTus_otherRoute(t *testing.T) {
+	store, err := tus.NewStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, otherDir := t.TempDir(), t.TempDir()
+	f := NewFileHandler("/in/", dir, true, Options{UploadStore: store})
+	other := NewFileHandler("/out/", otherDir, true, Options{UploadStore: store})
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, newTusRequest(http.MethodPost, "/in/", "", map[string]string{
+		"Upload-Length":   "5",
+		"Upload-Metadata": tusMetadata("a.txt"),
+	}))
+	id := strings.TrimPrefix(w.Header().Get("Location"), "/in/?"+tusUploadKey+"=")
+	location := "/out/?" + tusUploadKey + "=" + id
+	for _, r := range []*http.Request{
+		newTusRequest(http.MethodHead, location, "", nil),
+		newTusRequest(http.MethodPatch, location, "alpha", map[string]string{
+			"Content-Type":  tusOffsetContentType,
+			"Upload-Offset": "0",
+		}),
+		newTusRequest(http.MethodDelete, location, "", nil),
+	} {
+		w := httptest.NewRecorder()
+		other.ServeHTTP(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s on another route = %d, want %d", r.Method, w.Code, http.StatusNotFound)
+		}
+	}
+	if got := readTree(otherDir); len(got) != 0 {
+		t.Errorf("stored files = %v", got)
+	}
+	if _, err := store.Get(id, f.tusOwner()); err != nil {
+		t.Errorf("upload after requests on another route: %v", err)
+	}
+}
+
+func TestFileHandler_serveTus_errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    Options
+		method     string
+		target     string
+		header     map[string]string
+		existing   map[string]string
+		wantStatus int
+	}{
+		{
+			name:       "version",
+			method:     http.MethodPost,
+			target:     "/",
+			header:     map[string]string{tusResumableHeader: "0.2.2", "Upload-Length": "1", "Upload-Metadata": tusMetadata("a.txt")},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "missing length",
+			method:     http.MethodPost,
+			target:     "/",
+			header:     map[string]string{"Upload-Metadata": tusMetadata("a.txt")},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "missing file name",
+			method:     http.MethodPost,
+			target:     "/",
+			header:     map[string]string{"Upload-Length": "1"},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "path in file name",
+			method:     http.MethodPost,
+			target:     "/",
+			header:     map[string]string{"Upload-Length": "1", "Upload-Metadata": tusMetadata("../a.txt")},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "too large",
+			options:    Options{UploadLimits: UploadLimits{FileSize: 3}},
+			method:     http.MethodPost,
+			target:     "/",
+			header:     map[string]string{"Upload-Length": "4", "Upload-Metadata": tusMetadata("a.txt")},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "denied extension",
+			options:    Options{UploadPolicy: UploadPolicy{DenyExtensions: List{".exe"}}},
+			method:     http.MethodPost,
+			target:     "/",
+			header:     map[string]string{"Upload-Length": "1", "Upload-Metadata": tusMetadata("a.exe")},
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "reject existing",
+			options:    Options{UploadConflict: ConflictReject},
+			method:     http.MethodPost,
+			target:     "/",
+			header:     map[string]string{"Upload-Length": "1", "Upload-Metadata": tusMetadata("a.txt")},
+			existing:   map[string]string{"a.txt": "old"},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "not a directory",
+			method:     http.MethodPost,
+			target:     "/a.txt",
+			header:     map[string]string{"Upload-Length": "1", "Upload-Metadata": tusMetadata("b.txt")},
+			existing:   map[string]string{"a.txt": "old"},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "unknown upload",
+			method:     http.MethodHead,
+			target:     "/?" + tusUploadKey + "=0123456789abcdef0123456789abcdef",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "content type",
+			method:     http.MethodPatch,
+			target:     "/?" + tusUploadKey + "=0123456789abcdef0123456789abcdef",
+			header:     map[string]string{"Upload-Offset": "0"},
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, body := range tt.existing {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f := newTusHandler(t, dir, tt.options)
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, newTusRequest(tt.method, tt.target, "", tt.header))
+			if w.Code != tt.wantStatus {
+				t.Errorf("fileHandler.ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := readTree(dir); len(got) != len(tt.existing) {
+				t.Errorf("stored files = %v, want %v", got, tt.existing)
+			}
+		})
+	}
+}
+
+func TestFileHandler_serveTus_disabled(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := tus.NewStore(t.TempDir(), time.Hour)
+	f := NewFileHandler("/", dir, false, Options{UploadStore: store})
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, newTusRequest(http.MethodPost, "/", "", map[string]string{
+		"Upload-Length":   "1",
+		"Upload-Metadata": tusMetadata("a.txt"),
+	}))
+	if w.Code == http.StatusCreated {
+		t.Errorf("fileHandler.ServeHTTP() status = %d on a route without uploads", w.Code)
+	}
+	if got := readTree(dir); len(got) != 0 {
+		t.Errorf("stored files = %v", got)
+	}
+}
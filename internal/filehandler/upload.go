@@ -0,0 +1,416 @@
+package filehandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const uploadSummaryTemplateText = `
+<html>
+<meta name="google" content="notranslate"/>
+{{ template "head" . }}
+<body>
+<h1>{{ .Title }}</h1>
+<table>
+	<thead>
+		<th class=text>Uploaded</th>
+		<th class=text>Stored as</th>
+		<th colspan=2 class=number>Size (bytes)</th>
+		<th class=text>SHA-256</th>
+	</thead>
+	<tbody>
+	{{- range .Files }}
+	<tr>
+		<td class=text>{{ .Name }}</td>
+		<td class=text><a href="{{ .URL.String }}">{{ .StoredAs }}</a></td>
+		<td class=number>{{ .Size.String }}</td>
+		<td class=number>({{ .Size | printf "%d" }})</td>
+		<td class=text><code>{{ .SHA256 }}</code></td>
+	</tr>
+	{{- end }}
+	</tbody>
+</table>
+<p><a href="{{ .Back.String }}">Back to {{ .Title }}</a></p>
+</body>
+</html>
+`
+
+var uploadSummaryTemplate = newPageTemplate(uploadSummaryTemplateText)
+
+type uploadSummaryData struct {
+	Title string
+	Files []uploadResult
+	Back  *url.URL
+}
+
+const (
+	uploadFileKey = "file"
+	// uploadTempPattern names the temporary files uploads are written to
+	// before being moved into place.
+	uploadTempPattern = ".upload-*.tmp"
+	// dirMode is the mode of directories created by uploads and the
+	// management API, before the umask.
+	dirMode = 0755
+)
+
+var (
+	errUploadTooLarge = errors.New("upload too large")
+	errInvalidUpload  = errors.New("invalid upload")
+	errUploadConflict = errors.New("upload conflict")
+)
+
+// UploadLimits bound the size of uploads. Zero values mean no limit.
+type UploadLimits struct {
+	// FileSize is the maximum size of a single uploaded file.
+	FileSize int64
+	// RequestSize is the maximum size of an upload request body.
+	RequestSize int64
+}
+
+// uploadResult reports where an uploaded file was stored. Names are
+// slash-separated paths relative to the upload directory.
+type uploadResult struct {
+	Name     string
+	StoredAs string
+	Size     fileSizeBytes
+	URL      *url.URL
+	// SHA256 is the hex digest of the stored file.
+	SHA256 string
+}
+
+// uploadResultJSON is an uploadResult in the JSON response to an upload.
+type uploadResultJSON struct {
+	Name     string `json:"name"`
+	StoredAs string `json:"storedAs"`
+	Size     int64  `json:"size"`
+	URL      string `json:"url"`
+	SHA256   string `json:"sha256,omitempty"`
+}
+
+func (u uploadResult) String() string {
+	s := fmt.Sprintf("stored %s", u.Name)
+	if u.StoredAs != u.Name {
+		s += fmt.Sprintf(" as %s", u.StoredAs)
+	}
+	if u.SHA256 != "" {
+		s += fmt.Sprintf(" (%s %s)", digestSHA256, u.SHA256)
+	}
+	return s
+}
+
+// isUploadTemp reports whether the file at osPath is the temporary file of
+// an upload in progress, which is hidden from clients.
+func isUploadTemp(osPath string) bool {
+	ok, _ := filepath.Match(uploadTempPattern, filepath.Base(osPath))
+	return ok
+}
+
+// limitedBody fails reads with errUploadTooLarge once more than n bytes
+// have been read.
+type limitedBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, fmt.Errorf("%w: request body exceeds limit", errUploadTooLarge)
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, fmt.Errorf("%w: request body exceeds limit", errUploadTooLarge)
+	}
+	return n, err
+}
+
+// serveUploadTo streams the files of a multipart upload into the
+// directory osPath, without buffering them in memory, and reports where
+// they were stored.
+func (f *FileHandler) serveUploadTo(w http.ResponseWriter, r *http.Request, osPath string) error {
+	limits := f.options.UploadLimits
+	if limits.RequestSize > 0 {
+		if r.ContentLength > limits.RequestSize {
+			return fmt.Errorf("%w: request of %d bytes exceeds limit", errUploadTooLarge, r.ContentLength)
+		}
+		r.Body = &limitedBody{ReadCloser: r.Body, n: limits.RequestSize}
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidUpload, err)
+	}
+	var results []uploadResult
+	// digests sent in a checksum field apply to the next file
+	var checksum []uploadDigest
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if errors.Is(err, errUploadTooLarge) {
+				return err
+			}
+			return fmt.Errorf("%w: %v", errInvalidUpload, err)
+		}
+		filename := partFileName(part)
+		if part.FormName() == uploadChecksumKey && filename == "" {
+			d, err := readChecksumField(part)
+			part.Close()
+			if err != nil {
+				return err
+			}
+			checksum = append(checksum, d)
+			continue
+		}
+		if part.FormName() != uploadFileKey || filename == "" {
+			part.Close()
+			continue
+		}
+		digests, err := parseDigestHeaders(http.Header(part.Header))
+		if err != nil {
+			part.Close()
+			return err
+		}
+		result, err := f.storeUploadPath(osPath, filename, part, append(checksum, digests...))
+		part.Close()
+		if err != nil {
+			return err
+		}
+		checksum = nil
+		results = append(results, result)
+	}
+	return f.serveUploadResults(w, r, osPath, results)
+}
+
+// partFileName returns the file name sent for part, including the relative
+// directories sent by browsers for folder uploads, which
+// multipart.Part.FileName strips.
+func partFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// uploadPath turns the file name of an upload into a slash-separated path
+// relative to the upload directory. Backslashes are taken to separate the
+// full client-side path sent by some old browsers, of which only the base
+// name is kept; names leaving the upload directory are refused.
+func uploadPath(filename string) (string, error) {
+	if i := strings.LastIndex(filename, `\`); i >= 0 {
+		filename = filename[i+1:]
+	}
+	var elems []string
+	for _, elem := range strings.Split(filename, "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			return "", fmt.Errorf("%w: file name %q", errInvalidUpload, filename)
+		}
+		elems = append(elems, elem)
+	}
+	if len(elems) == 0 {
+		return "", fmt.Errorf("%w: file name %q", errInvalidUpload, filename)
+	}
+	return path.Join(elems...), nil
+}
+
+// storeUploadPath stores an uploaded file at its relative path below dir,
+// creating the directories on the way, and verifies it against the
+// digests sent by the client.
+func (f *FileHandler) storeUploadPath(dir, filename string, in io.Reader, digests []uploadDigest) (uploadResult, error) {
+	rel, dir, err := f.uploadTarget(dir, filename)
+	if err != nil {
+		return uploadResult{}, err
+	}
+	sent, _ := uploadPath(filename)
+	parent, name := path.Split(rel)
+	v := newDigestVerifier(digests)
+	stored, size, err := f.storeUpload(dir, name, in, v, f.options.UploadConflict)
+	if err != nil {
+		return uploadResult{}, err
+	}
+	return uploadResult{Name: sent, StoredAs: parent + stored, Size: fileSizeBytes(size), SHA256: v.sha256()}, nil
+}
+
+// uploadTarget resolves the file name of an upload to its relative path,
+// as allowed by the upload policy, and the directory below dir it is to be
+// stored in, creating the directory if necessary.
+func (f *FileHandler) uploadTarget(dir, filename string) (rel, targetDir string, err error) {
+	rel, err = uploadPath(filename)
+	if err != nil {
+		return "", "", err
+	}
+	if rel, err = f.options.UploadPolicy.checkName(rel); err != nil {
+		return "", "", err
+	}
+	if parent := path.Dir(rel); parent != "." {
+		if dir, err = makeUploadDirs(dir, parent); err != nil {
+			return "", "", err
+		}
+	}
+	return rel, dir, nil
+}
+
+// makeUploadDirs creates the slash-separated directories rel below dir and
+// returns the innermost one. Existing directories are reused; symbolic
+// links and files in the way are refused.
+func makeUploadDirs(dir, rel string) (string, error) {
+	for _, elem := range strings.Split(strings.Trim(rel, "/"), "/") {
+		dir = filepath.Join(dir, elem)
+		err := os.Mkdir(dir, dirMode)
+		if err == nil {
+			continue
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return "", err
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("%w: %s is not a directory", errUploadConflict, rel)
+		}
+	}
+	return dir, nil
+}
+
+// storeUpload copies an uploaded file into a temporary file in dir and
+// moves it to name according to policy once complete, so that
+// readers never see partial uploads. The file is hashed by v while being
+// copied and dropped if it does not match the digests sent by the client.
+// It returns the name the file was stored under.
+func (f *FileHandler) storeUpload(dir, name string, in io.Reader, v *digestVerifier, policy ConflictPolicy) (string, int64, error) {
+	if policy == ConflictReject {
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			return "", 0, fmt.Errorf("%w: %s exists", errUploadConflict, name)
+		}
+	}
+	in, err := f.options.UploadPolicy.sniffUpload(name, in)
+	if err != nil {
+		return "", 0, err
+	}
+	tmp, err := ioutil.TempFile(dir, uploadTempPattern)
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	size, err := f.copyUpload(tmp, name, io.TeeReader(in, v))
+	if err != nil {
+		return "", 0, err
+	}
+	if err := v.verify(name); err != nil {
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+	stored, err := policy.commit(tmp.Name(), dir, name)
+	return stored, size, err
+}
+
+// copyUpload copies an uploaded file to out, enforcing the file size limit.
+func (f *FileHandler) copyUpload(out io.Writer, name string, in io.Reader) (int64, error) {
+	max := f.options.UploadLimits.FileSize
+	if max <= 0 {
+		return io.Copy(out, in)
+	}
+	n, err := io.Copy(out, io.LimitReader(in, max))
+	if err != nil {
+		return n, err
+	}
+	if extra, _ := io.ReadFull(in, make([]byte, 1)); extra > 0 {
+		return n, fmt.Errorf("%w: %s exceeds %d bytes", errUploadTooLarge, name, max)
+	}
+	return n, nil
+}
+
+// serveUploadResults reports the stored files: as JSON to clients asking
+// for it, as a summary page to browsers, and as a redirect to the directory
+// listing with a plain-text list of the files otherwise.
+func (f *FileHandler) serveUploadResults(w http.ResponseWriter, r *http.Request, osPath string, results []uploadResult) error {
+	back := *r.URL
+	back.RawQuery = ""
+	for i := range results {
+		u := back
+		u.Path = path.Join(u.Path, results[i].StoredAs)
+		results[i].URL = &u
+	}
+	if wantsJSON(r) {
+		out := make([]uploadResultJSON, len(results))
+		for i, result := range results {
+			out[i] = uploadResultJSON{
+				Name:     result.Name,
+				StoredAs: result.StoredAs,
+				Size:     int64(result.Size),
+				URL:      result.URL.String(),
+				SHA256:   result.SHA256,
+			}
+		}
+		w.Header().Set("Content-Type", jsonContentType)
+		w.WriteHeader(http.StatusCreated)
+		return json.NewEncoder(w).Encode(out)
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Location", r.URL.String())
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusSeeOther)
+		for _, result := range results {
+			fmt.Fprintln(w, result)
+		}
+		return nil
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return uploadSummaryTemplate.Execute(w, uploadSummaryData{
+		Title: func() string {
+			relPath, _ := filepath.Rel(f.path, osPath)
+			return filepath.Join(filepath.Base(f.path), relPath)
+		}(),
+		Files: results,
+		Back:  &back,
+	})
+}
+
+// serveUploadError responds to a failed upload with a status matching err.
+func (f *FileHandler) serveUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, errUploadTooLarge):
+		log.Printf("[%s] %s upload refused: %v", f.path, r.RemoteAddr, err)
+		_ = f.serveStatusError(w, r, http.StatusRequestEntityTooLarge, err)
+	case errors.Is(err, errUploadType):
+		log.Printf("[%s] %s upload refused: %v", f.path, r.RemoteAddr, err)
+		_ = f.serveStatusError(w, r, http.StatusUnsupportedMediaType, err)
+	case errors.Is(err, errUploadConflict):
+		log.Printf("[%s] %s upload refused: %v", f.path, r.RemoteAddr, err)
+		_ = f.serveStatusError(w, r, http.StatusConflict, err)
+	case errors.Is(err, errInvalidUpload):
+		log.Printf("[%s] %s %v", f.path, r.RemoteAddr, err)
+		_ = f.serveStatusError(w, r, http.StatusBadRequest, err)
+	default:
+		log.Printf("[%s] %s upload failed: %v", f.path, r.RemoteAddr, err)
+		_ = f.serveStatus(w, r, http.StatusInternalServerError)
+	}
+}
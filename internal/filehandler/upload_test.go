@@ -0,0 +1,579 @@
+package filehandler
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testUpload struct {
+	field, name, body string
+}
+
+// newUploadRequest returns a multipart POST request uploading files.
+func newUploadRequest(t *testing.T, target string, files ...testUpload) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, f := range files {
+		var w io.Writer
+		var err error
+		if f.name == "" {
+			w, err = mw.CreateFormField(f.field)
+		} else {
+			w, err = mw.CreateFormFile(f.field, f.name)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, f.body)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, target, &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+// readTree returns the contents of the regular files below dir by their
+// slash-separated relative paths.
+func readTree(dir string) map[string]string {
+	files := make(map[string]string)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			data, _ := os.ReadFile(path)
+			rel, _ := filepath.Rel(dir, path)
+			files[filepath.ToSlash(rel)] = string(data)
+		}
+		return nil
+	})
+	return files
+}
+
+func TestFileHandler_serveUploadTo(t *testing.T) {
+	tests := []struct {
+		name       string
+		limits     UploadLimits
+		conflict   ConflictPolicy
+		policy     UploadPolicy
+		request    func(t *testing.T) *http.Request
+		existing   map[string]string
+		wantStatus int
+		wantType   string
+		wantBody   string
+		wantFiles  map[string]string
+	}{
+		{
+			name: "single file",
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "a.txt", "alpha"})
+			},
+			wantStatus: http.StatusSeeOther,
+			wantFiles:  map[string]string{"a.txt": "alpha"},
+		},
+		{
+			name: "several files and other fields",
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/",
+					testUpload{"note", "", "ignored"},
+					testUpload{"file", "a.txt", "alpha"},
+					testUpload{"file", "b.txt", "bravo"},
+				)
+			},
+			wantStatus: http.StatusSeeOther,
+			wantFiles:  map[string]string{"a.txt": "alpha", "b.txt": "bravo"},
+		},
+		{
+			name: "parent directory in file name",
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "../../a.txt", "alpha"})
+			},
+			wantStatus: http.StatusBadRequest,
+			wantFiles:  map[string]string{},
+		},
+		{
+			name: "checksum field",
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/",
+					testUpload{"checksum", "", "sha256:8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8"},
+					testUpload{"file", "a.txt", "alpha"},
+					testUpload{"checksum", "", "fd9ab41e47a9ef4f6477a8a000bf404f  b.txt"},
+					testUpload{"file", "b.txt", "bravo"},
+				)
+			},
+			wantStatus: http.StatusSeeOther,
+			wantFiles:  map[string]string{"a.txt": "alpha", "b.txt": "bravo"},
+		},
+		{
+			name: "checksum mismatch",
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/",
+					testUpload{"checksum", "", "sha256:8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8"},
+					testUpload{"file", "a.txt", "alpha"},
+					testUpload{"checksum", "", "sha256:8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8"},
+					testUpload{"file", "b.txt", "bravo"},
+				)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "b.txt: sha-256 sent 8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8, computed f144a6907dc4284d1f9fe6a7d9b9ff53c02c1d07ba68f24d413d7ff7f757a782",
+			wantFiles:  map[string]string{"a.txt": "alpha"},
+		},
+		{
+			name: "invalid checksum",
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/",
+					testUpload{"checksum", "", "crc32:cafe"},
+					testUpload{"file", "a.txt", "alpha"},
+				)
+			},
+			wantStatus: http.StatusBadRequest,
+			wantFiles:  map[string]string{},
+		},
+		{
+			name: "part digest header mismatch",
+			request: func(t *testing.T) *http.Request {
+				var buf bytes.Buffer
+				mw := multipart.NewWriter(&buf)
+				w, _ := mw.CreatePart(map[string][]string{
+					"Content-Disposition": {`form-data; name="file"; filename="a.txt"`},
+					"Content-Md5":         {"/Zq0Hkep709kd6igAL9ATw=="},
+				})
+				io.WriteString(w, "alpha")
+				mw.Close()
+				r := httptest.NewRequest(http.MethodPost, "/", &buf)
+				r.Header.Set("Content-Type", mw.FormDataContentType())
+				return r
+			},
+			existing:   map[string]string{"a.txt": "old"},
+			wantStatus: http.StatusBadRequest,
+			wantFiles:  map[string]string{"a.txt": "old"},
+		},
+		{
+			name:   "denied extension",
+			policy: UploadPolicy{DenyExtensions: List{".exe"}},
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "setup.exe", "MZ"})
+			},
+			wantStatus: http.StatusUnsupportedMediaType,
+			wantBody:   `setup.exe: extension ".exe" is denied (upload-deny-ext)`,
+			wantFiles:  map[string]string{},
+		},
+		{
+			name:   "denied type",
+			policy: UploadPolicy{DenyTypes: List{"text/html"}},
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "a.txt", "<html><script></script></html>"})
+			},
+			wantStatus: http.StatusUnsupportedMediaType,
+			wantBody:   "a.txt: type text/html is denied (upload-deny-type)",
+			wantFiles:  map[string]string{},
+		},
+		{
+			name:   "allowed type",
+			policy: UploadPolicy{AllowTypes: List{"text/*"}},
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "a.txt", "alpha"})
+			},
+			wantStatus: http.StatusSeeOther,
+			wantFiles:  map[string]string{"a.txt": "alpha"},
+		},
+		{
+			name:   "sanitized names",
+			policy: UploadPolicy{Names: NamesSanitize},
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "aux/a?.txt", "alpha"})
+			},
+			wantStatus: http.StatusSeeOther,
+			wantBody:   "stored aux/a?.txt as _aux/a_.txt",
+			wantFiles:  map[string]string{"_aux/a_.txt": "alpha"},
+		},
+		{
+			name:   "rejected names",
+			policy: UploadPolicy{Names: NamesReject},
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "a?.txt", "alpha"})
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `"a?.txt" contains the character U+003F (upload-names)`,
+			wantFiles:  map[string]string{},
+		},
+		{
+			name: "folder",
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/",
+					testUpload{"file", "docs/a.txt", "alpha"},
+					testUpload{"file", "docs/sub/b.txt", "bravo"},
+				)
+			},
+			existing:   map[string]string{"docs/c.txt": "charlie"},
+			wantStatus: http.StatusSeeOther,
+			wantBody:   "stored docs/a.txt (sha-256 8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8)\nstored docs/sub/b.txt (sha-256 f144a6907dc4284d1f9fe6a7d9b9ff53c02c1d07ba68f24d413d7ff7f757a782)\n",
+			wantFiles:  map[string]string{"docs/a.txt": "alpha", "docs/sub/b.txt": "bravo", "docs/c.txt": "charlie"},
+		},
+		{
+			name: "absolute and client paths",
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/",
+					testUpload{"file", "/etc/a.txt", "alpha"},
+					testUpload{"file", `C:\Users\me\b.txt`, "bravo"},
+				)
+			},
+			wantStatus: http.StatusSeeOther,
+			wantFiles:  map[string]string{"etc/a.txt": "alpha", "b.txt": "bravo"},
+		},
+		{
+			name:     "folder with renamed file",
+			conflict: ConflictRename,
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "docs/a.txt", "new"})
+			},
+			existing:   map[string]string{"docs/a.txt": "old"},
+			wantStatus: http.StatusSeeOther,
+			wantBody:   "stored docs/a.txt as docs/a (1).txt (sha-256 11507a0e2f5e69d5dfa40a62a1bd7b6ee57e6bcd85c67c9b8431b36fff21c437)\n",
+			wantFiles:  map[string]string{"docs/a.txt": "old", "docs/a (1).txt": "new"},
+		},
+		{
+			name: "file in the way of a folder",
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "docs/a.txt", "alpha"})
+			},
+			existing:   map[string]string{"docs": "file"},
+			wantStatus: http.StatusConflict,
+			wantFiles:  map[string]string{"docs": "file"},
+		},
+		{
+			name: "summary page",
+			request: func(t *testing.T) *http.Request {
+				r := newUploadRequest(t, "/?x=1", testUpload{"file", "docs/a b.txt", "alpha"})
+				r.Header.Set("Accept", "text/html,application/xhtml+xml")
+				return r
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   `<a href="/docs/a%20b.txt">docs/a b.txt</a>`,
+			wantFiles:  map[string]string{"docs/a b.txt": "alpha"},
+		},
+		{
+			name:     "JSON results",
+			conflict: ConflictRename,
+			request: func(t *testing.T) *http.Request {
+				r := newUploadRequest(t, "/sub/", testUpload{"file", "a b.txt", "alpha"})
+				r.Header.Set("Accept", "application/json")
+				return r
+			},
+			existing:   map[string]string{"sub/a b.txt": "old"},
+			wantStatus: http.StatusCreated,
+			wantType:   jsonContentType,
+			wantBody:   `[{"name":"a b.txt","storedAs":"a b (1).txt","size":5,"url":"/sub/a%20b%20%281%29.txt","sha256":"8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8"}]` + "\n",
+			wantFiles:  map[string]string{"sub/a b.txt": "old", "sub/a b (1).txt": "alpha"},
+		},
+		{
+			name: "JSON results for several files",
+			request: func(t *testing.T) *http.Request {
+				r := newUploadRequest(t, "/",
+					testUpload{"file", "a.txt", "alpha"},
+					testUpload{"file", "docs/b.txt", "bravo"},
+				)
+				r.Header.Set("Accept", "application/json, text/plain")
+				return r
+			},
+			wantStatus: http.StatusCreated,
+			wantType:   jsonContentType,
+			wantBody: `[{"name":"a.txt","storedAs":"a.txt","size":5,"url":"/a.txt","sha256":"8ed3f6ad685b959ead7022518e1af76cd816f8e8ec7ccdda1ed4018e8f2223f8"},` +
+				`{"name":"docs/b.txt","storedAs":"docs/b.txt","size":5,"url":"/docs/b.txt","sha256":"f144a6907dc4284d1f9fe6a7d9b9ff53c02c1d07ba68f24d413d7ff7f757a782"}]` + "\n",
+			wantFiles: map[string]string{"a.txt": "alpha", "docs/b.txt": "bravo"},
+		},
+		{
+			name:   "file within limit",
+			limits: UploadLimits{FileSize: 5, RequestSize: 1 << 20},
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "a.txt", "alpha"})
+			},
+			wantStatus: http.StatusSeeOther,
+			wantFiles:  map[string]string{"a.txt": "alpha"},
+		},
+		{
+			name:   "file too large",
+			limits: UploadLimits{FileSize: 4},
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/",
+					testUpload{"file", "a.txt", "alpha"},
+					testUpload{"file", "b.txt", "bravo"},
+				)
+			},
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantFiles:  map[string]string{},
+		},
+		{
+			name:   "request too large",
+			limits: UploadLimits{RequestSize: 100},
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "a.txt", strings.Repeat("a", 200)})
+			},
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantFiles:  map[string]string{},
+		},
+		{
+			name:   "request too large without content length",
+			limits: UploadLimits{RequestSize: 1000},
+			request: func(t *testing.T) *http.Request {
+				r := newUploadRequest(t, "/", testUpload{"file", "a.txt", strings.Repeat("a", 2000)})
+				r.ContentLength = -1
+				return r
+			},
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantFiles:  map[string]string{},
+		},
+		{
+			name:   "existing file kept on failure",
+			limits: UploadLimits{FileSize: 4},
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "a.txt", "alpha"})
+			},
+			existing:   map[string]string{"a.txt": "old"},
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantFiles:  map[string]string{"a.txt": "old"},
+		},
+		{
+			name: "overwrite shorter file",
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "a.txt", "new"})
+			},
+			existing:   map[string]string{"a.txt": "older contents"},
+			wantStatus: http.StatusSeeOther,
+			wantBody:   "stored a.txt (sha-256 11507a0e2f5e69d5dfa40a62a1bd7b6ee57e6bcd85c67c9b8431b36fff21c437)\n",
+			wantFiles:  map[string]string{"a.txt": "new"},
+		},
+		{
+			name:     "reject",
+			conflict: ConflictReject,
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "a.txt", "new"})
+			},
+			existing:   map[string]string{"a.txt": "old"},
+			wantStatus: http.StatusConflict,
+			wantFiles:  map[string]string{"a.txt": "old"},
+		},
+		{
+			name:     "reject without conflict",
+			conflict: ConflictReject,
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "b.txt", "new"})
+			},
+			existing:   map[string]string{"a.txt": "old"},
+			wantStatus: http.StatusSeeOther,
+			wantFiles:  map[string]string{"a.txt": "old", "b.txt": "new"},
+		},
+		{
+			name:     "rename",
+			conflict: ConflictRename,
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/",
+					testUpload{"file", "a.txt", "new"},
+					testUpload{"file", "a.txt", "newer"},
+				)
+			},
+			existing:   map[string]string{"a.txt": "old", "a (1).txt": "old"},
+			wantStatus: http.StatusSeeOther,
+			wantBody:   "stored a.txt as a (2).txt (sha-256 11507a0e2f5e69d5dfa40a62a1bd7b6ee57e6bcd85c67c9b8431b36fff21c437)\nstored a.txt as a (3).txt (sha-256 804f51f71254c4081e37e7c887073560f4a6fa6cdad202e9ac67e032c43ed1e1)\n",
+			wantFiles:  map[string]string{"a.txt": "old", "a (1).txt": "old", "a (2).txt": "new", "a (3).txt": "newer"},
+		},
+		{
+			name: "overwrite directory",
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "sub", "new"})
+			},
+			existing:   map[string]string{"sub/a.txt": "old"},
+			wantStatus: http.StatusConflict,
+			wantFiles:  map[string]string{"sub/a.txt": "old"},
+		},
+		{
+			name: "not multipart",
+			request: func(t *testing.T) *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/", strings.NewReader("a=b"))
+			},
+			wantStatus: http.StatusBadRequest,
+			wantFiles:  map[string]string{},
+		},
+		{
+			name: "no file selected",
+			request: func(t *testing.T) *http.Request {
+				return newUploadRequest(t, "/", testUpload{"file", "", ""})
+			},
+			wantStatus: http.StatusSeeOther,
+			wantFiles:  map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, body := range tt.existing {
+				os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700)
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f := NewFileHandler("/", dir, true, Options{UploadLimits: tt.limits, UploadConflict: tt.conflict, UploadPolicy: tt.policy})
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, tt.request(t))
+			if w.Code != tt.wantStatus {
+				t.Errorf("fileHandler.ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Content-Type"); tt.wantType != "" && got != tt.wantType {
+				t.Errorf("fileHandler.ServeHTTP() Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("fileHandler.ServeHTTP() body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+			got := readTree(dir)
+			if len(got) != len(tt.wantFiles) {
+				t.Errorf("stored files = %v, want %v", got, tt.wantFiles)
+			}
+			for name, body := range tt.wantFiles {
+				if got[name] != body {
+					t.Errorf("%s = %q, want %q", name, got[name], body)
+				}
+			}
+		})
+	}
+}
+
+func Test_numberedName(t *testing.T) {
+	tests := []struct {
+		name string
+		i    int
+		want string
+	}{
+		{name: "a.txt", i: 0, want: "a.txt"},
+		{name: "a.txt", i: 1, want: "a (1).txt"},
+		{name: "README", i: 2, want: "README (2)"},
+		{name: "build.tar.gz", i: 1, want: "build (1).tar.gz"},
+		{name: ".bashrc", i: 1, want: ".bashrc (1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := numberedName(tt.name, tt.i); got != tt.want {
+				t.Errorf("numberedName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConflictPolicy_Set(t *testing.T) {
+	var p ConflictPolicy
+	if p.String() != "overwrite" {
+		t.Errorf("ConflictPolicy.String() = %q, want %q", p.String(), "overwrite")
+	}
+	for _, v := range []string{"overwrite", "reject", "rename"} {
+		if err := p.Set(v); err != nil || p.String() != v {
+			t.Errorf("ConflictPolicy.Set(%q) = %v, got %q", v, err, p)
+		}
+	}
+	if err := p.Set("ask"); err == nil {
+		t.Error("ConflictPolicy.Set(ask) error = nil, want error")
+	}
+}
+
+func TestFileHandler_ServeHTTP_uploadTempHidden(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "alpha", ".upload-123.tmp": "half"})
+	f := NewFileHandler("/", dir, true, Options{WebDAV: true})
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		header     map[string]string
+		wantStatus int
+	}{
+		{name: "listing", method: http.MethodGet, target: "/", wantStatus: http.StatusOK},
+		{name: "download", method: http.MethodGet, target: "/.upload-123.tmp", wantStatus: http.StatusNotFound},
+		{name: "replace", method: http.MethodPut, target: "/.upload-123.tmp", wantStatus: http.StatusNotFound},
+		{name: "archive", method: http.MethodGet, target: "/?tar.gz=true", wantStatus: http.StatusOK},
+		{name: "propfind", method: methodPropfind, target: "/", header: map[string]string{"Depth": "1"}, wantStatus: http.StatusMultiStatus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader("new"))
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("fileHandler.ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			body := w.Body.String()
+			if tt.name == "archive" {
+				body = readTarGz(t, w.Body.Bytes())
+			}
+			if strings.Contains(body, ".upload-") {
+				t.Errorf("fileHandler.ServeHTTP() shows the upload in progress:\n%s", body)
+			}
+		})
+	}
+	if got := readTree(dir)[".upload-123.tmp"]; got != "half" {
+		t.Errorf("upload in progress = %q, want %q", got, "half")
+	}
+}
+
+// readTarGz returns the names of the entries of a tar.gz archive.
+func readTarGz(t *testing.T, data []byte) string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	var names []string
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return strings.Join(names, "\n")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+	}
+}
+
+func Test_makeUploadDirs(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skip(err)
+	}
+	got, err := makeUploadDirs(dir, "a/b/")
+	if want := filepath.Join(dir, "a", "b"); err != nil || got != want {
+		t.Errorf("makeUploadDirs() = %q, %v, want %q", got, err, want)
+	}
+	// directories get the same mode as those made by the management API
+	if err := os.Mkdir(filepath.Join(outside, "want"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.Stat(filepath.Join(outside, "want"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != want.Mode() {
+		t.Errorf("makeUploadDirs() created mode %v, want %v", info.Mode(), want.Mode())
+	}
+	if _, err := makeUploadDirs(dir, "link/c/"); err == nil {
+		t.Error("makeUploadDirs() followed a symbolic link")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "c")); err == nil {
+		t.Error("makeUploadDirs() created a directory outside the upload directory")
+	}
+}
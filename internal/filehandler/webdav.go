@@ -0,0 +1,784 @@
+package filehandler
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sgreben/httpfileserver/internal/webdav"
+)
+
+const (
+	methodPropfind  = "PROPFIND"
+	methodProppatch = "PROPPATCH"
+	methodMkcol     = "MKCOL"
+	methodCopy      = "COPY"
+	methodMove      = "MOVE"
+	methodLock      = "LOCK"
+	methodUnlock    = "UNLOCK"
+
+	maxDAVRequestSize = 1 << 20
+)
+
+var (
+	errDAVForbidden       = errors.New("forbidden")
+	errDAVMethod          = errors.New("method not allowed")
+	errDAVBody            = errors.New("unexpected request body")
+	errDAVDestination     = errors.New("destination outside the route")
+	errPreconditionFailed = errors.New("precondition failed")
+)
+
+// isWebDAVRequest reports whether r is served by the WebDAV handler rather
+// than as a plain HTTP request.
+func isWebDAVRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodOptions, http.MethodPut, http.MethodDelete,
+		methodPropfind, methodProppatch, methodMkcol, methodCopy, methodMove, methodLock, methodUnlock:
+		return true
+	}
+	return false
+}
+
+// servesWebDAV reports whether r is served by the WebDAV handler of f.
+// DELETE requests of the management API, which ask for JSON or say whether
+// to delete recursively, are left to it, since WebDAV always deletes
+// collections with their members.
+func (f *FileHandler) servesWebDAV(r *http.Request) bool {
+	if !f.options.WebDAV || !isWebDAVRequest(r) {
+		return false
+	}
+	manageDelete := r.Method == http.MethodDelete && (wantsJSON(r) || r.URL.Query().Get("recursive") != "")
+	return !(f.options.Manage && manageDelete)
+}
+
+// serveWebDAV serves the methods of WebDAV (RFC 4918) class 1, and of
+// class 2 if the route has a lock manager. Reading is allowed to everyone.
+// Creating resources needs uploads to be allowed on the route, and
+// deleting, moving and replacing them the management credentials.
+func (f *FileHandler) serveWebDAV(w http.ResponseWriter, r *http.Request, osPath string) error {
+	if r.Method != http.MethodPut {
+		r.Body = http.MaxBytesReader(w, r.Body, maxDAVRequestSize)
+	}
+	switch r.Method {
+	case http.MethodOptions:
+		return f.serveDAVOptions(w)
+	case methodPropfind:
+		return f.servePropfind(w, r, osPath)
+	case methodProppatch:
+		return f.serveProppatch(w, r, osPath)
+	case methodMkcol:
+		return f.serveMkcol(w, r, osPath)
+	case http.MethodPut:
+		return f.serveDAVPut(w, r, osPath)
+	case http.MethodDelete:
+		return f.serveDAVDelete(w, r, osPath)
+	case methodCopy, methodMove:
+		return f.serveCopyMove(w, r, osPath)
+	case methodLock:
+		return f.serveLock(w, r, osPath)
+	case methodUnlock:
+		return f.serveUnlock(w, r)
+	}
+	return errDAVMethod
+}
+
+// serveWebDAVError responds to a failed WebDAV request with a status
+// matching err.
+func (f *FileHandler) serveWebDAVError(w http.ResponseWriter, r *http.Request, err error) {
+	var status int
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, webdav.ErrLocked):
+		status = http.StatusLocked
+	case errors.Is(err, webdav.ErrNoSuchLock):
+		status = http.StatusConflict
+	case errors.Is(err, webdav.ErrTooManyLocks):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, webdav.ErrInvalid):
+		status = http.StatusBadRequest
+	case errors.Is(err, errPreconditionFailed):
+		status = http.StatusPreconditionFailed
+	case errors.Is(err, errDAVForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, errDAVMethod):
+		status = http.StatusMethodNotAllowed
+	case errors.Is(err, errDAVBody), errors.Is(err, errUploadType):
+		status = http.StatusUnsupportedMediaType
+	case errors.Is(err, errDAVDestination):
+		status = http.StatusBadGateway
+	case errors.Is(err, errUploadTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	default:
+		f.serveManageError(w, r, err)
+		return
+	}
+	log.Printf("[%s] %s %s %s: %v", f.path, r.RemoteAddr, r.Method, r.URL.Path, err)
+	_ = f.serveStatusError(w, r, status, err)
+}
+
+func (f *FileHandler) serveDAVOptions(w http.ResponseWriter) error {
+	class, allow := "1", "OPTIONS, GET, HEAD, POST, PUT, DELETE, PROPFIND, PROPPATCH, MKCOL, COPY, MOVE"
+	if f.options.WebDAVLocks != nil {
+		class, allow = "1, 2", allow+", LOCK, UNLOCK"
+	}
+	w.Header().Set("DAV", class)
+	w.Header().Set("Allow", allow)
+	w.Header().Set("MS-Author-Via", "DAV")
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// authorizeDAV checks that r may create resources or, if replace is set,
+// delete, move or replace them.
+func (f *FileHandler) authorizeDAV(w http.ResponseWriter, r *http.Request, replace bool) error {
+	if f.options.Manage && f.options.ManageAuth.authorized(r) {
+		return nil
+	}
+	if !replace && f.allowUpload {
+		return nil
+	}
+	if f.options.Manage {
+		return f.authorizeManage(w, r)
+	}
+	return fmt.Errorf("%w: the route is read-only", errDAVForbidden)
+}
+
+// davConditions evaluates the If header of r, returning the lock tokens it
+// submits.
+func (f *FileHandler) davConditions(r *http.Request) ([]string, error) {
+	value := r.Header.Get("If")
+	if value == "" {
+		return nil, nil
+	}
+	h, err := webdav.ParseIf(value)
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range h {
+		urlPath := r.URL.Path
+		if list.Resource != "" {
+			u, err := url.Parse(list.Resource)
+			if err != nil {
+				continue
+			}
+			urlPath = u.Path
+		}
+		if f.davListHolds(list, urlPath) {
+			return h.Tokens(), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: If header", errPreconditionFailed)
+}
+
+// davListHolds reports whether all conditions of list hold for the
+// resource at urlPath.
+func (f *FileHandler) davListHolds(list webdav.List, urlPath string) bool {
+	etag := ""
+	if info, err := os.Stat(f.urlPathToOSPath(urlPath)); err == nil {
+		etag = davETag(info)
+	}
+	for _, c := range list.Conditions {
+		var holds bool
+		if c.Token != "" {
+			holds = f.options.WebDAVLocks != nil && f.options.WebDAVLocks.Valid(c.Token, urlPath)
+		} else {
+			holds = etag != "" && c.ETag == etag
+		}
+		if holds == c.Not {
+			return false
+		}
+	}
+	return true
+}
+
+// davConfirm checks that tokens include the tokens of the locks on the
+// resources at urlPaths, and on their members if members is set.
+func (f *FileHandler) davConfirm(tokens []string, members bool, urlPaths ...string) error {
+	if f.options.WebDAVLocks == nil {
+		return nil
+	}
+	for _, p := range urlPaths {
+		if err := f.options.WebDAVLocks.Confirm(p, members, tokens); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// davParent returns the URL path of the collection holding the resource at
+// urlPath.
+func davParent(urlPath string) string {
+	return path.Dir(webdav.Clean(urlPath))
+}
+
+// davName checks the name of a new resource against the upload policy.
+// Names the policy would change are refused, since WebDAV clients cannot
+// be told that a resource was stored under another name.
+func (f *FileHandler) davName(osPath string) error {
+	name := filepath.Base(osPath)
+	checked, err := f.manageName(name)
+	if err != nil {
+		return err
+	}
+	if checked != name {
+		return fmt.Errorf("%w: %q is not a safe name (upload-names)", errInvalidUpload, name)
+	}
+	return nil
+}
+
+// davETag returns the entity tag of a file, from its modification time and
+// size.
+func davETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// davHref returns the escaped URL of a resource, with a trailing slash for
+// collections.
+func davHref(urlPath string, isDir bool) string {
+	href := (&url.URL{Path: urlPath}).EscapedPath()
+	if isDir && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
+	return href
+}
+
+func davProp(local, value string) webdav.Property {
+	return webdav.Property{Name: xml.Name{Space: webdav.Namespace, Local: local}, InnerXML: value}
+}
+
+// davProps returns the live properties of the resource at urlPath.
+func (f *FileHandler) davProps(urlPath string, info os.FileInfo) []webdav.Property {
+	resourceType := ""
+	if info.IsDir() {
+		resourceType = "<D:collection/>"
+	}
+	props := []webdav.Property{
+		davProp("resourcetype", resourceType),
+		davProp("displayname", webdav.Text(path.Base(urlPath))),
+		davProp("getlastmodified", info.ModTime().UTC().Format(http.TimeFormat)),
+	}
+	if !info.IsDir() {
+		contentType := mime.TypeByExtension(path.Ext(urlPath))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		props = append(props,
+			davProp("getcontentlength", fmt.Sprint(info.Size())),
+			davProp("getcontenttype", webdav.Text(contentType)),
+			davProp("getetag", webdav.Text(davETag(info))),
+		)
+	}
+	if locks := f.options.WebDAVLocks; locks != nil {
+		var discovery strings.Builder
+		for _, l := range locks.Discover(urlPath) {
+			discovery.WriteString(webdav.ActiveLock(l, davHref(l.Root, false)))
+		}
+		props = append(props,
+			davProp("supportedlock", webdav.SupportedLock),
+			davProp("lockdiscovery", discovery.String()),
+		)
+	}
+	return props
+}
+
+// davResponse returns the properties asked for by pf of the resource at
+// urlPath.
+func (f *FileHandler) davResponse(urlPath string, info os.FileInfo, pf webdav.Propfind) webdav.Response {
+	props := f.davProps(urlPath, info)
+	response := webdav.Response{Href: davHref(urlPath, info.IsDir())}
+	switch {
+	case pf.PropName:
+		for i := range props {
+			props[i].InnerXML = ""
+		}
+		response.Propstats = []webdav.Propstat{{Props: props, Status: http.StatusOK}}
+	case pf.Names == nil:
+		response.Propstats = []webdav.Propstat{{Props: props, Status: http.StatusOK}}
+	default:
+		found := webdav.Propstat{Status: http.StatusOK}
+		missing := webdav.Propstat{Status: http.StatusNotFound}
+	names:
+		for _, name := range pf.Names {
+			for _, p := range props {
+				if p.Name == name {
+					found.Props = append(found.Props, p)
+					continue names
+				}
+			}
+			missing.Props = append(missing.Props, webdav.Property{Name: name})
+		}
+		for _, ps := range []webdav.Propstat{found, missing} {
+			if len(ps.Props) > 0 {
+				response.Propstats = append(response.Propstats, ps)
+			}
+		}
+	}
+	return response
+}
+
+// servePropfind lists the properties of a resource and, for Depth 1, of
+// the members of a collection. Infinite depth is refused.
+func (f *FileHandler) servePropfind(w http.ResponseWriter, r *http.Request, osPath string) error {
+	depth, err := webdav.ParseDepth(r.Header.Get("Depth"), webdav.Infinity)
+	if err != nil {
+		return err
+	}
+	if depth == webdav.Infinity {
+		return fmt.Errorf("%w: PROPFIND with infinite depth (propfind-finite-depth)", errDAVForbidden)
+	}
+	pf, err := webdav.ReadPropfind(r.Body)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(osPath)
+	if err != nil {
+		return err
+	}
+	responses := []webdav.Response{f.davResponse(r.URL.Path, info, pf)}
+	if depth == 1 && info.IsDir() {
+		d, err := os.Open(osPath)
+		if err != nil {
+			return err
+		}
+		names, err := d.Readdirnames(-1)
+		d.Close()
+		if err != nil {
+			return err
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if isUploadTemp(name) {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(osPath, name))
+			if err != nil {
+				continue
+			}
+			responses = append(responses, f.davResponse(path.Join(r.URL.Path, name), info, pf))
+		}
+	}
+	return webdav.WriteMultistatus(w, responses)
+}
+
+// serveProppatch refuses to change properties: the live properties are
+// computed from the file system, and no others are stored.
+func (f *FileHandler) serveProppatch(w http.ResponseWriter, r *http.Request, osPath string) error {
+	if err := f.authorizeDAV(w, r, false); err != nil {
+		return err
+	}
+	names, err := webdav.ReadProppatch(r.Body)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(osPath)
+	if err != nil {
+		return err
+	}
+	tokens, err := f.davConditions(r)
+	if err != nil {
+		return err
+	}
+	if err := f.davConfirm(tokens, false, r.URL.Path); err != nil {
+		return err
+	}
+	refused := webdav.Propstat{Status: http.StatusForbidden}
+	for _, name := range names {
+		refused.Props = append(refused.Props, webdav.Property{Name: name})
+	}
+	return webdav.WriteMultistatus(w, []webdav.Response{{
+		Href:      davHref(r.URL.Path, info.IsDir()),
+		Propstats: []webdav.Propstat{refused},
+	}})
+}
+
+func (f *FileHandler) serveMkcol(w http.ResponseWriter, r *http.Request, osPath string) error {
+	if err := f.authorizeDAV(w, r, false); err != nil {
+		return err
+	}
+	if n, _ := r.Body.Read(make([]byte, 1)); n > 0 {
+		return fmt.Errorf("%w: MKCOL with a body", errDAVBody)
+	}
+	tokens, err := f.davConditions(r)
+	if err != nil {
+		return err
+	}
+	if err := f.davConfirm(tokens, false, davParent(r.URL.Path)); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(osPath); err == nil {
+		return fmt.Errorf("%w: %s exists", errDAVMethod, filepath.Base(osPath))
+	}
+	if err := f.davName(osPath); err != nil {
+		return err
+	}
+	if err := f.confined(osPath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: parent collection does not exist", errManageConflict)
+		}
+		return err
+	}
+	target, err := f.manageMkdir(filepath.Dir(osPath), filepath.Base(osPath))
+	if err != nil {
+		return err
+	}
+	log.Printf("[%s] %s created %s", f.path, r.RemoteAddr, target)
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// serveDAVPut stores a file like other PUT uploads, once its locks are
+// confirmed. A file the client is authorized to replace is overwritten
+// whatever the route's conflict policy, as WebDAV clients expect.
+func (f *FileHandler) serveDAVPut(w http.ResponseWriter, r *http.Request, osPath string) error {
+	_, err := os.Lstat(osPath)
+	exists := err == nil
+	if err := f.authorizeDAV(w, r, exists); err != nil {
+		return err
+	}
+	tokens, err := f.davConditions(r)
+	if err != nil {
+		return err
+	}
+	urlPaths := []string{r.URL.Path}
+	if !exists {
+		urlPaths = append(urlPaths, davParent(r.URL.Path))
+	}
+	if err := f.davConfirm(tokens, false, urlPaths...); err != nil {
+		return err
+	}
+	if err := f.davName(osPath); err != nil {
+		return err
+	}
+	if err := f.confined(osPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	policy := f.options.UploadConflict
+	if exists {
+		policy = ConflictOverwrite
+	}
+	return f.servePut(w, r, osPath, policy)
+}
+
+func (f *FileHandler) serveDAVDelete(w http.ResponseWriter, r *http.Request, osPath string) error {
+	if err := f.authorizeDAV(w, r, true); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(osPath); err != nil {
+		return err
+	}
+	tokens, err := f.davConditions(r)
+	if err != nil {
+		return err
+	}
+	if err := f.davConfirm(tokens, true, r.URL.Path); err != nil {
+		return err
+	}
+	if err := f.davConfirm(tokens, false, davParent(r.URL.Path)); err != nil {
+		return err
+	}
+	if err := f.confined(osPath); err != nil {
+		return err
+	}
+	if f.isRoot(osPath) {
+		return fmt.Errorf("%w: the route's root cannot be deleted", errDAVForbidden)
+	}
+	if err := f.manageDelete(osPath, true); err != nil {
+		return err
+	}
+	if f.options.WebDAVLocks != nil {
+		f.options.WebDAVLocks.Remove(r.URL.Path)
+	}
+	log.Printf("[%s] %s deleted %s", f.path, r.RemoteAddr, osPath)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// davDestination returns the URL and file system paths of the Destination
+// of a COPY or MOVE request, which must lie within the route.
+func (f *FileHandler) davDestination(r *http.Request) (string, string, error) {
+	d := r.Header.Get("Destination")
+	u, err := url.Parse(d)
+	if d == "" || err != nil {
+		return "", "", fmt.Errorf("%w: Destination %q", webdav.ErrInvalid, d)
+	}
+	route := strings.TrimSuffix("/"+strings.Trim(f.route, "/"), "/")
+	if u.Host != "" && u.Host != r.Host || !strings.HasPrefix(u.Path, route+"/") {
+		return "", "", fmt.Errorf("%w: %s", errDAVDestination, d)
+	}
+	return u.Path, f.urlPathToOSPath(u.Path), nil
+}
+
+// serveCopyMove copies or moves a resource within the route. Existing
+// destinations are replaced unless the Overwrite header is F.
+func (f *FileHandler) serveCopyMove(w http.ResponseWriter, r *http.Request, osPath string) error {
+	move := r.Method == methodMove
+	depth, err := webdav.ParseDepth(r.Header.Get("Depth"), webdav.Infinity)
+	if err != nil {
+		return err
+	}
+	if depth == 1 || move && depth != webdav.Infinity {
+		return fmt.Errorf("%w: %s with Depth %s", webdav.ErrInvalid, r.Method, r.Header.Get("Depth"))
+	}
+	var overwrite bool
+	switch r.Header.Get("Overwrite") {
+	case "", "T":
+		overwrite = true
+	case "F":
+	default:
+		return fmt.Errorf("%w: Overwrite %q", webdav.ErrInvalid, r.Header.Get("Overwrite"))
+	}
+	dstURL, dst, err := f.davDestination(r)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(osPath)
+	if err != nil {
+		return err
+	}
+	_, err = os.Lstat(dst)
+	exists := err == nil
+	if err := f.authorizeDAV(w, r, move || exists && overwrite); err != nil {
+		return err
+	}
+	tokens, err := f.davConditions(r)
+	if err != nil {
+		return err
+	}
+	if move {
+		if err := f.davConfirm(tokens, true, r.URL.Path); err != nil {
+			return err
+		}
+		if err := f.davConfirm(tokens, false, davParent(r.URL.Path)); err != nil {
+			return err
+		}
+	}
+	if err := f.davConfirm(tokens, true, dstURL); err != nil {
+		return err
+	}
+	if err := f.davConfirm(tokens, false, davParent(dstURL)); err != nil {
+		return err
+	}
+	switch {
+	case dst == osPath || strings.HasPrefix(dst, osPath+osPathSeparator):
+		return fmt.Errorf("%w: destination within the source", errDAVForbidden)
+	case f.isRoot(osPath) && move, f.isRoot(dst):
+		return fmt.Errorf("%w: the route's root cannot be replaced or moved", errDAVForbidden)
+	}
+	if parent, err := os.Stat(filepath.Dir(dst)); err != nil || !parent.IsDir() {
+		return fmt.Errorf("%w: parent collection of the destination does not exist", errManageConflict)
+	}
+	if err := f.davName(dst); err != nil {
+		return err
+	}
+	for _, p := range []string{osPath, dst} {
+		if err := f.confined(p); err != nil {
+			return err
+		}
+	}
+	if exists {
+		if !overwrite {
+			return fmt.Errorf("%w: destination exists", errPreconditionFailed)
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		if f.options.WebDAVLocks != nil {
+			f.options.WebDAVLocks.Remove(dstURL)
+		}
+	}
+	if move {
+		if err := os.Rename(osPath, dst); err != nil {
+			return err
+		}
+		if f.options.WebDAVLocks != nil {
+			f.options.WebDAVLocks.Remove(r.URL.Path)
+		}
+		log.Printf("[%s] %s moved %s to %s", f.path, r.RemoteAddr, osPath, dst)
+	} else {
+		if err := copyTree(osPath, dst, info, depth == 0); err != nil {
+			return err
+		}
+		log.Printf("[%s] %s copied %s to %s", f.path, r.RemoteAddr, osPath, dst)
+	}
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.Header().Set("Location", davHref(dstURL, info.IsDir()))
+		w.WriteHeader(http.StatusCreated)
+	}
+	return nil
+}
+
+// copyTree copies the file or directory src, described by info, to dst,
+// leaving out the directory's members if shallow is set. Symbolic links
+// below src are copied as links.
+func copyTree(src, dst string, info os.FileInfo, shallow bool) error {
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	case !info.IsDir():
+		return copyFile(src, dst, info.Mode().Perm())
+	}
+	if err := os.Mkdir(dst, info.Mode().Perm()|0700); err != nil {
+		return err
+	}
+	if shallow {
+		return nil
+	}
+	members, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if isUploadTemp(member.Name()) {
+			continue
+		}
+		if err := copyTree(filepath.Join(src, member.Name()), filepath.Join(dst, member.Name()), member, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// davClient identifies the client of r by its address, without the port,
+// as locks are counted per client.
+func davClient(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// serveLock creates or refreshes a lock. Locking an unmapped URL creates
+// an empty file; locking an existing resource needs the rights to replace
+// it.
+func (f *FileHandler) serveLock(w http.ResponseWriter, r *http.Request, osPath string) error {
+	locks := f.options.WebDAVLocks
+	if locks == nil {
+		return errDAVMethod
+	}
+	if err := f.authorizeDAV(w, r, false); err != nil {
+		return err
+	}
+	info, refresh, err := webdav.ReadLockInfo(r.Body)
+	if err != nil {
+		return err
+	}
+	timeout := webdav.ParseTimeout(r.Header.Get("Timeout"))
+	if refresh {
+		h, err := webdav.ParseIf(r.Header.Get("If"))
+		if err != nil {
+			return err
+		}
+		for _, token := range h.Tokens() {
+			if l, err := locks.Refresh(token, r.URL.Path, timeout); err == nil {
+				return webdav.WriteLock(w, http.StatusOK, l, davHref(l.Root, false))
+			}
+		}
+		return fmt.Errorf("%w: no lock to refresh", errPreconditionFailed)
+	}
+	depth, err := webdav.ParseDepth(r.Header.Get("Depth"), webdav.Infinity)
+	if err != nil {
+		return err
+	}
+	if depth == 1 {
+		return fmt.Errorf("%w: LOCK with Depth 1", webdav.ErrInvalid)
+	}
+	tokens, err := f.davConditions(r)
+	if err != nil {
+		return err
+	}
+	status := http.StatusOK
+	if _, err := os.Lstat(osPath); os.IsNotExist(err) {
+		if err := f.davConfirm(tokens, false, davParent(r.URL.Path)); err != nil {
+			return err
+		}
+		if err := f.davName(osPath); err != nil {
+			return err
+		}
+		if parent, err := os.Stat(filepath.Dir(osPath)); err != nil || !parent.IsDir() {
+			return fmt.Errorf("%w: parent collection does not exist", errManageConflict)
+		}
+		if err := f.confined(osPath); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(osPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		file.Close()
+		status = http.StatusCreated
+	} else if err != nil {
+		return err
+	} else if err := f.authorizeDAV(w, r, true); err != nil {
+		// locking keeps others from changing the resource, which is
+		// up to those who may change it
+		return err
+	}
+	l, err := locks.Create(r.URL.Path, depth == 0, info.Shared, info.Owner, davClient(r), timeout)
+	if err != nil {
+		if status == http.StatusCreated {
+			os.Remove(osPath)
+		}
+		return err
+	}
+	w.Header().Set("Lock-Token", "<"+l.Token+">")
+	return webdav.WriteLock(w, status, l, davHref(l.Root, false))
+}
+
+func (f *FileHandler) serveUnlock(w http.ResponseWriter, r *http.Request) error {
+	locks := f.options.WebDAVLocks
+	if locks == nil {
+		return errDAVMethod
+	}
+	if err := f.authorizeDAV(w, r, false); err != nil {
+		return err
+	}
+	token := strings.TrimSpace(r.Header.Get("Lock-Token"))
+	if !strings.HasPrefix(token, "<") || !strings.HasSuffix(token, ">") {
+		return fmt.Errorf("%w: Lock-Token %q", webdav.ErrInvalid, token)
+	}
+	if err := locks.Unlock(token[1:len(token)-1], r.URL.Path); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
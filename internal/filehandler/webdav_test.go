@@ -0,0 +1,301 @@
+package filehandler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sgreben/httpfileserver/internal/webdav"
+)
+
+const (
+	davLockExclusive = `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner>litmus</D:owner></D:lockinfo>`
+	davLockShared    = `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	davPropfindProps = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:" xmlns:Z="http://example.com/"><D:prop><D:getcontentlength/><D:resourcetype/><Z:color/></D:prop></D:propfind>`
+	davProppatch     = `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:"><D:set><D:prop><D:getlastmodified>now</D:getlastmodified></D:prop></D:set></D:propertyupdate>`
+)
+
+// davStep is a request of a WebDAV test sequence. Header values and the
+// target may refer to the lock tokens returned by earlier steps as
+// {step name}.
+type davStep struct {
+	name       string
+	method     string
+	target     string
+	header     map[string]string
+	body       string
+	wantStatus int
+	wantBody   []string
+}
+
+// runDAVSteps runs steps in order against f, like the litmus WebDAV
+// server test suite.
+func runDAVSteps(t *testing.T, f *FileHandler, steps []davStep) {
+	t.Helper()
+	tokens := make(map[string]string)
+	expand := func(s string) string {
+		return regexp.MustCompile(`\{[^}]+\}`).ReplaceAllStringFunc(s, func(ref string) string {
+			return tokens[ref[1:len(ref)-1]]
+		})
+	}
+	for _, step := range steps {
+		r := httptest.NewRequest(step.method, expand(step.target), strings.NewReader(step.body))
+		for k, v := range step.header {
+			r.Header.Set(k, expand(v))
+		}
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		if w.Code != step.wantStatus {
+			t.Fatalf("%s: %s %s status = %d, want %d\n%s", step.name, step.method, step.target, w.Code, step.wantStatus, w.Body)
+		}
+		for _, want := range step.wantBody {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: body %s, want %s", step.name, w.Body, want)
+			}
+		}
+		if token := w.Header().Get("Lock-Token"); token != "" {
+			tokens[step.name] = strings.Trim(token, "<>")
+		}
+	}
+}
+
+func newDAVHandler(t *testing.T, dir string) *FileHandler {
+	t.Helper()
+	return NewFileHandler("/dav/", dir, true, Options{
+		WebDAV:      true,
+		WebDAVLocks: webdav.NewLocks(),
+		Manage:      true,
+		ManageAuth:  testManageAuth,
+	})
+}
+
+func TestFileHandler_serveWebDAV_basic(t *testing.T) {
+	dir := t.TempDir()
+	auth := map[string]string{"Authorization": "Basic YWRtaW46c2VjcmV0"}
+	runDAVSteps(t, newDAVHandler(t, dir), []davStep{
+		{name: "options", method: http.MethodOptions, target: "/dav/", wantStatus: http.StatusOK},
+		{name: "put_get", method: http.MethodPut, target: "/dav/res", body: "This is\na test file", wantStatus: http.StatusCreated},
+		{name: "get", method: http.MethodGet, target: "/dav/res", wantStatus: http.StatusOK, wantBody: []string{"This is\na test file"}},
+		{name: "put_replace_unauthenticated", method: http.MethodPut, target: "/dav/res", body: "replaced", wantStatus: http.StatusUnauthorized},
+		{name: "get_unchanged", method: http.MethodGet, target: "/dav/res", wantStatus: http.StatusOK, wantBody: []string{"This is\na test file"}},
+		{name: "put_replace", method: http.MethodPut, target: "/dav/res", body: "replaced", header: auth, wantStatus: http.StatusNoContent},
+		{name: "get_replaced", method: http.MethodGet, target: "/dav/res", wantStatus: http.StatusOK, wantBody: []string{"replaced"}},
+		{name: "put_get_utf8_segment", method: http.MethodPut, target: "/dav/res-%e2%82%ac", body: "euro", wantStatus: http.StatusCreated},
+		{name: "put_no_parent", method: http.MethodPut, target: "/dav/409me/noparent.txt", wantStatus: http.StatusConflict},
+		{name: "mkcol_over_plain", method: methodMkcol, target: "/dav/res", wantStatus: http.StatusMethodNotAllowed},
+		{name: "delete_unauthenticated", method: http.MethodDelete, target: "/dav/res", wantStatus: http.StatusUnauthorized},
+		{name: "delete", method: http.MethodDelete, target: "/dav/res", header: auth, wantStatus: http.StatusNoContent},
+		{name: "delete_null", method: http.MethodDelete, target: "/dav/404me", header: auth, wantStatus: http.StatusNotFound},
+		{name: "delete_utf8_segment", method: http.MethodDelete, target: "/dav/res-%e2%82%ac", header: auth, wantStatus: http.StatusNoContent},
+		{name: "mkcol", method: methodMkcol, target: "/dav/coll/", wantStatus: http.StatusCreated},
+		{name: "mkcol_again", method: methodMkcol, target: "/dav/coll/", wantStatus: http.StatusMethodNotAllowed},
+		{name: "put_in_coll", method: http.MethodPut, target: "/dav/coll/a.txt", body: "a", wantStatus: http.StatusCreated},
+		{name: "delete_coll", method: http.MethodDelete, target: "/dav/coll/", header: auth, wantStatus: http.StatusNoContent},
+		{name: "mkcol_no_parent", method: methodMkcol, target: "/dav/409me/noparent/", wantStatus: http.StatusConflict},
+		{name: "mkcol_with_body", method: methodMkcol, target: "/dav/withbody", body: "afafafaf", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "delete_root", method: http.MethodDelete, target: "/dav/", header: auth, wantStatus: http.StatusForbidden},
+	})
+	if got := readTree(dir); len(got) != 0 {
+		t.Errorf("files = %v", got)
+	}
+}
+
+func TestFileHandler_serveWebDAV_putConflict(t *testing.T) {
+	for _, policy := range []ConflictPolicy{ConflictOverwrite, ConflictRename, ConflictReject} {
+		t.Run(string(policy), func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, map[string]string{"a.txt": "alpha"})
+			f := newDAVHandler(t, dir)
+			f.options.UploadConflict = policy
+			runDAVSteps(t, f, []davStep{
+				{name: "put_unauthenticated", method: http.MethodPut, target: "/dav/a.txt", body: "replaced", wantStatus: http.StatusUnauthorized},
+				{name: "put_replace", method: http.MethodPut, target: "/dav/a.txt", body: "replaced", header: map[string]string{"Authorization": "Basic YWRtaW46c2VjcmV0"}, wantStatus: http.StatusNoContent},
+				{name: "put_new", method: http.MethodPut, target: "/dav/b.txt", body: "beta", wantStatus: http.StatusCreated},
+			})
+			want := map[string]string{"a.txt": "replaced", "b.txt": "beta"}
+			if got := readTree(dir); len(got) != len(want) || got["a.txt"] != want["a.txt"] || got["b.txt"] != want["b.txt"] {
+				t.Errorf("files = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFileHandler_serveWebDAV_manageDelete(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a/a.txt": "alpha", "b/b.txt": "beta", "c/c.txt": "charlie"})
+	auth := "Basic YWRtaW46c2VjcmV0"
+	runDAVSteps(t, newDAVHandler(t, dir), []davStep{
+		{name: "json", method: http.MethodDelete, target: "/dav/a/", header: map[string]string{"Authorization": auth, "Accept": jsonContentType}, wantStatus: http.StatusConflict, wantBody: []string{`"error"`}},
+		{name: "not_recursive", method: http.MethodDelete, target: "/dav/a/?recursive=false", header: map[string]string{"Authorization": auth}, wantStatus: http.StatusConflict},
+		{name: "recursive", method: http.MethodDelete, target: "/dav/a/?recursive=true", header: map[string]string{"Authorization": auth}, wantStatus: http.StatusNoContent},
+		{name: "json_recursive", method: http.MethodDelete, target: "/dav/b/", header: map[string]string{"Authorization": auth, "Content-Type": jsonContentType}, body: `{"recursive":true}`, wantStatus: http.StatusNoContent},
+		{name: "webdav", method: http.MethodDelete, target: "/dav/c/", header: map[string]string{"Authorization": auth}, wantStatus: http.StatusNoContent},
+	})
+	if got := readTree(dir); len(got) != 0 {
+		t.Errorf("files = %v", got)
+	}
+}
+
+func TestFileHandler_serveWebDAV_copymove(t *testing.T) {
+	dir := t.TempDir()
+	auth := "Basic YWRtaW46c2VjcmV0"
+	dest := func(p string, extra ...string) map[string]string {
+		h := map[string]string{"Destination": "http://example.com/dav/" + p}
+		for i := 0; i+1 < len(extra); i += 2 {
+			h[extra[i]] = extra[i+1]
+		}
+		return h
+	}
+	runDAVSteps(t, newDAVHandler(t, dir), []davStep{
+		{name: "copy_init", method: http.MethodPut, target: "/dav/copysrc", body: "source", wantStatus: http.StatusCreated},
+		{name: "copy_init_coll", method: methodMkcol, target: "/dav/copycoll/", wantStatus: http.StatusCreated},
+		{name: "copy_simple", method: methodCopy, target: "/dav/copysrc", header: dest("copydest"), wantStatus: http.StatusCreated},
+		{name: "copy_overwrite_false", method: methodCopy, target: "/dav/copysrc", header: dest("copydest", "Overwrite", "F"), wantStatus: http.StatusPreconditionFailed},
+		{name: "copy_overwrite_unauthenticated", method: methodCopy, target: "/dav/copysrc", header: dest("copydest"), wantStatus: http.StatusUnauthorized},
+		{name: "copy_overwrite", method: methodCopy, target: "/dav/copysrc", header: dest("copydest", "Authorization", auth), wantStatus: http.StatusNoContent},
+		{name: "copy_nodestcoll", method: methodCopy, target: "/dav/copysrc", header: dest("nonesuch/foo"), wantStatus: http.StatusConflict},
+		{name: "copy_into_coll", method: methodCopy, target: "/dav/copysrc", header: dest("copycoll/a"), wantStatus: http.StatusCreated},
+		{name: "copy_coll", method: methodCopy, target: "/dav/copycoll/", header: dest("copycoll2/"), wantStatus: http.StatusCreated},
+		{name: "copy_coll_shallow", method: methodCopy, target: "/dav/copycoll/", header: dest("copycoll3/", "Depth", "0"), wantStatus: http.StatusCreated},
+		{name: "copy_coll_into_itself", method: methodCopy, target: "/dav/copycoll/", header: dest("copycoll/sub/"), wantStatus: http.StatusForbidden},
+		{name: "copy_other_route", method: methodCopy, target: "/dav/copysrc", header: map[string]string{"Destination": "/other/copysrc"}, wantStatus: http.StatusBadGateway},
+		{name: "move_unauthenticated", method: methodMove, target: "/dav/copysrc", header: dest("movedest"), wantStatus: http.StatusUnauthorized},
+		{name: "move", method: methodMove, target: "/dav/copysrc", header: dest("movedest", "Authorization", auth), wantStatus: http.StatusCreated},
+		{name: "move_gone", method: http.MethodGet, target: "/dav/copysrc", wantStatus: http.StatusNotFound},
+		{name: "move_coll", method: methodMove, target: "/dav/copycoll2/", header: dest("movecoll/", "Authorization", auth), wantStatus: http.StatusCreated},
+		{name: "move_coll_depth", method: methodMove, target: "/dav/movecoll/", header: dest("movecoll2/", "Authorization", auth, "Depth", "0"), wantStatus: http.StatusBadRequest},
+	})
+	want := map[string]string{"copydest": "source", "movedest": "source", "copycoll/a": "source", "movecoll/a": "source"}
+	if got := readTree(dir); len(got) != len(want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "copycoll3")); err != nil || !info.IsDir() {
+		t.Errorf("shallow copy of a collection missing: %v", err)
+	}
+}
+
+func TestFileHandler_serveWebDAV_props(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "alpha", "sub/b.txt": "beta"})
+	runDAVSteps(t, newDAVHandler(t, dir), []davStep{
+		{name: "propfind_invalid", method: methodPropfind, target: "/dav/", header: map[string]string{"Depth": "1"}, body: "<foo>", wantStatus: http.StatusBadRequest},
+		{name: "propfind_infinity", method: methodPropfind, target: "/dav/", wantStatus: http.StatusForbidden},
+		{name: "propfind_d0", method: methodPropfind, target: "/dav/", header: map[string]string{"Depth": "0"}, wantStatus: http.StatusMultiStatus, wantBody: []string{
+			"<D:href>/dav/</D:href>", "<D:resourcetype><D:collection/></D:resourcetype>", "<D:supportedlock>",
+		}},
+		{name: "propfind_d1", method: methodPropfind, target: "/dav/", header: map[string]string{"Depth": "1"}, body: davPropfindProps, wantStatus: http.StatusMultiStatus, wantBody: []string{
+			"<D:href>/dav/a.txt</D:href><D:propstat><D:prop><D:getcontentlength>5</D:getcontentlength><D:resourcetype/></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>" +
+				`<D:propstat><D:prop><R:color xmlns:R="http://example.com/"/></D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat>`,
+			"<D:href>/dav/sub/</D:href>",
+		}},
+		{name: "propname", method: methodPropfind, target: "/dav/a.txt", header: map[string]string{"Depth": "0"}, body: `<propfind xmlns="DAV:"><propname/></propfind>`, wantStatus: http.StatusMultiStatus, wantBody: []string{
+			"<D:getetag/>",
+		}},
+		{name: "propfind_missing", method: methodPropfind, target: "/dav/none", header: map[string]string{"Depth": "0"}, wantStatus: http.StatusNotFound},
+		{name: "proppatch", method: methodProppatch, target: "/dav/a.txt", body: davProppatch, wantStatus: http.StatusMultiStatus, wantBody: []string{
+			"<D:getlastmodified/></D:prop><D:status>HTTP/1.1 403 Forbidden</D:status>",
+		}},
+		{name: "proppatch_invalid", method: methodProppatch, target: "/dav/a.txt", body: "<x/>", wantStatus: http.StatusBadRequest},
+	})
+}
+
+func TestFileHandler_serveWebDAV_locks(t *testing.T) {
+	dir := t.TempDir()
+	auth := "Basic YWRtaW46c2VjcmV0"
+	runDAVSteps(t, newDAVHandler(t, dir), []davStep{
+		{name: "put", method: http.MethodPut, target: "/dav/lockme", body: "data", wantStatus: http.StatusCreated},
+		{name: "lock_unauthenticated", method: methodLock, target: "/dav/lockme", body: davLockExclusive, wantStatus: http.StatusUnauthorized},
+		{name: "lock_excl", method: methodLock, target: "/dav/lockme", header: map[string]string{"Authorization": auth, "Timeout": "Second-3600"}, body: davLockExclusive, wantStatus: http.StatusOK, wantBody: []string{
+			"<D:exclusive/>", "<D:owner>litmus</D:owner>", "<D:timeout>Second-3600</D:timeout>", "<D:lockroot><D:href>/dav/lockme</D:href></D:lockroot>",
+		}},
+		{name: "discover", method: methodPropfind, target: "/dav/lockme", header: map[string]string{"Depth": "0"}, wantStatus: http.StatusMultiStatus, wantBody: []string{
+			"<D:lockdiscovery><D:activelock>",
+		}},
+		{name: "refresh", method: methodLock, target: "/dav/lockme", header: map[string]string{"If": "(<{lock_excl}>)", "Timeout": "Second-60"}, wantStatus: http.StatusOK, wantBody: []string{
+			"<D:timeout>Second-60</D:timeout>",
+		}},
+		{name: "notowner_modify", method: http.MethodPut, target: "/dav/lockme", header: map[string]string{"Authorization": auth}, body: "other", wantStatus: http.StatusLocked},
+		{name: "notowner_lock", method: methodLock, target: "/dav/lockme", header: map[string]string{"Authorization": auth}, body: davLockExclusive, wantStatus: http.StatusLocked},
+		{name: "notowner_delete", method: http.MethodDelete, target: "/dav/lockme", header: map[string]string{"Authorization": auth}, wantStatus: http.StatusLocked},
+		{name: "cond_put_corrupt_token", method: http.MethodPut, target: "/dav/lockme", header: map[string]string{"Authorization": auth, "If": "(<urn:uuid:00000000-0000-0000-0000-000000000000>)"}, body: "other", wantStatus: http.StatusPreconditionFailed},
+		{name: "owner_modify", method: http.MethodPut, target: "/dav/lockme", header: map[string]string{"Authorization": auth, "If": "(<{lock_excl}>)"}, body: "owner", wantStatus: http.StatusNoContent},
+		{name: "cond_put_with_not", method: http.MethodPut, target: "/dav/lockme", header: map[string]string{"Authorization": auth, "If": "(<{lock_excl}>) (Not <DAV:no-lock>)"}, body: "owner", wantStatus: http.StatusNoContent},
+		{name: "copy_onto_locked", method: methodCopy, target: "/dav/lockme", header: map[string]string{"Destination": "/dav/lockme", "Authorization": auth, "If": "(<{lock_excl}>)"}, wantStatus: http.StatusForbidden},
+		{name: "unlock_wrong_token", method: methodUnlock, target: "/dav/lockme", header: map[string]string{"Lock-Token": "<urn:uuid:x>"}, wantStatus: http.StatusConflict},
+		{name: "unlock", method: methodUnlock, target: "/dav/lockme", header: map[string]string{"Lock-Token": "<{lock_excl}>"}, wantStatus: http.StatusNoContent},
+		{name: "modify_unlocked", method: http.MethodPut, target: "/dav/lockme", header: map[string]string{"Authorization": auth}, body: "anyone", wantStatus: http.StatusNoContent},
+
+		{name: "mkcol", method: methodMkcol, target: "/dav/coll/", wantStatus: http.StatusCreated},
+		{name: "lock_coll", method: methodLock, target: "/dav/coll/", header: map[string]string{"Authorization": auth}, body: davLockExclusive, wantStatus: http.StatusOK},
+		{name: "lock_null", method: methodLock, target: "/dav/coll/new", header: map[string]string{"If": "(<{lock_coll}>)", "Depth": "0"}, body: davLockExclusive, wantStatus: http.StatusLocked},
+		{name: "put_in_locked_coll", method: http.MethodPut, target: "/dav/coll/new", header: map[string]string{"Authorization": auth}, body: "new", wantStatus: http.StatusLocked},
+		{name: "put_in_locked_coll_with_token", method: http.MethodPut, target: "/dav/coll/new", header: map[string]string{"Authorization": auth, "If": "(<{lock_coll}>)"}, body: "new", wantStatus: http.StatusCreated},
+		{name: "move_out_of_locked_coll", method: methodMove, target: "/dav/coll/new", header: map[string]string{"Destination": "/dav/new", "Authorization": auth}, wantStatus: http.StatusLocked},
+		{name: "unlock_coll", method: methodUnlock, target: "/dav/coll/new", header: map[string]string{"Lock-Token": "<{lock_coll}>"}, wantStatus: http.StatusNoContent},
+
+		{name: "lock_unmapped", method: methodLock, target: "/dav/coll/null", header: map[string]string{"Depth": "0"}, body: davLockExclusive, wantStatus: http.StatusCreated},
+		{name: "put_unmapped", method: http.MethodPut, target: "/dav/coll/null", header: map[string]string{"Authorization": auth, "If": "(<{lock_unmapped}>)"}, body: "null", wantStatus: http.StatusNoContent},
+		{name: "delete_locked_member", method: http.MethodDelete, target: "/dav/coll/", header: map[string]string{"Authorization": auth}, wantStatus: http.StatusLocked},
+		{name: "delete_with_token", method: http.MethodDelete, target: "/dav/coll/", header: map[string]string{"Authorization": auth, "If": "</dav/coll/null> (<{lock_unmapped}>)"}, wantStatus: http.StatusNoContent},
+		{name: "lock_after_delete", method: methodLock, target: "/dav/lockme", header: map[string]string{"Authorization": auth, "Depth": "0"}, body: davLockExclusive, wantStatus: http.StatusOK},
+
+		{name: "lock_shared", method: methodLock, target: "/dav/shared", body: davLockShared, wantStatus: http.StatusCreated},
+		{name: "lock_shared_again", method: methodLock, target: "/dav/shared", header: map[string]string{"Authorization": auth}, body: davLockShared, wantStatus: http.StatusOK},
+		{name: "lock_shared_excl", method: methodLock, target: "/dav/shared", header: map[string]string{"Authorization": auth}, body: davLockExclusive, wantStatus: http.StatusLocked},
+		{name: "put_one_shared_token", method: http.MethodPut, target: "/dav/shared", header: map[string]string{"Authorization": auth, "If": "(<{lock_shared}>)"}, body: "s", wantStatus: http.StatusLocked},
+		{name: "put_both_shared_tokens", method: http.MethodPut, target: "/dav/shared", header: map[string]string{"Authorization": auth, "If": "(<{lock_shared}>) (<{lock_shared_again}>)"}, body: "s", wantStatus: http.StatusNoContent},
+	})
+}
+
+func TestFileHandler_serveWebDAV_lockLimit(t *testing.T) {
+	dir := t.TempDir()
+	f := newDAVHandler(t, dir)
+	lock := func(name, remoteAddr string) int {
+		r := httptest.NewRequest(methodLock, "/dav/"+name, strings.NewReader(davLockExclusive))
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		f.ServeHTTP(w, r)
+		return w.Code
+	}
+	for n := 0; n < webdav.MaxLocksPerClient; n++ {
+		if got := lock(fmt.Sprintf("f%d", n), "192.0.2.1:1234"); got != http.StatusCreated {
+			t.Fatalf("LOCK %d status = %d, want %d", n, got, http.StatusCreated)
+		}
+	}
+	if got := lock("other", "192.0.2.1:5678"); got != http.StatusServiceUnavailable {
+		t.Errorf("LOCK beyond the client limit status = %d, want %d", got, http.StatusServiceUnavailable)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other")); !os.IsNotExist(err) {
+		t.Errorf("refused LOCK left a file: %v", err)
+	}
+	if got := lock("other", "192.0.2.2:1234"); got != http.StatusCreated {
+		t.Errorf("LOCK of another client status = %d, want %d", got, http.StatusCreated)
+	}
+}
+
+func TestFileHandler_serveWebDAV_permissions(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "alpha"})
+	f := NewFileHandler("/", dir, false, Options{WebDAV: true})
+	runDAVSteps(t, f, []davStep{
+		{name: "options", method: http.MethodOptions, target: "/", wantStatus: http.StatusOK},
+		{name: "propfind", method: methodPropfind, target: "/", header: map[string]string{"Depth": "1"}, wantStatus: http.StatusMultiStatus, wantBody: []string{"<D:href>/a.txt</D:href>"}},
+		{name: "put", method: http.MethodPut, target: "/b.txt", body: "beta", wantStatus: http.StatusForbidden},
+		{name: "mkcol", method: methodMkcol, target: "/coll", wantStatus: http.StatusForbidden},
+		{name: "delete", method: http.MethodDelete, target: "/a.txt", wantStatus: http.StatusForbidden},
+		{name: "lock", method: methodLock, target: "/a.txt", body: davLockExclusive, wantStatus: http.StatusMethodNotAllowed},
+	})
+	w := httptest.NewRecorder()
+	f.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/", nil))
+	if got := w.Header().Get("DAV"); got != "1" {
+		t.Errorf("DAV header without a lock manager = %q, want %q", got, "1")
+	}
+	if got := readTree(dir); len(got) != 1 {
+		t.Errorf("files = %v", got)
+	}
+}
@@ -0,0 +1,120 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBusy is returned by Acquire when neither a slot nor a place in the
+// wait queue becomes available.
+var ErrBusy = errors.New("too many concurrent requests")
+
+var metrics = expvar.NewMap("archives")
+
+// Limiter bounds the number of concurrently running jobs. Callers that find
+// all slots taken may wait in a short queue for one to become free.
+type Limiter struct {
+	name    string
+	slots   chan struct{}
+	queue   chan struct{}
+	timeout time.Duration
+
+	active   int64
+	queued   int64
+	rejected int64
+}
+
+// New returns a Limiter allowing concurrency simultaneous jobs and up to
+// queue waiting callers, each waiting at most timeout (0 waits until the
+// caller's context is done). A concurrency of 0 or less returns nil, which
+// is a valid Limiter that never blocks.
+//
+// The limiter's active, queued and rejected counts are published via
+// expvar in the "archives" map, prefixed by name.
+func New(name string, concurrency, queue int, timeout time.Duration) *Limiter {
+	if concurrency <= 0 {
+		return nil
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	l := &Limiter{
+		name:    name,
+		slots:   make(chan struct{}, concurrency),
+		queue:   make(chan struct{}, queue),
+		timeout: timeout,
+	}
+	l.publish()
+	return l
+}
+
+func (l *Limiter) publish() {
+	metrics.Set(l.name+".active", expvar.Func(func() interface{} { return atomic.LoadInt64(&l.active) }))
+	metrics.Set(l.name+".queued", expvar.Func(func() interface{} { return atomic.LoadInt64(&l.queued) }))
+	metrics.Set(l.name+".rejected", expvar.Func(func() interface{} { return atomic.LoadInt64(&l.rejected) }))
+}
+
+// Acquire takes a slot, waiting in the queue if necessary. It returns
+// ErrBusy if the queue is full or the wait times out, and the context's
+// error if it is done first. Every successful Acquire must be paired with a
+// call to Release.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		atomic.AddInt64(&l.active, 1)
+		return nil
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		atomic.AddInt64(&l.rejected, 1)
+		return ErrBusy
+	}
+	atomic.AddInt64(&l.queued, 1)
+	defer func() {
+		atomic.AddInt64(&l.queued, -1)
+		<-l.queue
+	}()
+
+	var timeout <-chan time.Time
+	if l.timeout > 0 {
+		timer := time.NewTimer(l.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		atomic.AddInt64(&l.active, 1)
+		return nil
+	case <-timeout:
+		atomic.AddInt64(&l.rejected, 1)
+		return ErrBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire.
+func (l *Limiter) Release() {
+	if l == nil {
+		return
+	}
+	atomic.AddInt64(&l.active, -1)
+	<-l.slots
+}
+
+// Queued returns the number of callers currently waiting for a slot.
+func (l *Limiter) Queued() int {
+	if l == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&l.queued))
+}
@@ -0,0 +1,116 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		wantNil     bool
+	}{
+		{
+			name:        "unlimited",
+			concurrency: 0,
+			wantNil:     true,
+		},
+		{
+			name:        "limited",
+			concurrency: 2,
+			wantNil:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := New("test-new-"+tt.name, tt.concurrency, 1, time.Second); (got == nil) != tt.wantNil {
+				t.Errorf("New() = %v, wantNil %v", got, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestLimiter_Acquire(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		queue   int
+		timeout time.Duration
+		ctx     context.Context
+		wantErr error
+	}{
+		{
+			name:    "no queue",
+			queue:   0,
+			timeout: time.Second,
+			ctx:     context.Background(),
+			wantErr: ErrBusy,
+		},
+		{
+			name:    "queue timeout",
+			queue:   1,
+			timeout: 10 * time.Millisecond,
+			ctx:     context.Background(),
+			wantErr: ErrBusy,
+		},
+		{
+			name:    "context canceled",
+			queue:   1,
+			timeout: 0,
+			ctx:     canceled,
+			wantErr: context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New("test-acquire-"+tt.name, 1, tt.queue, tt.timeout)
+			if err := l.Acquire(context.Background()); err != nil {
+				t.Fatalf("Limiter.Acquire() first slot error = %v", err)
+			}
+			defer l.Release()
+			if err := l.Acquire(tt.ctx); err != tt.wantErr {
+				t.Errorf("Limiter.Acquire() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := l.Queued(); got != 0 {
+				t.Errorf("Limiter.Queued() = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestLimiter_AcquireQueued(t *testing.T) {
+	l := New("test-acquire-queued", 1, 1, time.Second)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatalf("Limiter.Acquire() error = %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- l.Acquire(context.Background())
+	}()
+	for l.Queued() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := l.Acquire(context.Background()); err != ErrBusy {
+		t.Errorf("Limiter.Acquire() with full queue error = %v, want %v", err, ErrBusy)
+	}
+	l.Release()
+	if err := <-done; err != nil {
+		t.Errorf("Limiter.Acquire() queued error = %v", err)
+	}
+	l.Release()
+}
+
+func TestLimiter_nil(t *testing.T) {
+	var l *Limiter
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Errorf("Limiter.Acquire() error = %v", err)
+	}
+	l.Release()
+	if got := l.Queued(); got != 0 {
+		t.Errorf("Limiter.Queued() = %d, want 0", got)
+	}
+}
@@ -0,0 +1,226 @@
+// Package pgzip implements a gzip writer that compresses blocks of its input
+// in parallel.
+//
+// The output is a single standard gzip member, readable by compress/gzip and
+// gunzip: each block is deflated independently, primed with the last 32 KiB
+// of the preceding block as its dictionary, and ends on a byte boundary
+// with a sync flush, so that the compressed blocks can simply be
+// concatenated. The output depends on the level and block size but not on
+// the number of workers.
+package pgzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// DefaultBlockSize is the size of the blocks compressed in parallel.
+	DefaultBlockSize = 1 << 20
+	// dictSize is the window of deflate, the most a dictionary can be used.
+	dictSize = 32 << 10
+)
+
+var errClosed = errors.New("pgzip: write to closed writer")
+
+// block is a part of the input compressed by one worker.
+type block struct {
+	data []byte
+	dict []byte
+	out  bytes.Buffer
+	err  error
+	done chan struct{}
+}
+
+// Writer is an io.WriteCloser compressing to a gzip stream with up to
+// workers blocks compressed at once. Compressed blocks are written to the
+// underlying writer by Write and Close, in order, so an abandoned Writer
+// leaves no goroutines behind once its blocks are compressed.
+type Writer struct {
+	w         io.Writer
+	level     int
+	workers   int
+	blockSize int
+
+	buf     []byte
+	prev    []byte
+	pending []*block
+	crc     uint32
+	size    uint32
+	header  bool
+	closed  bool
+	err     error
+}
+
+// NewWriter returns a Writer compressing at level, a compress/flate level,
+// with the given number of workers.
+func NewWriter(w io.Writer, level, workers int) (*Writer, error) {
+	return NewWriterBlockSize(w, level, workers, DefaultBlockSize)
+}
+
+// NewWriterBlockSize is like NewWriter with blocks of blockSize bytes.
+func NewWriterBlockSize(w io.Writer, level, workers, blockSize int) (*Writer, error) {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return nil, errors.New("pgzip: invalid compression level")
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if blockSize < dictSize {
+		blockSize = dictSize
+	}
+	return &Writer{
+		w:         w,
+		level:     level,
+		workers:   workers,
+		blockSize: blockSize,
+	}, nil
+}
+
+// writeHeader writes a gzip header without name or timestamp, like
+// gzip.Writer with an empty Header and OS 255 (unknown).
+func (z *Writer) writeHeader() error {
+	header := [10]byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	switch z.level {
+	case flate.BestCompression:
+		header[8] = 2
+	case flate.BestSpeed:
+		header[8] = 4
+	}
+	_, err := z.w.Write(header[:])
+	return err
+}
+
+func (z *Writer) compress(b *block) {
+	defer close(b.done)
+	fw, err := flate.NewWriterDict(&b.out, z.level, b.dict)
+	if err != nil {
+		b.err = err
+		return
+	}
+	if _, err := fw.Write(b.data); err != nil {
+		b.err = err
+		return
+	}
+	b.err = fw.Flush()
+}
+
+// writeNext waits for the oldest pending block and writes it.
+func (z *Writer) writeNext() error {
+	b := z.pending[0]
+	z.pending = z.pending[1:]
+	<-b.done
+	if b.err != nil {
+		return b.err
+	}
+	_, err := z.w.Write(b.out.Bytes())
+	return err
+}
+
+// dispatch hands the buffered input to a worker, first writing completed
+// blocks until fewer than workers are pending.
+func (z *Writer) dispatch() error {
+	b := &block{data: z.buf, done: make(chan struct{})}
+	if n := len(z.prev); n > dictSize {
+		b.dict = z.prev[n-dictSize:]
+	} else {
+		b.dict = z.prev
+	}
+	z.prev = z.buf
+	z.buf = nil
+	for len(z.pending) >= z.workers {
+		if err := z.writeNext(); err != nil {
+			return err
+		}
+	}
+	go z.compress(b)
+	z.pending = append(z.pending, b)
+	return nil
+}
+
+// Write buffers p, handing each full block to a worker.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	if !z.header {
+		z.header = true
+		if z.err = z.writeHeader(); z.err != nil {
+			return 0, z.err
+		}
+	}
+	z.crc = crc32.Update(z.crc, crc32.IEEETable, p)
+	z.size += uint32(len(p))
+	n := len(p)
+	for len(p) > 0 {
+		if z.buf == nil {
+			z.buf = make([]byte, 0, z.blockSize)
+		}
+		m := z.blockSize - len(z.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		z.buf = append(z.buf, p[:m]...)
+		p = p[m:]
+		if len(z.buf) == z.blockSize {
+			if z.err = z.dispatch(); z.err != nil {
+				return n - len(p), z.err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close compresses the remaining input, writes all pending blocks, the
+// final deflate block and the gzip trailer. It does not close the
+// underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err != nil {
+		return z.err
+	}
+	z.err = z.finish()
+	return z.err
+}
+
+func (z *Writer) finish() error {
+	if !z.header {
+		z.header = true
+		if err := z.writeHeader(); err != nil {
+			return err
+		}
+	}
+	if len(z.buf) > 0 {
+		if err := z.dispatch(); err != nil {
+			return err
+		}
+	}
+	for len(z.pending) > 0 {
+		if err := z.writeNext(); err != nil {
+			return err
+		}
+	}
+	// An empty final block ends the deflate stream.
+	fw, err := flate.NewWriter(z.w, z.level)
+	if err != nil {
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:4], z.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], z.size)
+	_, err = z.w.Write(trailer[:])
+	return err
+}
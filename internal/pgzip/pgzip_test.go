@@ -0,0 +1,208 @@
+package pgzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math/rand"
+	"os/exec"
+	"testing"
+)
+
+// testData returns n bytes of compressible data.
+func testData(n int) []byte {
+	rnd := rand.New(rand.NewSource(1))
+	words := []string{"alpha ", "beta ", "gamma ", "delta ", "epsilon\n"}
+	var b bytes.Buffer
+	for b.Len() < n {
+		b.WriteString(words[rnd.Intn(len(words))])
+	}
+	return b.Bytes()[:n]
+}
+
+func compress(t testing.TB, data []byte, level, workers, blockSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	z, err := NewWriterBlockSize(&buf, level, workers, blockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Odd-sized writes exercise blocks filled across several calls.
+	for p := data; len(p) > 0; {
+		n := 12345
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := z.Write(p[:n]); err != nil {
+			t.Fatalf("Writer.Write() error = %v", err)
+		}
+		p = p[n:]
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("Writer.Close() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		level   int
+		workers int
+	}{
+		{name: "empty", size: 0, level: flate.DefaultCompression, workers: 4},
+		{name: "less than a block", size: 1000, level: flate.DefaultCompression, workers: 4},
+		{name: "exact blocks", size: 4 * 64 << 10, level: flate.DefaultCompression, workers: 4},
+		{name: "many blocks", size: 1<<20 + 17, level: flate.DefaultCompression, workers: 4},
+		{name: "single worker", size: 1<<20 + 17, level: flate.DefaultCompression, workers: 1},
+		{name: "best speed", size: 300 << 10, level: flate.BestSpeed, workers: 3},
+		{name: "best compression", size: 300 << 10, level: flate.BestCompression, workers: 3},
+		{name: "no compression", size: 300 << 10, level: flate.NoCompression, workers: 3},
+		{name: "huffman only", size: 300 << 10, level: flate.HuffmanOnly, workers: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testData(tt.size)
+			compressed := compress(t, data, tt.level, tt.workers, 64<<10)
+			r, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatalf("gzip.NewReader() error = %v", err)
+			}
+			r.Multistream(false)
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("reading gzip stream: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("decompressed %d bytes, want %d", len(got), len(data))
+			}
+			if rest, _ := io.ReadAll(r); len(rest) != 0 {
+				t.Errorf("trailing data after the gzip member")
+			}
+		})
+	}
+}
+
+func TestWriter_deterministic(t *testing.T) {
+	data := testData(1 << 20)
+	want := compress(t, data, flate.DefaultCompression, 1, 64<<10)
+	for _, workers := range []int{2, 8} {
+		if got := compress(t, data, flate.DefaultCompression, workers, 64<<10); !bytes.Equal(got, want) {
+			t.Errorf("output with %d workers differs from output with 1 worker", workers)
+		}
+	}
+}
+
+func TestWriter_dictionary(t *testing.T) {
+	// Random data repeating with a period shorter than the dictionary
+	// compresses as well across block boundaries as within blocks.
+	period := make([]byte, 8<<10)
+	rand.New(rand.NewSource(1)).Read(period)
+	data := bytes.Repeat(period, 64)
+	parallel := compress(t, data, flate.DefaultCompression, 4, 64<<10)
+	var serial bytes.Buffer
+	gz := gzip.NewWriter(&serial)
+	gz.Write(data)
+	gz.Close()
+	if len(parallel) > 2*serial.Len() {
+		t.Errorf("compressed to %d bytes, want about %d", len(parallel), serial.Len())
+	}
+}
+
+func TestWriter_gunzip(t *testing.T) {
+	gunzip, err := exec.LookPath("gunzip")
+	if err != nil {
+		t.Skip("gunzip not found")
+	}
+	data := testData(1<<20 + 17)
+	cmd := exec.Command(gunzip, "-c")
+	cmd.Stdin = bytes.NewReader(compress(t, data, flate.DefaultCompression, 4, 64<<10))
+	got, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("gunzip error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("gunzip output differs")
+	}
+}
+
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n < len(p) {
+		return 0, errors.New("test error")
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriter_error(t *testing.T) {
+	z, err := NewWriterBlockSize(&failingWriter{n: 100}, flate.DefaultCompression, 2, 64<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var werr error
+	for i := 0; i < 16 && werr == nil; i++ {
+		_, werr = z.Write(testData(64 << 10))
+	}
+	if werr == nil {
+		werr = z.Close()
+	}
+	if werr == nil {
+		t.Fatalf("Writer did not report the underlying writer's error")
+	}
+	if _, err := z.Write([]byte("x")); err == nil {
+		t.Errorf("Writer.Write() after error = nil, want error")
+	}
+}
+
+func TestNewWriter_level(t *testing.T) {
+	if _, err := NewWriter(io.Discard, 10, 1); err == nil {
+		t.Errorf("NewWriter() with level 10 error = nil, want error")
+	}
+}
+
+func benchmarkWriter(b *testing.B, newWriter func(io.Writer) io.WriteCloser) {
+	data := testData(16 << 20)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := newWriter(io.Discard)
+		if _, err := w.Write(data); err != nil {
+			b.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGzip(b *testing.B) {
+	benchmarkWriter(b, func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	})
+}
+
+func BenchmarkWriter_1(b *testing.B) {
+	benchmarkWriter(b, func(w io.Writer) io.WriteCloser {
+		z, _ := NewWriter(w, flate.DefaultCompression, 1)
+		return z
+	})
+}
+
+func BenchmarkWriter_4(b *testing.B) {
+	benchmarkWriter(b, func(w io.Writer) io.WriteCloser {
+		z, _ := NewWriter(w, flate.DefaultCompression, 4)
+		return z
+	})
+}
+
+func BenchmarkWriter_16(b *testing.B) {
+	benchmarkWriter(b, func(w io.Writer) io.WriteCloser {
+		z, _ := NewWriter(w, flate.DefaultCompression, 16)
+		return z
+	})
+}
@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Options holds settings that override a global flag for a single route,
+// given as ROUTE:KEY=VALUE. It implements flag.Value.
+type Options struct {
+	// Values maps routes to their settings by key.
+	Values map[string]map[string]string
+	Texts  []string
+}
+
+func (fv *Options) Help() string {
+	return "a per-route setting ROUTE:KEY=VALUE, overriding the flag -KEY for ROUTE (may be repeated)"
+}
+
+// Set is flag.Value.Set
+func (fv *Options) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i < 0 {
+		return fmt.Errorf("%q: want ROUTE:KEY=VALUE", v)
+	}
+	j := strings.LastIndex(v[:i], ":")
+	if j <= 0 || j == i-1 {
+		return fmt.Errorf("%q: want ROUTE:KEY=VALUE", v)
+	}
+	route, key, value := normalizeRoute(v[:j]), v[j+1:i], v[i+1:]
+	if fv.Values == nil {
+		fv.Values = make(map[string]map[string]string)
+	}
+	if fv.Values[route] == nil {
+		fv.Values[route] = make(map[string]string)
+	}
+	fv.Values[route][key] = value
+	fv.Texts = append(fv.Texts, v)
+	return nil
+}
+
+func (fv *Options) String() string {
+	return strings.Join(fv.Texts, ", ")
+}
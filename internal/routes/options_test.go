@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOptions_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    map[string]map[string]string
+		wantErr bool
+	}{
+		{
+			name: "single",
+			args: []string{"/srv/:archive-max-files=100"},
+			want: map[string]map[string]string{"/srv/": {"archive-max-files": "100"}},
+		},
+		{
+			name: "route without slashes",
+			args: []string{"srv:archive-max-size=1G"},
+			want: map[string]map[string]string{"/srv/": {"archive-max-size": "1G"}},
+		},
+		{
+			name: "later overrides earlier",
+			args: []string{"/srv/:archive-max-files=1", "/srv/:archive-max-files=2", "/tmp/:archive-max-depth=3"},
+			want: map[string]map[string]string{
+				"/srv/": {"archive-max-files": "2"},
+				"/tmp/": {"archive-max-depth": "3"},
+			},
+		},
+		{
+			name: "colon in route",
+			args: []string{"/a:b/:key=c:d"},
+			want: map[string]map[string]string{"/a:b/": {"key": "c:d"}},
+		},
+		{
+			name:    "no value",
+			args:    []string{"/srv/:archive-max-files"},
+			wantErr: true,
+		},
+		{
+			name:    "no route",
+			args:    []string{":archive-max-files=1"},
+			wantErr: true,
+		},
+		{
+			name:    "no key",
+			args:    []string{"/srv/:=1"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fv Options
+			var err error
+			for _, arg := range tt.args {
+				if err = fv.Set(arg); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Options.Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(fv.Values, tt.want) {
+				t.Errorf("Options.Set() = %v, want %v", fv.Values, tt.want)
+			}
+		})
+	}
+}
@@ -47,12 +47,7 @@ func (fv *Routes) Set(v string) error {
 		if err != nil {
 			return err
 		}
-		if !strings.HasPrefix(route, "/") {
-			route = "/" + route
-		}
-		if !strings.HasSuffix(route, "/") {
-			route = route + "/"
-		}
+		route = normalizeRoute(route)
 	}
 	fv.Texts = append(fv.Texts, v)
 	fv.Values = append(fv.Values, struct {
@@ -65,6 +60,18 @@ func (fv *Routes) Set(v string) error {
 	return nil
 }
 
+// normalizeRoute adds the leading and trailing slashes routes are
+// registered with.
+func normalizeRoute(route string) string {
+	if !strings.HasPrefix(route, "/") {
+		route = "/" + route
+	}
+	if !strings.HasSuffix(route, "/") {
+		route = route + "/"
+	}
+	return route
+}
+
 func (fv *Routes) String() string {
 	return strings.Join(fv.Texts, ", ")
 }
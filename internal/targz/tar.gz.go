@@ -3,54 +3,106 @@ package targz
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"io"
-	"log"
-	"os"
-	"path/filepath"
+	"time"
+
+	"github.com/sgreben/httpfileserver/internal/archive"
+	"github.com/sgreben/httpfileserver/internal/pgzip"
 )
 
-func TarGz(w io.Writer, path string) error {
-	basePath := path
-	addFile := func(w *tar.Writer, path string, stat os.FileInfo) error {
-		if stat.IsDir() {
-			return nil
-		}
-		file, err := os.Open(path)
+// Tar writes an uncompressed tar archive of the file tree rooted at path to
+// w. It stops walking as soon as ctx is done and returns the context's error.
+//
+// On error the archive is left unterminated so that it cannot be mistaken
+// for a complete one.
+func Tar(ctx context.Context, w io.Writer, path string, opts archive.Options) error {
+	sums := archive.NewChecksums(opts)
+	// The writer picks the PAX format for entries whose names, ids or
+	// sizes do not fit a USTAR header.
+	addFile := func(w *tar.Writer, entry archive.Entry) error {
+		header, err := tar.FileInfoHeader(entry.Info, entry.Link)
 		if err != nil {
 			return err
 		}
-		defer file.Close()
-		path, err = filepath.Rel(basePath, path)
-		if err != nil {
-			return err
+		header.Name = entry.Name
+		if entry.Info.IsDir() {
+			header.Name += "/"
 		}
-		if err := w.WriteHeader(&tar.Header{
-			Name:    path,
-			Size:    stat.Size(),
-			Mode:    int64(stat.Mode()),
-			ModTime: stat.ModTime(),
-		}); err != nil {
+		header.ModTime = opts.ModTime(header.ModTime)
+		if opts.Reproducible {
+			header.Uid, header.Gid = 0, 0
+			header.Uname, header.Gname = "", ""
+			header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+		}
+		if err := w.WriteHeader(header); err != nil {
 			return err
 		}
-		if _, err := io.Copy(w, file); err != nil {
+		if entry.File == nil {
+			return nil
+		}
+		if _, err := sums.Copy(ctx, w, entry); err != nil {
 			return err
 		}
 		return w.Flush()
 	}
-	wGzip := gzip.NewWriter(w)
-	wTar := tar.NewWriter(wGzip)
-	defer func() {
-		if err := wTar.Close(); err != nil {
-			log.Println(err)
+	addGenerated := func(w *tar.Writer, name string, data []byte) error {
+		if err := w.WriteHeader(&tar.Header{
+			Name:    name,
+			Size:    int64(len(data)),
+			Mode:    0644,
+			ModTime: opts.GeneratedTime(),
+		}); err != nil {
+			return err
 		}
-		if err := wGzip.Close(); err != nil {
-			log.Println(err)
+		_, err := w.Write(data)
+		return err
+	}
+	wTar := tar.NewWriter(w)
+	skipped, err := archive.Walk(ctx, path, opts, func(entry archive.Entry) error {
+		return addFile(wTar, entry)
+	})
+	if err != nil {
+		return err
+	}
+	if sums != nil {
+		if err := addGenerated(wTar, archive.ChecksumsFileName, sums.Bytes()); err != nil {
+			return err
 		}
-	}()
-	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
+	}
+	if len(skipped) > 0 {
+		if err := addGenerated(wTar, archive.ErrorsFileName, archive.ErrorsFile(skipped)); err != nil {
 			return err
 		}
-		return addFile(wTar, path, info)
-	})
+	}
+	return wTar.Close()
+}
+
+// TarGz writes a gzip-compressed tar archive of the file tree rooted at path
+// to w, compressed at the level selected by opts. With opts.GzipWorkers
+// greater than 1, blocks of the archive are compressed in parallel. See Tar.
+func TarGz(ctx context.Context, w io.Writer, path string, opts archive.Options) error {
+	wGzip, err := newGzipWriter(w, opts)
+	if err != nil {
+		return err
+	}
+	if err := Tar(ctx, wGzip, path, opts); err != nil {
+		return err
+	}
+	return wGzip.Close()
+}
+
+// newGzipWriter returns the gzip writer selected by opts. Neither writes a
+// name or timestamp into the gzip header, so it does not vary between
+// downloads.
+func newGzipWriter(w io.Writer, opts archive.Options) (io.WriteCloser, error) {
+	if opts.GzipWorkers > 1 {
+		return pgzip.NewWriter(w, opts.CompressionLevel(), opts.GzipWorkers)
+	}
+	wGzip, err := gzip.NewWriterLevel(w, opts.CompressionLevel())
+	if err != nil {
+		return nil, err
+	}
+	wGzip.Header = gzip.Header{OS: 255}
+	return wGzip, nil
 }
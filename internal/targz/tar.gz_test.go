@@ -0,0 +1,276 @@
+package targz
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgreben/httpfileserver/internal/archive"
+)
+
+func testTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	longName := strings.Repeat("d", 120)
+	for _, name := range []string{"empty", filepath.Join("sub", longName)} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "sub", longName, "file.txt")
+	if err := os.WriteFile(file, []byte("content"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, time.Unix(1500000000, 0), time.Unix(1500000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func readTarGz(t *testing.T, data []byte) map[string]*tar.Header {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := make(map[string]*tar.Header)
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return headers
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers[h.Name] = h
+	}
+}
+
+func TestTarGz(t *testing.T) {
+	dir := testTree(t)
+	longFile := "sub/" + strings.Repeat("d", 120) + "/file.txt"
+
+	tests := []struct {
+		name     string
+		opts     archive.Options
+		want     map[string]byte
+		wantMiss []string
+	}{
+		{
+			name: "follow symlinks",
+			opts: archive.Options{Symlinks: archive.SymlinksFollow},
+			want: map[string]byte{
+				"empty/": tar.TypeDir,
+				"sub/":   tar.TypeDir,
+				longFile: tar.TypeReg,
+				"link/":  tar.TypeDir,
+				strings.Replace(longFile, "sub/", "link/", 1): tar.TypeReg,
+			},
+		},
+		{
+			name: "store symlinks",
+			opts: archive.Options{Symlinks: archive.SymlinksStore},
+			want: map[string]byte{
+				"empty/": tar.TypeDir,
+				longFile: tar.TypeReg,
+				"link":   tar.TypeSymlink,
+			},
+			wantMiss: []string{"link/"},
+		},
+		{
+			name:     "skip symlinks",
+			opts:     archive.Options{Symlinks: archive.SymlinksSkip},
+			want:     map[string]byte{longFile: tar.TypeReg},
+			wantMiss: []string{"link", "link/"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := TarGz(context.Background(), &buf, dir, tt.opts); err != nil {
+				t.Fatalf("TarGz() error = %v", err)
+			}
+			headers := readTarGz(t, buf.Bytes())
+			for name, typ := range tt.want {
+				h, ok := headers[name]
+				if !ok {
+					t.Errorf("TarGz() missing entry %q", name)
+					continue
+				}
+				if h.Typeflag != typ {
+					t.Errorf("TarGz() entry %q type = %q, want %q", name, h.Typeflag, typ)
+				}
+			}
+			for _, name := range tt.wantMiss {
+				if _, ok := headers[name]; ok {
+					t.Errorf("TarGz() unexpected entry %q", name)
+				}
+			}
+			if h := headers[longFile]; h != nil {
+				if !h.ModTime.Equal(time.Unix(1500000000, 0)) {
+					t.Errorf("TarGz() mtime = %v", h.ModTime)
+				}
+				if h.Mode&0777 != 0640 {
+					t.Errorf("TarGz() mode = %o, want %o", h.Mode, 0640)
+				}
+				if h.Uid != os.Getuid() || h.Gid != os.Getgid() {
+					t.Errorf("TarGz() uid/gid = %d/%d, want %d/%d", h.Uid, h.Gid, os.Getuid(), os.Getgid())
+				}
+			}
+			if h := headers["link"]; h != nil && h.Linkname != "sub" {
+				t.Errorf("TarGz() link target = %q, want %q", h.Linkname, "sub")
+			}
+		})
+	}
+}
+
+func TestTar(t *testing.T) {
+	dir := testTree(t)
+	var buf bytes.Buffer
+	if err := Tar(context.Background(), &buf, dir, archive.Options{}); err != nil {
+		t.Fatalf("Tar() error = %v", err)
+	}
+	tr := tar.NewReader(&buf)
+	var names []string
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Tar() produced unreadable archive: %v", err)
+		}
+		names = append(names, h.Name)
+	}
+	if len(names) == 0 {
+		t.Errorf("Tar() produced no entries")
+	}
+}
+
+func TestTarGz_level(t *testing.T) {
+	dir := testTree(t)
+	for _, opts := range []archive.Options{{Level: 1}, {Level: 9}, {Store: true}} {
+		var buf bytes.Buffer
+		if err := TarGz(context.Background(), &buf, dir, opts); err != nil {
+			t.Fatalf("TarGz(%+v) error = %v", opts, err)
+		}
+		if headers := readTarGz(t, buf.Bytes()); len(headers) == 0 {
+			t.Errorf("TarGz(%+v) produced no entries", opts)
+		}
+	}
+}
+
+func TestTarGz_reproducible(t *testing.T) {
+	dir := testTree(t)
+	epoch := time.Unix(1400000000, 0)
+	opts := archive.Options{Reproducible: true, SourceDateEpoch: epoch, SkipErrors: true}
+
+	var first, second bytes.Buffer
+	if err := TarGz(context.Background(), &first, dir, opts); err != nil {
+		t.Fatalf("TarGz() error = %v", err)
+	}
+	if err := TarGz(context.Background(), &second, dir, opts); err != nil {
+		t.Fatalf("TarGz() error = %v", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("TarGz() archives differ between runs")
+	}
+	for name, h := range readTarGz(t, first.Bytes()) {
+		if h.ModTime.After(epoch) {
+			t.Errorf("%s: ModTime = %v, want at most %v", name, h.ModTime, epoch)
+		}
+		if h.Uid != 0 || h.Gid != 0 || h.Uname != "" || h.Gname != "" {
+			t.Errorf("%s: owner = %d:%d (%q:%q), want 0:0", name, h.Uid, h.Gid, h.Uname, h.Gname)
+		}
+	}
+}
+
+func TestTarGz_checksums(t *testing.T) {
+	dir := testTree(t)
+	var buf bytes.Buffer
+	if err := TarGz(context.Background(), &buf, dir, archive.Options{Checksums: true, Symlinks: archive.SymlinksSkip}); err != nil {
+		t.Fatalf("TarGz() error = %v", err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var last string
+	var content []byte
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = h.Name
+		if content, err = io.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if last != archive.ChecksumsFileName {
+		t.Fatalf("TarGz() last entry = %q, want %q", last, archive.ChecksumsFileName)
+	}
+	want := "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73  sub/" + strings.Repeat("d", 120) + "/file.txt\n"
+	if string(content) != want {
+		t.Errorf("TarGz() %s = %q, want %q", archive.ChecksumsFileName, content, want)
+	}
+}
+
+func TestTarGz_parallel(t *testing.T) {
+	dir := testTree(t)
+	if err := os.WriteFile(filepath.Join(dir, "big.txt"), bytes.Repeat([]byte("parallel gzip\n"), 300000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := TarGz(context.Background(), &buf, dir, archive.Options{GzipWorkers: 4}); err != nil {
+		t.Fatalf("TarGz() error = %v", err)
+	}
+	headers := readTarGz(t, buf.Bytes())
+	if h := headers["big.txt"]; h == nil || h.Size != 14*300000 {
+		t.Errorf("TarGz() big.txt header = %+v", h)
+	}
+}
+
+func benchmarkTarGz(b *testing.B, opts archive.Options) {
+	dir := b.TempDir()
+	data := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 1<<18)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(4 * int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := TarGz(context.Background(), io.Discard, dir, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTarGz(b *testing.B) {
+	benchmarkTarGz(b, archive.Options{})
+}
+
+func BenchmarkTarGz_parallel4(b *testing.B) {
+	benchmarkTarGz(b, archive.Options{GzipWorkers: 4})
+}
+
+func BenchmarkTarGz_parallel16(b *testing.B) {
+	benchmarkTarGz(b, archive.Options{GzipWorkers: 16})
+}
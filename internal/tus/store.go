@@ -0,0 +1,309 @@
+// Package tus stores partial uploads of the tus resumable upload protocol
+// (https://tus.io/protocols/resumable-upload.html).
+package tus
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	infoExtension = ".info"
+	dataExtension = ".bin"
+	idBytes       = 16
+)
+
+var (
+	// ErrNotFound is returned for unknown and expired uploads.
+	ErrNotFound = errors.New("upload not found")
+	// ErrOffsetMismatch is returned by Append if the offset sent by the
+	// client is not the offset of the upload.
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	// ErrLocked is returned by Append and Terminate while another request
+	// appends to the same upload.
+	ErrLocked = errors.New("upload is locked by another request")
+	// ErrTooLong is returned by Append if more data is sent than the
+	// length declared for the upload. The data of such a request is
+	// discarded.
+	ErrTooLong = errors.New("upload exceeds its length")
+)
+
+// Upload describes a partial upload.
+type Upload struct {
+	ID string `json:"id"`
+	// Length is the size of the complete upload.
+	Length int64 `json:"length"`
+	// Offset is the number of bytes received so far.
+	Offset int64 `json:"-"`
+	// Metadata holds the decoded Upload-Metadata pairs sent on creation.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Owner identifies the route the upload was created on. Uploads are
+	// not found by requests of other owners.
+	Owner string `json:"owner"`
+	// Dir is the directory the completed upload is to be moved to.
+	Dir     string    `json:"dir"`
+	Expires time.Time `json:"expires"`
+}
+
+// Complete reports whether all data of the upload has been received.
+func (u Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// Store keeps partial uploads as pairs of files in a staging directory: the
+// data received so far and a JSON description of the upload.
+type Store struct {
+	dir    string
+	expiry time.Duration
+
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+// NewStore returns a store keeping uploads in dir, creating dir if
+// necessary. Uploads not completed within expiry are removed.
+func NewStore(dir string, expiry time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, expiry: expiry, locked: make(map[string]bool)}, nil
+}
+
+func validID(id string) bool {
+	if len(id) != 2*idBytes {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil && strings.ToLower(id) == id
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+infoExtension)
+}
+
+// DataPath returns the path of the data file of upload id.
+func (s *Store) DataPath(id string) string {
+	return filepath.Join(s.dir, id+dataExtension)
+}
+
+// Create starts a new upload of length bytes for owner, to be moved to dir
+// when complete. It also removes expired uploads.
+func (s *Store) Create(length int64, metadata map[string]string, owner, dir string) (Upload, error) {
+	s.Purge()
+	b := make([]byte, idBytes)
+	if _, err := rand.Read(b); err != nil {
+		return Upload{}, err
+	}
+	u := Upload{
+		ID:       hex.EncodeToString(b),
+		Length:   length,
+		Metadata: metadata,
+		Owner:    owner,
+		Dir:      dir,
+		Expires:  time.Now().Add(s.expiry).UTC().Truncate(time.Second),
+	}
+	data, err := os.OpenFile(s.DataPath(u.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return Upload{}, err
+	}
+	data.Close()
+	if err := s.writeInfo(u); err != nil {
+		os.Remove(s.DataPath(u.ID))
+		return Upload{}, err
+	}
+	return u, nil
+}
+
+func (s *Store) writeInfo(u Upload) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(s.dir, u.ID+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.infoPath(u.ID))
+}
+
+// Get returns the upload id of owner, with its current offset.
+func (s *Store) Get(id, owner string) (Upload, error) {
+	u, err := s.get(id)
+	if err == nil && u.Owner != owner {
+		return Upload{}, ErrNotFound
+	}
+	return u, err
+}
+
+func (s *Store) get(id string) (Upload, error) {
+	if !validID(id) {
+		return Upload{}, ErrNotFound
+	}
+	b, err := ioutil.ReadFile(s.infoPath(id))
+	if os.IsNotExist(err) {
+		return Upload{}, ErrNotFound
+	}
+	if err != nil {
+		return Upload{}, err
+	}
+	var u Upload
+	if err := json.Unmarshal(b, &u); err != nil {
+		return Upload{}, fmt.Errorf("upload %s: %v", id, err)
+	}
+	if time.Now().After(u.Expires) {
+		s.Remove(id)
+		return Upload{}, ErrNotFound
+	}
+	info, err := os.Stat(s.DataPath(id))
+	if os.IsNotExist(err) {
+		return Upload{}, ErrNotFound
+	}
+	if err != nil {
+		return Upload{}, err
+	}
+	u.Offset = info.Size()
+	return u, nil
+}
+
+func (s *Store) lock(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked[id] {
+		return false
+	}
+	s.locked[id] = true
+	return true
+}
+
+func (s *Store) unlock(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.locked, id)
+}
+
+// Append adds the data read from r to upload id of owner, which must be at
+// offset. Data received before a read error is kept, so that the client
+// can resume from the new offset. If verify is not nil, it is called once
+// all data of r is read, and the data is discarded if verify fails or
+// not all of it was read. It returns the upload with its new offset and
+// expiry.
+func (s *Store) Append(id, owner string, offset int64, r io.Reader, verify func() error) (Upload, error) {
+	if !s.lock(id) {
+		return Upload{}, ErrLocked
+	}
+	defer s.unlock(id)
+	u, err := s.Get(id, owner)
+	if err != nil {
+		return u, err
+	}
+	if u.Complete() {
+		// being moved into place by the request that completed it
+		return u, ErrNotFound
+	}
+	if offset != u.Offset {
+		return u, fmt.Errorf("%w: %d, want %d", ErrOffsetMismatch, offset, u.Offset)
+	}
+	data, err := os.OpenFile(s.DataPath(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return u, err
+	}
+	n, copyErr := io.Copy(data, io.LimitReader(r, u.Length-u.Offset))
+	closeErr := data.Close()
+	u.Offset += n
+	discard := func(err error) (Upload, error) {
+		if truncErr := os.Truncate(s.DataPath(id), offset); truncErr != nil {
+			return u, truncErr
+		}
+		u.Offset = offset
+		return u, err
+	}
+	if copyErr == nil && u.Complete() {
+		if extra, _ := io.ReadFull(r, make([]byte, 1)); extra > 0 {
+			// rather than completing the upload with the beginning of a
+			// request that does not fit
+			return discard(ErrTooLong)
+		}
+	}
+	if verify != nil {
+		if copyErr != nil {
+			return discard(copyErr)
+		}
+		if err := verify(); err != nil {
+			return discard(err)
+		}
+	}
+	if !u.Complete() {
+		u.Expires = time.Now().Add(s.expiry).UTC().Truncate(time.Second)
+		if err := s.writeInfo(u); err != nil && copyErr == nil {
+			copyErr = err
+		}
+	}
+	if copyErr != nil {
+		return u, copyErr
+	}
+	return u, closeErr
+}
+
+// Terminate deletes the upload id of owner. Complete uploads are left to
+// the request that completed them, which moves them into place.
+func (s *Store) Terminate(id, owner string) error {
+	if !s.lock(id) {
+		return ErrLocked
+	}
+	defer s.unlock(id)
+	u, err := s.Get(id, owner)
+	if err != nil {
+		return err
+	}
+	if u.Complete() {
+		return ErrNotFound
+	}
+	return s.Remove(id)
+}
+
+// Remove deletes the upload id.
+func (s *Store) Remove(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	err := os.Remove(s.infoPath(id))
+	if dataErr := os.Remove(s.DataPath(id)); err == nil || os.IsNotExist(err) {
+		err = dataErr
+	}
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Purge removes expired uploads.
+func (s *Store) Purge() {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		if id := strings.TrimSuffix(info.Name(), infoExtension); id != info.Name() {
+			// get removes expired uploads
+			_, _ = s.get(id)
+		}
+	}
+}
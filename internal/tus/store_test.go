@@ -0,0 +1,137 @@
+package tus
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	s, err := NewStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.Create(10, map[string]string{"filename": "a.txt"}, "/=/srv", "/srv")
+	if err != nil {
+		t.Fatalf("Store.Create() error = %v", err)
+	}
+	if got, err := s.Get(u.ID, "/=/srv"); err != nil || got.Offset != 0 || got.Length != 10 || got.Metadata["filename"] != "a.txt" || got.Dir != "/srv" {
+		t.Fatalf("Store.Get() = %+v, %v", got, err)
+	}
+	if _, err := s.Get(u.ID, "/other=/srv"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Store.Get() of another owner error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := s.Append(u.ID, "/other=/srv", 0, strings.NewReader("0123"), nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Store.Append() of another owner error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Terminate(u.ID, "/other=/srv"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Store.Terminate() of another owner error = %v, want %v", err, ErrNotFound)
+	}
+
+	// an interrupted request keeps the data received so far
+	broken := io.MultiReader(strings.NewReader("0123"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if got, err := s.Append(u.ID, "/=/srv", 0, broken, nil); !errors.Is(err, io.ErrUnexpectedEOF) || got.Offset != 4 {
+		t.Fatalf("Store.Append() = %+v, %v, want offset 4 and the read error", got, err)
+	}
+	if _, err := s.Append(u.ID, "/=/srv", 0, strings.NewReader("0123"), nil); !errors.Is(err, ErrOffsetMismatch) {
+		t.Errorf("Store.Append() at a stale offset error = %v, want %v", err, ErrOffsetMismatch)
+	}
+	if got, err := s.Append(u.ID, "/=/srv", 4, strings.NewReader("456789x"), nil); !errors.Is(err, ErrTooLong) || got.Offset != 4 {
+		t.Errorf("Store.Append() beyond the length = %+v, %v, want offset 4 and %v", got, err, ErrTooLong)
+	}
+	if got, err := s.Get(u.ID, "/=/srv"); err != nil || got.Offset != 4 {
+		t.Fatalf("Store.Get() after a request beyond the length = %+v, %v, want offset 4", got, err)
+	}
+	failed := errors.New("checksum mismatch")
+	if got, err := s.Append(u.ID, "/=/srv", 4, strings.NewReader("4567"), func() error { return failed }); err != failed || got.Offset != 4 {
+		t.Errorf("Store.Append() failing verification = %+v, %v, want offset 4 and %v", got, err, failed)
+	}
+	broken = io.MultiReader(strings.NewReader("45"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if got, err := s.Append(u.ID, "/=/srv", 4, broken, func() error { return nil }); !errors.Is(err, io.ErrUnexpectedEOF) || got.Offset != 4 {
+		t.Errorf("Store.Append() of an interrupted verified request = %+v, %v, want offset 4 and the read error", got, err)
+	}
+	if _, err := s.Append(u.ID, "/=/srv", 4, strings.NewReader("456789"), func() error { return nil }); err != nil {
+		t.Errorf("Store.Append() error = %v", err)
+	}
+	got, err := s.Get(u.ID, "/=/srv")
+	if err != nil || !got.Complete() {
+		t.Fatalf("Store.Get() = %+v, %v, want a complete upload", got, err)
+	}
+	if data, _ := ioutil.ReadFile(s.DataPath(u.ID)); string(data) != "0123456789" {
+		t.Errorf("data = %q, want %q", data, "0123456789")
+	}
+	if _, err := s.Append(u.ID, "/=/srv", 10, strings.NewReader(""), nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Store.Append() to a complete upload error = %v, want %v", err, ErrNotFound)
+	}
+	if err := s.Terminate(u.ID, "/=/srv"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Store.Terminate() of a complete upload error = %v, want %v", err, ErrNotFound)
+	}
+	if data, _ := ioutil.ReadFile(s.DataPath(u.ID)); string(data) != "0123456789" {
+		t.Errorf("data after Terminate = %q, want %q", data, "0123456789")
+	}
+
+	if err := s.Remove(u.ID); err != nil {
+		t.Errorf("Store.Remove() error = %v", err)
+	}
+	if _, err := s.Get(u.ID, "/=/srv"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Store.Get() after Remove error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestStore_locked(t *testing.T) {
+	s, _ := NewStore(t.TempDir(), time.Hour)
+	u, _ := s.Create(10, nil, "/=/srv", "/srv")
+	s.lock(u.ID)
+	if _, err := s.Append(u.ID, "/=/srv", 0, strings.NewReader("0123"), nil); !errors.Is(err, ErrLocked) {
+		t.Errorf("Store.Append() error = %v, want %v", err, ErrLocked)
+	}
+	if err := s.Terminate(u.ID, "/=/srv"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Store.Terminate() error = %v, want %v", err, ErrLocked)
+	}
+	s.unlock(u.ID)
+	if _, err := s.Append(u.ID, "/=/srv", 0, strings.NewReader("0123"), nil); err != nil {
+		t.Errorf("Store.Append() error = %v", err)
+	}
+	if err := s.Terminate(u.ID, "/=/srv"); err != nil {
+		t.Errorf("Store.Terminate() error = %v", err)
+	}
+}
+
+func TestStore_Purge(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewStore(dir, -time.Second)
+	expired, _ := s.Create(10, nil, "/=/srv", "/srv")
+	s.expiry = time.Hour
+	if _, err := s.Get(expired.ID, "/=/srv"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Store.Get() of an expired upload error = %v, want %v", err, ErrNotFound)
+	}
+	expired, _ = func() (Upload, error) {
+		s.expiry = -time.Second
+		defer func() { s.expiry = time.Hour }()
+		return s.Create(10, nil, "/=/srv", "/srv")
+	}()
+	if _, err := s.Create(10, nil, "/=/srv", "/srv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.DataPath(expired.ID)); !os.IsNotExist(err) {
+		t.Errorf("expired upload not purged by Create: %v", err)
+	}
+	infos, _ := ioutil.ReadDir(dir)
+	if len(infos) != 2 {
+		t.Errorf("staging directory holds %d files, want 2", len(infos))
+	}
+}
+
+func TestStore_invalidID(t *testing.T) {
+	s, _ := NewStore(t.TempDir(), time.Hour)
+	for _, id := range []string{"", "../../etc/passwd", strings.Repeat("A", 32), strings.Repeat("g", 32)} {
+		if _, err := s.Get(id, ""); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Store.Get(%q) error = %v, want %v", id, err, ErrNotFound)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package webdav
+
+import (
+	"fmt"
+	"strings"
+)
+
+var errInvalidIf = fmt.Errorf("%w: If header", ErrInvalid)
+
+// Condition is a condition of an If header: a lock token or an entity tag,
+// possibly negated.
+type Condition struct {
+	Not   bool
+	Token string
+	// ETag is the quoted entity tag, if Token is empty.
+	ETag string
+}
+
+// List is a list of conditions of an If header, all of which must hold. It
+// applies to the tagged resource, or to the request URL if Resource is
+// empty.
+type List struct {
+	Resource   string
+	Conditions []Condition
+}
+
+// If is a parsed If header. It holds if any of its lists holds.
+type If []List
+
+// Tokens returns the lock tokens submitted by the header, the tokens of
+// its conditions that are not negated.
+func (h If) Tokens() []string {
+	var tokens []string
+	for _, list := range h {
+		for _, c := range list.Conditions {
+			if c.Token != "" && !c.Not {
+				tokens = append(tokens, c.Token)
+			}
+		}
+	}
+	return tokens
+}
+
+// ParseIf parses the value of an If header (RFC 4918, section 10.4).
+func ParseIf(s string) (If, error) {
+	var h If
+	resource, tagged := "", false
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			break
+		}
+		switch s[0] {
+		case '<':
+			if len(h) > 0 && !tagged {
+				return nil, errInvalidIf
+			}
+			end := strings.IndexByte(s, '>')
+			if end < 2 {
+				return nil, errInvalidIf
+			}
+			resource, tagged, s = s[1:end], true, s[end+1:]
+			if !strings.HasPrefix(strings.TrimLeft(s, " \t"), "(") {
+				return nil, errInvalidIf
+			}
+		case '(':
+			if len(h) > 0 && tagged != (h[0].Resource != "") {
+				return nil, errInvalidIf
+			}
+			list := List{Resource: resource}
+			var err error
+			if list.Conditions, s, err = parseConditions(s[1:]); err != nil {
+				return nil, err
+			}
+			h = append(h, list)
+		default:
+			return nil, errInvalidIf
+		}
+	}
+	if len(h) == 0 {
+		return nil, errInvalidIf
+	}
+	return h, nil
+}
+
+// parseConditions parses the conditions of a list up to its closing
+// parenthesis, returning the rest of s.
+func parseConditions(s string) ([]Condition, string, error) {
+	var conditions []Condition
+	for {
+		s = strings.TrimLeft(s, " \t")
+		var c Condition
+		if strings.HasPrefix(s, "Not") {
+			c.Not, s = true, strings.TrimLeft(s[len("Not"):], " \t")
+		}
+		switch {
+		case s == "":
+			return nil, "", errInvalidIf
+		case s[0] == ')' && !c.Not && len(conditions) > 0:
+			return conditions, s[1:], nil
+		case s[0] == '<':
+			end := strings.IndexByte(s, '>')
+			if end < 2 {
+				return nil, "", errInvalidIf
+			}
+			c.Token, s = s[1:end], s[end+1:]
+		case s[0] == '[':
+			end := strings.IndexByte(s, ']')
+			if end < 2 {
+				return nil, "", errInvalidIf
+			}
+			c.ETag, s = s[1:end], s[end+1:]
+		default:
+			return nil, "", errInvalidIf
+		}
+		conditions = append(conditions, c)
+	}
+}
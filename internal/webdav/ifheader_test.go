@@ -0,0 +1,65 @@
+package webdav
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIf(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    If
+		wantErr bool
+	}{
+		{
+			name:  "token",
+			value: "(<urn:uuid:1>)",
+			want:  If{{Conditions: []Condition{{Token: "urn:uuid:1"}}}},
+		},
+		{
+			name:  "lists",
+			value: `(<urn:uuid:1> ["etag"]) (Not <DAV:no-lock>)`,
+			want: If{
+				{Conditions: []Condition{{Token: "urn:uuid:1"}, {ETag: `"etag"`}}},
+				{Conditions: []Condition{{Not: true, Token: "DAV:no-lock"}}},
+			},
+		},
+		{
+			name:  "tagged",
+			value: `<http://example.com/a> (<urn:uuid:1>) <http://example.com/b> (["x"])`,
+			want: If{
+				{Resource: "http://example.com/a", Conditions: []Condition{{Token: "urn:uuid:1"}}},
+				{Resource: "http://example.com/b", Conditions: []Condition{{ETag: `"x"`}}},
+			},
+		},
+		{name: "empty", value: " ", wantErr: true},
+		{name: "empty list", value: "()", wantErr: true},
+		{name: "unterminated", value: "(<urn:uuid:1>", wantErr: true},
+		{name: "tag without list", value: "<http://example.com/a>", wantErr: true},
+		{name: "mixed", value: "(<urn:uuid:1>) <http://example.com/a> (<urn:uuid:2>)", wantErr: true},
+		{name: "dangling Not", value: "(<urn:uuid:1> Not)", wantErr: true},
+		{name: "bare token", value: "urn:uuid:1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseIf(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseIf() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseIf() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIf_Tokens(t *testing.T) {
+	h, err := ParseIf(`(<urn:uuid:1> Not <urn:uuid:2>) (<urn:uuid:3>)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := h.Tokens(), []string{"urn:uuid:1", "urn:uuid:3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("If.Tokens() = %v, want %v", got, want)
+	}
+}
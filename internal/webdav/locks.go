@@ -0,0 +1,272 @@
+// Package webdav keeps the locks of the WebDAV protocol (RFC 4918) and
+// parses and writes its headers and XML bodies.
+package webdav
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout is the lifetime of locks requested without a timeout
+	// or with an infinite one.
+	DefaultTimeout = time.Hour
+	// MaxTimeout is the longest lifetime a lock is granted.
+	MaxTimeout = 24 * time.Hour
+	// MaxLocks is the number of locks a server keeps at most, and
+	// MaxLocksPerClient the number of those held by a single client.
+	MaxLocks          = 10000
+	MaxLocksPerClient = 100
+
+	tokenPrefix = "urn:uuid:"
+)
+
+var (
+	// ErrLocked is returned for requests conflicting with a lock they do
+	// not hold.
+	ErrLocked = errors.New("resource is locked")
+	// ErrNoSuchLock is returned for unknown and expired lock tokens, and
+	// for tokens of locks not covering the resource.
+	ErrNoSuchLock = errors.New("no such lock")
+	// ErrTooManyLocks is returned by Create once the server or the client
+	// holds as many locks as allowed.
+	ErrTooManyLocks = errors.New("too many locks")
+)
+
+// Lock is a write lock on a resource and, unless ZeroDepth is set, on all
+// its members.
+type Lock struct {
+	Token string
+	// Root is the cleaned URL path of the locked resource.
+	Root      string
+	ZeroDepth bool
+	// Shared locks may coexist with other shared locks; exclusive locks
+	// conflict with all others.
+	Shared bool
+	// Owner is the owner XML sent by the client, returned as is.
+	Owner string
+	// Client identifies the client that created the lock, to bound the
+	// number of locks each client holds.
+	Client  string
+	Timeout time.Duration
+	Expires time.Time
+}
+
+// covers reports whether the lock applies to the resource at the cleaned
+// URL path p.
+func (l *Lock) covers(p string) bool {
+	return l.Root == p || !l.ZeroDepth && isMember(p, l.Root)
+}
+
+// isMember reports whether p lies below the collection dir.
+func isMember(p, dir string) bool {
+	return strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// Locks keeps the locks of a server in memory. Expired locks are dropped
+// as they are encountered.
+type Locks struct {
+	now          func() time.Time
+	max          int
+	maxPerClient int
+
+	mu      sync.Mutex
+	byToken map[string]*Lock
+}
+
+// NewLocks returns an empty lock manager.
+func NewLocks() *Locks {
+	return &Locks{
+		now:          time.Now,
+		max:          MaxLocks,
+		maxPerClient: MaxLocksPerClient,
+		byToken:      make(map[string]*Lock),
+	}
+}
+
+// Clean returns the cleaned URL path locks are kept under.
+func Clean(p string) string {
+	return path.Clean("/" + p)
+}
+
+// timeout bounds the requested lock lifetime, 0 for the default.
+func timeout(d time.Duration) time.Duration {
+	switch {
+	case d <= 0:
+		return DefaultTimeout
+	case d > MaxTimeout:
+		return MaxTimeout
+	}
+	return d
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%s%x-%x-%x-%x-%x", tokenPrefix, b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// expire drops expired locks. It is called with l.mu held.
+func (l *Locks) expire() {
+	now := l.now()
+	for token, lock := range l.byToken {
+		if !now.Before(lock.Expires) {
+			delete(l.byToken, token)
+		}
+	}
+}
+
+// sorted returns the locks matching keep, sorted by root and token.
+func (l *Locks) sorted(keep func(*Lock) bool) []Lock {
+	var locks []Lock
+	for _, lock := range l.byToken {
+		if keep(lock) {
+			locks = append(locks, *lock)
+		}
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		if locks[i].Root != locks[j].Root {
+			return locks[i].Root < locks[j].Root
+		}
+		return locks[i].Token < locks[j].Token
+	})
+	return locks
+}
+
+// Create locks the resource at the URL path root and, unless zeroDepth is
+// set, its members, on behalf of client. It returns ErrLocked if a
+// conflicting lock is held on the resource, one of its ancestors or, for
+// deep locks, one of its members, and ErrTooManyLocks if the server or the
+// client already holds as many locks as allowed.
+func (l *Locks) Create(root string, zeroDepth, shared bool, owner, client string, d time.Duration) (Lock, error) {
+	root = Clean(root)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire()
+	held := 0
+	for _, lock := range l.byToken {
+		if lock.Client == client {
+			held++
+		}
+		if shared && lock.Shared {
+			continue
+		}
+		if lock.covers(root) || !zeroDepth && isMember(lock.Root, root) {
+			return Lock{}, ErrLocked
+		}
+	}
+	if len(l.byToken) >= l.max || held >= l.maxPerClient {
+		return Lock{}, ErrTooManyLocks
+	}
+	token, err := newToken()
+	if err != nil {
+		return Lock{}, err
+	}
+	d = timeout(d)
+	lock := &Lock{
+		Token:     token,
+		Root:      root,
+		ZeroDepth: zeroDepth,
+		Shared:    shared,
+		Owner:     owner,
+		Client:    client,
+		Timeout:   d,
+		Expires:   l.now().Add(d),
+	}
+	l.byToken[token] = lock
+	return *lock, nil
+}
+
+// Refresh restarts the timeout of the lock with the given token, which
+// must cover the resource at the URL path p.
+func (l *Locks) Refresh(token, p string, d time.Duration) (Lock, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire()
+	lock, ok := l.byToken[token]
+	if !ok || !lock.covers(Clean(p)) {
+		return Lock{}, ErrNoSuchLock
+	}
+	lock.Timeout = timeout(d)
+	lock.Expires = l.now().Add(lock.Timeout)
+	return *lock, nil
+}
+
+// Unlock removes the lock with the given token, which must cover the
+// resource at the URL path p.
+func (l *Locks) Unlock(token, p string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire()
+	lock, ok := l.byToken[token]
+	if !ok || !lock.covers(Clean(p)) {
+		return ErrNoSuchLock
+	}
+	delete(l.byToken, token)
+	return nil
+}
+
+// Valid reports whether token is the token of a lock covering the
+// resource at the URL path p.
+func (l *Locks) Valid(token, p string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire()
+	lock, ok := l.byToken[token]
+	return ok && lock.covers(Clean(p))
+}
+
+// Discover returns the locks covering the resource at the URL path p.
+func (l *Locks) Discover(p string) []Lock {
+	p = Clean(p)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire()
+	return l.sorted(func(lock *Lock) bool { return lock.covers(p) })
+}
+
+// Confirm checks that tokens holds the token of every lock covering the
+// resource at the URL path p and, if members is set, of every lock on its
+// members. It returns ErrLocked otherwise.
+func (l *Locks) Confirm(p string, members bool, tokens []string) error {
+	p = Clean(p)
+	held := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		held[token] = true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire()
+	for token, lock := range l.byToken {
+		if held[token] {
+			continue
+		}
+		if lock.covers(p) || members && isMember(lock.Root, p) {
+			return fmt.Errorf("%w: %s", ErrLocked, lock.Root)
+		}
+	}
+	return nil
+}
+
+// Remove drops the locks on the resource at the URL path p and its
+// members, after it was deleted or moved away.
+func (l *Locks) Remove(p string) {
+	p = Clean(p)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for token, lock := range l.byToken {
+		if lock.Root == p || isMember(lock.Root, p) {
+			delete(l.byToken, token)
+		}
+	}
+}
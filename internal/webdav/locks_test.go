@@ -0,0 +1,136 @@
+package webdav
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLocks(t *testing.T) {
+	l := NewLocks()
+	coll, err := l.Create("/a/", false, false, "<D:href>me</D:href>", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coll.Root != "/a" || coll.Timeout != DefaultTimeout {
+		t.Errorf("Locks.Create() = %+v", coll)
+	}
+	if _, err := l.Create("/a/b/c", true, false, "", "", 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("Locks.Create() of a member error = %v, want %v", err, ErrLocked)
+	}
+	if _, err := l.Create("/", false, true, "", "", 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("Locks.Create() of an ancestor error = %v, want %v", err, ErrLocked)
+	}
+	shallow, err := l.Create("/", true, false, "", "", 0)
+	if err != nil {
+		t.Errorf("Locks.Create() of a depth 0 ancestor error = %v", err)
+	}
+	if _, err := l.Create("/ab", false, false, "", "", 0); err != nil {
+		t.Errorf("Locks.Create() of a sibling error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		members bool
+		tokens  []string
+		wantErr bool
+	}{
+		{name: "root", path: "/a", wantErr: true},
+		{name: "member", path: "/a/b/c", wantErr: true},
+		{name: "member with token", path: "/a/b/c", tokens: []string{coll.Token}},
+		{name: "depth 0 lock", path: "/", wantErr: true},
+		{name: "below depth 0 lock", path: "/b"},
+		{name: "members", path: "/", members: true, tokens: []string{shallow.Token}, wantErr: true},
+		{name: "sibling prefix", path: "/abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := l.Confirm(tt.path, tt.members, tt.tokens); (err != nil) != tt.wantErr {
+				t.Errorf("Locks.Confirm() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if !l.Valid(coll.Token, "/a/b") || l.Valid(coll.Token, "/b") || l.Valid("urn:uuid:x", "/a") {
+		t.Error("Locks.Valid() wrong")
+	}
+	if got := l.Discover("/a/b"); len(got) != 1 || got[0].Token != coll.Token {
+		t.Errorf("Locks.Discover() = %+v", got)
+	}
+	if _, err := l.Refresh(coll.Token, "/b", time.Minute); !errors.Is(err, ErrNoSuchLock) {
+		t.Errorf("Locks.Refresh() outside the lock error = %v", err)
+	}
+	if got, err := l.Refresh(coll.Token, "/a/b", 48*time.Hour); err != nil || got.Timeout != MaxTimeout {
+		t.Errorf("Locks.Refresh() = %+v, %v", got, err)
+	}
+	if err := l.Unlock(coll.Token, "/b"); !errors.Is(err, ErrNoSuchLock) {
+		t.Errorf("Locks.Unlock() outside the lock error = %v", err)
+	}
+	if err := l.Unlock(coll.Token, "/a/"); err != nil {
+		t.Errorf("Locks.Unlock() error = %v", err)
+	}
+	if err := l.Confirm("/a/b/c", false, nil); err != nil {
+		t.Errorf("Locks.Confirm() after Unlock error = %v", err)
+	}
+}
+
+func TestLocks_shared(t *testing.T) {
+	l := NewLocks()
+	if _, err := l.Create("/a", false, true, "", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Create("/a/b", true, true, "", "", 0); err != nil {
+		t.Errorf("Locks.Create() of a second shared lock error = %v", err)
+	}
+	if _, err := l.Create("/a", true, false, "", "", 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("Locks.Create() of an exclusive lock error = %v, want %v", err, ErrLocked)
+	}
+	l.Remove("/a")
+	if got := l.Discover("/a/b"); len(got) != 0 {
+		t.Errorf("Locks.Discover() after Remove = %+v", got)
+	}
+}
+
+func TestLocks_expiry(t *testing.T) {
+	now := time.Now()
+	l := NewLocks()
+	l.now = func() time.Time { return now }
+	lock, err := l.Create("/a", false, false, "", "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Second)
+	if l.Valid(lock.Token, "/a") {
+		t.Error("Locks.Valid() of an expired lock")
+	}
+	if _, err := l.Create("/a", false, false, "", "", 0); err != nil {
+		t.Errorf("Locks.Create() after expiry error = %v", err)
+	}
+}
+
+func TestLocks_limits(t *testing.T) {
+	l := NewLocks()
+	l.max, l.maxPerClient = 3, 2
+	for _, p := range []string{"/a", "/b"} {
+		if _, err := l.Create(p, true, false, "", "10.0.0.1", 0); err != nil {
+			t.Fatalf("Locks.Create(%q) error = %v", p, err)
+		}
+	}
+	if _, err := l.Create("/c", true, false, "", "10.0.0.1", 0); !errors.Is(err, ErrTooManyLocks) {
+		t.Errorf("Locks.Create() beyond the client limit error = %v, want %v", err, ErrTooManyLocks)
+	}
+	lock, err := l.Create("/c", true, false, "", "10.0.0.2", 0)
+	if err != nil {
+		t.Fatalf("Locks.Create() of another client error = %v", err)
+	}
+	if _, err := l.Create("/d", true, false, "", "10.0.0.3", 0); !errors.Is(err, ErrTooManyLocks) {
+		t.Errorf("Locks.Create() beyond the server limit error = %v, want %v", err, ErrTooManyLocks)
+	}
+	if err := l.Unlock(lock.Token, "/c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Create("/d", true, false, "", "10.0.0.3", 0); err != nil {
+		t.Errorf("Locks.Create() after Unlock error = %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+package webdav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Namespace is the XML namespace of the WebDAV elements.
+const Namespace = "DAV:"
+
+// Infinity is the depth of requests applying to all members of a
+// collection.
+const Infinity = -1
+
+// ErrInvalid is returned for malformed headers and request bodies.
+var ErrInvalid = errors.New("invalid WebDAV request")
+
+// ParseDepth parses a Depth header, def if the header is empty.
+func ParseDepth(s string, def int) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return def, nil
+	case "0":
+		return 0, nil
+	case "1":
+		return 1, nil
+	case "infinity":
+		return Infinity, nil
+	}
+	return 0, fmt.Errorf("%w: Depth %q", ErrInvalid, s)
+}
+
+// ParseTimeout parses a Timeout header, returning the first lifetime it
+// lists that can be parsed, or 0 for the default.
+func ParseTimeout(s string) time.Duration {
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if strings.EqualFold(t, "Infinite") {
+			return MaxTimeout
+		}
+		if len(t) > len("Second-") && strings.EqualFold(t[:len("Second-")], "Second-") {
+			if n, err := strconv.ParseUint(t[len("Second-"):], 10, 32); err == nil {
+				return time.Duration(n) * time.Second
+			}
+		}
+	}
+	return 0
+}
+
+// names collects the names of the child elements of an element.
+type names []xml.Name
+
+func (n *names) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			*n = append(*n, t.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// innerXML holds the content of an element, re-encoded so that it does not
+// depend on namespace prefixes declared outside of it.
+type innerXML string
+
+func (x *innerXML) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var b bytes.Buffer
+	e := xml.NewEncoder(&b)
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			attrs := t.Attr[:0]
+			for _, a := range t.Attr {
+				if a.Name.Space != "xmlns" && !(a.Name.Space == "" && a.Name.Local == "xmlns") {
+					attrs = append(attrs, a)
+				}
+			}
+			t.Attr = attrs
+			tok = t
+		case xml.EndElement:
+			if depth == 0 {
+				if err := e.Flush(); err != nil {
+					return err
+				}
+				*x = innerXML(b.String())
+				return nil
+			}
+			depth--
+		case xml.ProcInst, xml.Directive:
+			continue
+		}
+		if err := e.EncodeToken(xml.CopyToken(tok)); err != nil {
+			return err
+		}
+	}
+}
+
+// decode decodes the XML body r into v, reporting whether the body was
+// empty.
+func decode(r io.Reader, v interface{}) (empty bool, err error) {
+	err = xml.NewDecoder(r).Decode(v)
+	if err == io.EOF {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return false, nil
+}
+
+// Propfind is a parsed PROPFIND request body. It asks for all properties
+// unless PropName is set or Names are listed.
+type Propfind struct {
+	// PropName asks for the names of all properties, without values.
+	PropName bool
+	// Names are the properties asked for.
+	Names []xml.Name
+}
+
+// ReadPropfind parses a PROPFIND request body. An empty body asks for all
+// properties.
+func ReadPropfind(r io.Reader) (Propfind, error) {
+	var body struct {
+		XMLName  xml.Name  `xml:"DAV: propfind"`
+		AllProp  *struct{} `xml:"DAV: allprop"`
+		PropName *struct{} `xml:"DAV: propname"`
+		Prop     *names    `xml:"DAV: prop"`
+	}
+	if empty, err := decode(r, &body); empty || err != nil {
+		return Propfind{}, err
+	}
+	n := 0
+	for _, set := range []bool{body.AllProp != nil, body.PropName != nil, body.Prop != nil} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return Propfind{}, fmt.Errorf("%w: want one of allprop, propname or prop", ErrInvalid)
+	}
+	pf := Propfind{PropName: body.PropName != nil}
+	if body.Prop != nil {
+		pf.Names = *body.Prop
+		if len(pf.Names) == 0 {
+			return Propfind{}, fmt.Errorf("%w: empty prop", ErrInvalid)
+		}
+	}
+	return pf, nil
+}
+
+// ReadProppatch parses a PROPPATCH request body, returning the names of
+// the properties set or removed.
+func ReadProppatch(r io.Reader) ([]xml.Name, error) {
+	type props struct {
+		Prop names `xml:"DAV: prop"`
+	}
+	var body struct {
+		XMLName xml.Name `xml:"DAV: propertyupdate"`
+		Set     []props  `xml:"DAV: set"`
+		Remove  []props  `xml:"DAV: remove"`
+	}
+	empty, err := decode(r, &body)
+	if err != nil {
+		return nil, err
+	}
+	var all []xml.Name
+	for _, p := range append(body.Set, body.Remove...) {
+		all = append(all, p.Prop...)
+	}
+	if empty || len(all) == 0 {
+		return nil, fmt.Errorf("%w: no properties to update", ErrInvalid)
+	}
+	return all, nil
+}
+
+// LockInfo is a parsed LOCK request body.
+type LockInfo struct {
+	Shared bool
+	// Owner is the content of the owner element.
+	Owner string
+}
+
+// ReadLockInfo parses a LOCK request body. An empty body asks to refresh
+// the locks of the tokens in the If header.
+func ReadLockInfo(r io.Reader) (info LockInfo, refresh bool, err error) {
+	var body struct {
+		XMLName   xml.Name `xml:"DAV: lockinfo"`
+		LockScope struct {
+			Exclusive *struct{} `xml:"DAV: exclusive"`
+			Shared    *struct{} `xml:"DAV: shared"`
+		} `xml:"DAV: lockscope"`
+		LockType struct {
+			Write *struct{} `xml:"DAV: write"`
+		} `xml:"DAV: locktype"`
+		Owner innerXML `xml:"DAV: owner"`
+	}
+	empty, err := decode(r, &body)
+	if empty || err != nil {
+		return LockInfo{}, empty, err
+	}
+	if (body.LockScope.Exclusive == nil) == (body.LockScope.Shared == nil) || body.LockType.Write == nil {
+		return LockInfo{}, false, fmt.Errorf("%w: want an exclusive or shared write lock", ErrInvalid)
+	}
+	return LockInfo{Shared: body.LockScope.Shared != nil, Owner: string(body.Owner)}, false, nil
+}
+
+// Text escapes s for use as XML character data.
+func Text(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Property is a property of a resource, with its value as XML. Elements of
+// the WebDAV namespace in the value use the prefix "D".
+type Property struct {
+	Name     xml.Name
+	InnerXML string
+}
+
+func (p Property) String() string {
+	name, ns := p.Name.Local, ""
+	switch p.Name.Space {
+	case "":
+	case Namespace:
+		name = "D:" + name
+	default:
+		name, ns = "R:"+name, ` xmlns:R="`+Text(p.Name.Space)+`"`
+	}
+	if p.InnerXML == "" {
+		return "<" + name + ns + "/>"
+	}
+	return "<" + name + ns + ">" + p.InnerXML + "</" + name + ">"
+}
+
+// Propstat are properties of a resource sharing a status.
+type Propstat struct {
+	Props  []Property
+	Status int
+}
+
+// Response is the response for one resource of a multistatus body: either
+// its properties, or a status.
+type Response struct {
+	Href      string
+	Propstats []Propstat
+	Status    int
+}
+
+func statusLine(status int) string {
+	return fmt.Sprintf("<D:status>HTTP/1.1 %d %s</D:status>", status, http.StatusText(status))
+}
+
+const xmlHeader = `<?xml version="1.0" encoding="utf-8"?>` + "\n"
+
+// WriteMultistatus writes a 207 Multi-Status response.
+func WriteMultistatus(w http.ResponseWriter, responses []Response) error {
+	var b strings.Builder
+	b.WriteString(xmlHeader + `<D:multistatus xmlns:D="DAV:">`)
+	for _, r := range responses {
+		b.WriteString("<D:response><D:href>" + Text(r.Href) + "</D:href>")
+		for _, ps := range r.Propstats {
+			b.WriteString("<D:propstat><D:prop>")
+			for _, p := range ps.Props {
+				b.WriteString(p.String())
+			}
+			b.WriteString("</D:prop>" + statusLine(ps.Status) + "</D:propstat>")
+		}
+		if r.Status != 0 {
+			b.WriteString(statusLine(r.Status))
+		}
+		b.WriteString("</D:response>")
+	}
+	b.WriteString("</D:multistatus>\n")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// SupportedLock is the value of the supportedlock property.
+const SupportedLock = `<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>` +
+	`<D:lockentry><D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>`
+
+// ActiveLock returns the activelock element describing l, whose root is
+// at the URL href.
+func ActiveLock(l Lock, href string) string {
+	scope, depth := "<D:exclusive/>", "infinity"
+	if l.Shared {
+		scope = "<D:shared/>"
+	}
+	if l.ZeroDepth {
+		depth = "0"
+	}
+	owner := ""
+	if l.Owner != "" {
+		owner = "<D:owner>" + l.Owner + "</D:owner>"
+	}
+	return "<D:activelock><D:locktype><D:write/></D:locktype><D:lockscope>" + scope + "</D:lockscope>" +
+		"<D:depth>" + depth + "</D:depth>" + owner +
+		"<D:timeout>Second-" + strconv.Itoa(int(l.Timeout/time.Second)) + "</D:timeout>" +
+		"<D:locktoken><D:href>" + Text(l.Token) + "</D:href></D:locktoken>" +
+		"<D:lockroot><D:href>" + Text(href) + "</D:href></D:lockroot></D:activelock>"
+}
+
+// WriteLock writes the response to a LOCK request granting or refreshing
+// l, with the given status.
+func WriteLock(w http.ResponseWriter, status int, l Lock, href string) error {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	_, err := io.WriteString(w, xmlHeader+`<D:prop xmlns:D="DAV:"><D:lockdiscovery>`+ActiveLock(l, href)+"</D:lockdiscovery></D:prop>\n")
+	return err
+}
@@ -0,0 +1,108 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadPropfind(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    Propfind
+		wantErr bool
+	}{
+		{name: "empty", body: "", want: Propfind{}},
+		{name: "allprop", body: `<?xml version="1.0"?><propfind xmlns="DAV:"><allprop/></propfind>`, want: Propfind{}},
+		{name: "propname", body: `<D:propfind xmlns:D="DAV:"><D:propname/></D:propfind>`, want: Propfind{PropName: true}},
+		{
+			name: "prop",
+			body: `<D:propfind xmlns:D="DAV:" xmlns:Z="http://example.com/"><D:prop><D:getetag/><Z:color/></D:prop></D:propfind>`,
+			want: Propfind{Names: []xml.Name{{Space: "DAV:", Local: "getetag"}, {Space: "http://example.com/", Local: "color"}}},
+		},
+		{name: "not XML", body: "<propfind", wantErr: true},
+		{name: "wrong namespace", body: `<propfind><allprop/></propfind>`, wantErr: true},
+		{name: "two kinds", body: `<propfind xmlns="DAV:"><allprop/><propname/></propfind>`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadPropfind(strings.NewReader(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadPropfind() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadPropfind() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLockInfo(t *testing.T) {
+	body := `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:" xmlns:a="DAV:"><D:lockscope><D:shared/></D:lockscope>` +
+		`<D:locktype><D:write/></D:locktype><D:owner><a:href>mailto:me@example.com</a:href></D:owner></D:lockinfo>`
+	info, refresh, err := ReadLockInfo(strings.NewReader(body))
+	if err != nil || refresh {
+		t.Fatalf("ReadLockInfo() refresh = %v, error = %v", refresh, err)
+	}
+	if want := `<href xmlns="DAV:">mailto:me@example.com</href>`; !info.Shared || info.Owner != want {
+		t.Errorf("ReadLockInfo() = %+v, want shared lock owned by %s", info, want)
+	}
+	if _, refresh, err := ReadLockInfo(strings.NewReader("")); !refresh || err != nil {
+		t.Errorf("ReadLockInfo() of an empty body refresh = %v, error = %v", refresh, err)
+	}
+	if _, _, err := ReadLockInfo(strings.NewReader(`<lockinfo xmlns="DAV:"><lockscope><exclusive/></lockscope></lockinfo>`)); err == nil {
+		t.Error("ReadLockInfo() without a lock type succeeded")
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":                            0,
+		"Second-60":                   time.Minute,
+		"Infinite, Second-4100000000": MaxTimeout,
+		"Second-x, Second-1":          time.Second,
+	}
+	for value, want := range tests {
+		if got := ParseTimeout(value); got != want {
+			t.Errorf("ParseTimeout(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestWriteMultistatus(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := WriteMultistatus(w, []Response{{
+		Href: "/a%20b",
+		Propstats: []Propstat{
+			{Props: []Property{{Name: xml.Name{Space: Namespace, Local: "displayname"}, InnerXML: Text("a & b")}}, Status: 200},
+			{Props: []Property{{Name: xml.Name{Space: "http://example.com/", Local: "color"}}}, Status: 404},
+		},
+	}})
+	if err != nil || w.Code != 207 {
+		t.Fatalf("WriteMultistatus() status = %d, error = %v", w.Code, err)
+	}
+	var got struct {
+		Responses []struct {
+			Href      string `xml:"DAV: href"`
+			Propstats []struct {
+				Prop struct {
+					Inner string `xml:",innerxml"`
+				} `xml:"DAV: prop"`
+				Status string `xml:"DAV: status"`
+			} `xml:"DAV: propstat"`
+		} `xml:"DAV: response"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("WriteMultistatus() wrote invalid XML: %v\n%s", err, w.Body)
+	}
+	if len(got.Responses) != 1 || len(got.Responses[0].Propstats) != 2 || got.Responses[0].Propstats[1].Status != "HTTP/1.1 404 Not Found" {
+		t.Errorf("WriteMultistatus() wrote %s", w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `<R:color xmlns:R="http://example.com/"/>`) {
+		t.Errorf("WriteMultistatus() wrote %s", w.Body)
+	}
+}
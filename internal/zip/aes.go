@@ -0,0 +1,213 @@
+package zip
+
+import (
+	zipper "archive/zip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// Entries are encrypted following the WinZip AES specification, version
+// AE-2, with 256-bit keys.
+const (
+	aesMethod      = 99
+	aesExtraID     = 0x9901
+	aesVersion     = 2
+	aesStrength256 = 3
+	aesKeyLen      = 32
+	aesSaltLen     = 16
+	aesVerifierLen = 2
+	aesAuthLen     = 10
+	aesIterations  = 1000
+	// aesOverhead is the number of bytes encryption adds to an entry: the
+	// salt and password verifier before the data, the authentication code
+	// after it.
+	aesOverhead = aesSaltLen + aesVerifierLen + aesAuthLen
+	// aesReaderVersion is the version needed to extract encrypted entries.
+	aesReaderVersion = 51
+
+	extTimeExtraID = 0x5455
+)
+
+// pbkdf2 derives keyLen bytes from password and salt with PBKDF2, using
+// HMAC-SHA1 as the pseudorandom function (RFC 8018).
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	var out []byte
+	for block := uint32(1); len(out) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		var blockIndex [4]byte
+		binary.BigEndian.PutUint32(blockIndex[:], block)
+		prf.Write(blockIndex[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
+
+// aesWriter encrypts an entry's data. It writes the salt and password
+// verifier, then the data encrypted with AES in counter mode, and on Close
+// the truncated HMAC-SHA1 of the encrypted data.
+//
+// Unlike crypto/cipher's CTR mode, the WinZip counter starts at 1 and is
+// incremented in little-endian byte order.
+type aesWriter struct {
+	w       io.Writer
+	block   cipher.Block
+	mac     hash.Hash
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int
+	buf     []byte
+}
+
+func newAESWriter(w io.Writer, password string) (*aesWriter, error) {
+	salt := make([]byte, aesSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	keys := pbkdf2([]byte(password), salt, aesIterations, 2*aesKeyLen+aesVerifierLen)
+	block, err := aes.NewCipher(keys[:aesKeyLen])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(keys[2*aesKeyLen:]); err != nil {
+		return nil, err
+	}
+	return &aesWriter{
+		w:     w,
+		block: block,
+		mac:   hmac.New(sha1.New, keys[aesKeyLen:2*aesKeyLen]),
+		used:  aes.BlockSize,
+	}, nil
+}
+
+func (a *aesWriter) Write(p []byte) (int, error) {
+	if cap(a.buf) < len(p) {
+		a.buf = make([]byte, len(p))
+	}
+	buf := a.buf[:len(p)]
+	for i, b := range p {
+		if a.used == aes.BlockSize {
+			for j := range a.counter {
+				a.counter[j]++
+				if a.counter[j] != 0 {
+					break
+				}
+			}
+			a.block.Encrypt(a.stream[:], a.counter[:])
+			a.used = 0
+		}
+		buf[i] = b ^ a.stream[a.used]
+		a.used++
+	}
+	a.mac.Write(buf)
+	return a.w.Write(buf)
+}
+
+// Close writes the authentication code. It does not close the underlying
+// writer.
+func (a *aesWriter) Close() error {
+	_, err := a.w.Write(a.mac.Sum(nil)[:aesAuthLen])
+	return err
+}
+
+// aesExtra returns the extra field of an encrypted entry whose data is
+// compressed with method before encryption.
+func aesExtra(method uint16) []byte {
+	b := make([]byte, 11)
+	binary.LittleEndian.PutUint16(b[0:], aesExtraID)
+	binary.LittleEndian.PutUint16(b[2:], 7)
+	binary.LittleEndian.PutUint16(b[4:], aesVersion)
+	copy(b[6:], "AE")
+	b[8] = aesStrength256
+	binary.LittleEndian.PutUint16(b[9:], method)
+	return b
+}
+
+// extTimeExtra returns the extended timestamp extra field that
+// zip.Writer.CreateHeader adds for the modification time t.
+func extTimeExtra(t time.Time) []byte {
+	b := make([]byte, 9)
+	binary.LittleEndian.PutUint16(b[0:], extTimeExtraID)
+	binary.LittleEndian.PutUint16(b[2:], 5)
+	b[4] = 1
+	binary.LittleEndian.PutUint32(b[5:], uint32(t.Unix()))
+	return b
+}
+
+// msDosTime converts t to the MS-DOS date and time fields of a zip header,
+// like zip.Writer.CreateHeader.
+func msDosTime(t time.Time) (date, clock uint16) {
+	date = uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock = uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
+
+// createEncrypted adds an entry of size bytes, written by write, encrypted
+// with password. The data is stored uncompressed inside the encryption so
+// that the entry's sizes are known before it is written and the archive
+// can be streamed; as AE-2 requires, no CRC of the plaintext is stored.
+func createEncrypted(w *zipper.Writer, header *zipper.FileHeader, password string, size int64, write func(io.Writer) (int64, error)) error {
+	header.Method = aesMethod
+	header.Flags |= 0x1
+	if !isASCII(header.Name) && utf8.ValidString(header.Name) {
+		header.Flags |= 0x800
+	}
+	header.CreatorVersion = header.CreatorVersion&0xff00 | aesReaderVersion
+	header.ReaderVersion = aesReaderVersion
+	header.CRC32 = 0
+	header.UncompressedSize64 = uint64(size)
+	header.CompressedSize64 = uint64(size) + aesOverhead
+	header.Extra = aesExtra(zipper.Store)
+	if !header.Modified.IsZero() {
+		header.ModifiedDate, header.ModifiedTime = msDosTime(header.Modified)
+		header.Extra = append(header.Extra, extTimeExtra(header.Modified)...)
+	}
+	zw, err := w.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	ew, err := newAESWriter(zw, password)
+	if err != nil {
+		return err
+	}
+	n, err := write(ew)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("%s: size changed from %d to %d bytes while archiving", header.Name, size, n)
+	}
+	return ew.Close()
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
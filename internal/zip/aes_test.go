@@ -0,0 +1,203 @@
+package zip
+
+import (
+	zipper "archive/zip"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sgreben/httpfileserver/internal/archive"
+)
+
+func Test_pbkdf2(t *testing.T) {
+	// Test vectors for PBKDF2-HMAC-SHA1 from RFC 6070.
+	tests := []struct {
+		name       string
+		password   string
+		salt       string
+		iterations int
+		keyLen     int
+		want       string
+	}{
+		{
+			name:       "1 iteration",
+			password:   "password",
+			salt:       "salt",
+			iterations: 1,
+			keyLen:     20,
+			want:       "0c60c80f961f0e71f3a9b524af6012062fe037a6",
+		},
+		{
+			name:       "2 iterations",
+			password:   "password",
+			salt:       "salt",
+			iterations: 2,
+			keyLen:     20,
+			want:       "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957",
+		},
+		{
+			name:       "4096 iterations",
+			password:   "password",
+			salt:       "salt",
+			iterations: 4096,
+			keyLen:     20,
+			want:       "4b007901b765489abead49d926f721d065a429c1",
+		},
+		{
+			name:       "multiple blocks",
+			password:   "passwordPASSWORDpassword",
+			salt:       "saltSALTsaltSALTsaltSALTsaltSALTsalt",
+			iterations: 4096,
+			keyLen:     25,
+			want:       "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hex.EncodeToString(pbkdf2([]byte(tt.password), []byte(tt.salt), tt.iterations, tt.keyLen))
+			if got != tt.want {
+				t.Errorf("pbkdf2() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// decryptAE decrypts the raw data of an AE-2 entry as described in the
+// WinZip specification, checking the password verifier and authentication
+// code.
+func decryptAE(t *testing.T, raw []byte, password string) []byte {
+	t.Helper()
+	if len(raw) < aesSaltLen+aesVerifierLen+aesAuthLen {
+		t.Fatalf("encrypted data too short: %d bytes", len(raw))
+	}
+	salt := raw[:aesSaltLen]
+	verifier := raw[aesSaltLen : aesSaltLen+aesVerifierLen]
+	data := raw[aesSaltLen+aesVerifierLen : len(raw)-aesAuthLen]
+	auth := raw[len(raw)-aesAuthLen:]
+
+	keys := pbkdf2([]byte(password), salt, 1000, 66)
+	if !bytes.Equal(verifier, keys[64:]) {
+		t.Fatalf("password verifier = %x, want %x", verifier, keys[64:])
+	}
+	mac := hmac.New(sha1.New, keys[32:64])
+	mac.Write(data)
+	if want := mac.Sum(nil)[:10]; !hmac.Equal(auth, want) {
+		t.Fatalf("authentication code = %x, want %x", auth, want)
+	}
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := make([]byte, len(data))
+	var counter, stream [aes.BlockSize]byte
+	for i := 0; i < len(data); i += aes.BlockSize {
+		binary.LittleEndian.PutUint64(counter[:8], uint64(i/aes.BlockSize+1))
+		block.Encrypt(stream[:], counter[:])
+		for j := i; j < len(data) && j < i+aes.BlockSize; j++ {
+			plain[j] = data[j] ^ stream[j-i]
+		}
+	}
+	return plain
+}
+
+func readEncrypted(t *testing.T, f *zipper.File, password string) string {
+	t.Helper()
+	r, err := f.OpenRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decryptAE(t, raw, password))
+}
+
+func TestZip_encrypted(t *testing.T) {
+	dir := testTree(t)
+	long := strings.Repeat("0123456789", 100)
+	if err := os.WriteFile(filepath.Join(dir, "long.txt"), []byte(long), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	opts := archive.Options{Password: "secret", Symlinks: archive.SymlinksStore, Checksums: true}
+	if err := Zip(context.Background(), &buf, dir, opts); err != nil {
+		t.Fatalf("Zip() error = %v", err)
+	}
+	files := readZip(t, buf.Bytes())
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "file.txt", want: "content"},
+		{name: "long.txt", want: long},
+		{name: "link", want: "file.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, ok := files[tt.name]
+			if !ok {
+				t.Fatalf("Zip() has no %q entry", tt.name)
+			}
+			if f.Method != aesMethod {
+				t.Errorf("method = %d, want %d", f.Method, aesMethod)
+			}
+			if f.Flags&0x1 == 0 {
+				t.Errorf("flags = %#x, want encrypted bit", f.Flags)
+			}
+			if f.CRC32 != 0 {
+				t.Errorf("CRC32 = %#x, want 0 for AE-2", f.CRC32)
+			}
+			if f.UncompressedSize64 != uint64(len(tt.want)) || f.CompressedSize64 != uint64(len(tt.want))+28 {
+				t.Errorf("sizes = %d/%d, want %d/%d", f.CompressedSize64, f.UncompressedSize64, len(tt.want)+28, len(tt.want))
+			}
+			// AES extra field: ID 0x9901, size 7, vendor version 2 (AE-2),
+			// vendor ID "AE", strength 3 (AES-256), method 0 (stored).
+			if want := []byte{0x01, 0x99, 7, 0, 2, 0, 'A', 'E', 3, 0, 0}; !bytes.Contains(f.Extra, want) {
+				t.Errorf("extra = %x, want AES extra field %x", f.Extra, want)
+			}
+			if got := readEncrypted(t, f, "secret"); got != tt.want {
+				t.Errorf("decrypted = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if f := files["empty/"]; f == nil || f.Flags&0x1 != 0 {
+		t.Errorf("Zip() directory entry = %+v, want unencrypted", f)
+	}
+	if got := readEncrypted(t, files[archive.ChecksumsFileName], "secret"); !strings.Contains(got, "  file.txt\n") {
+		t.Errorf("Zip() %s = %q, want digest of file.txt", archive.ChecksumsFileName, got)
+	}
+}
+
+func TestZip_encryptedSalt(t *testing.T) {
+	dir := testTree(t)
+	salts := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		if err := Zip(context.Background(), &buf, dir, archive.Options{Password: "secret"}); err != nil {
+			t.Fatalf("Zip() error = %v", err)
+		}
+		r, err := readZip(t, buf.Bytes())["file.txt"].OpenRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		salt := make([]byte, aesSaltLen)
+		if _, err := io.ReadFull(r, salt); err != nil {
+			t.Fatal(err)
+		}
+		salts[string(salt)] = true
+	}
+	if len(salts) != 2 {
+		t.Errorf("Zip() reused the salt between archives")
+	}
+}
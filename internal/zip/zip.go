@@ -2,46 +2,113 @@ package zip
 
 import (
 	zipper "archive/zip"
+	"compress/flate"
+	"context"
 	"io"
-	"log"
-	"os"
-	"path/filepath"
+
+	"github.com/sgreben/httpfileserver/internal/archive"
 )
 
-func Zip(w io.Writer, path string) error {
-	basePath := path
-	addFile := func(w *zipper.Writer, path string, stat os.FileInfo) error {
-		if stat.IsDir() {
-			return nil
-		}
-		file, err := os.Open(path)
+// Zip writes an archive of the file tree rooted at path to w. Files are
+// deflated at the level selected by opts, or stored uncompressed with
+// opts.Store. It stops walking as soon as ctx is done and returns the
+// context's error.
+//
+// With opts.Password, files are encrypted with AES-256 following the WinZip
+// AE-2 specification and stored uncompressed; directories are not
+// encrypted.
+//
+// On error the central directory is not written so that the archive cannot
+// be mistaken for a complete one.
+func Zip(ctx context.Context, w io.Writer, path string, opts archive.Options) error {
+	sums := archive.NewChecksums(opts)
+	addFile := func(w *zipper.Writer, entry archive.Entry) error {
+		header, err := zipper.FileInfoHeader(entry.Info)
 		if err != nil {
 			return err
 		}
-		defer file.Close()
-		path, err = filepath.Rel(basePath, path)
-		if err != nil {
-			return err
+		header.Name = entry.Name
+		header.Modified = opts.ModTime(header.Modified)
+		switch {
+		case entry.Info.IsDir():
+			header.Name += "/"
+		case entry.Link != "" || opts.Store:
+			header.Method = zipper.Store
+		default:
+			header.Method = zipper.Deflate
+		}
+		var size int64
+		var write func(io.Writer) (int64, error)
+		switch {
+		case entry.Link != "":
+			size = int64(len(entry.Link))
+			write = func(zw io.Writer) (int64, error) {
+				n, err := io.WriteString(zw, entry.Link)
+				return int64(n), err
+			}
+		case entry.File != nil:
+			size = entry.Info.Size()
+			write = func(zw io.Writer) (int64, error) {
+				return sums.Copy(ctx, zw, entry)
+			}
 		}
-		zw, err := w.Create(path)
+		if opts.Password != "" && write != nil {
+			if err := createEncrypted(w, header, opts.Password, size, write); err != nil {
+				return err
+			}
+			return w.Flush()
+		}
+		zw, err := w.CreateHeader(header)
 		if err != nil {
 			return err
 		}
-		if _, err := io.Copy(zw, file); err != nil {
-			return err
+		if write != nil {
+			if _, err := write(zw); err != nil {
+				return err
+			}
 		}
 		return w.Flush()
 	}
-	wZip := zipper.NewWriter(w)
-	defer func() {
-		if err := wZip.Close(); err != nil {
-			log.Println(err)
+	addGenerated := func(w *zipper.Writer, name string, data []byte) error {
+		header := &zipper.FileHeader{
+			Name:     name,
+			Method:   zipper.Deflate,
+			Modified: opts.GeneratedTime(),
+		}
+		write := func(zw io.Writer) (int64, error) {
+			n, err := zw.Write(data)
+			return int64(n), err
+		}
+		if opts.Password != "" {
+			return createEncrypted(w, header, opts.Password, int64(len(data)), write)
 		}
-	}()
-	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		zw, err := w.CreateHeader(header)
 		if err != nil {
 			return err
 		}
-		return addFile(wZip, path, info)
+		_, err = write(zw)
+		return err
+	}
+	wZip := zipper.NewWriter(w)
+	level := opts.CompressionLevel()
+	wZip.RegisterCompressor(zipper.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+	skipped, err := archive.Walk(ctx, path, opts, func(entry archive.Entry) error {
+		return addFile(wZip, entry)
 	})
+	if err != nil {
+		return err
+	}
+	if sums != nil {
+		if err := addGenerated(wZip, archive.ChecksumsFileName, sums.Bytes()); err != nil {
+			return err
+		}
+	}
+	if len(skipped) > 0 {
+		if err := addGenerated(wZip, archive.ErrorsFileName, archive.ErrorsFile(skipped)); err != nil {
+			return err
+		}
+	}
+	return wZip.Close()
 }
@@ -0,0 +1,207 @@
+package zip
+
+import (
+	zipper "archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sgreben/httpfileserver/internal/archive"
+)
+
+func testTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "empty"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(file, []byte("content"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, time.Unix(1500000000, 0), time.Unix(1500000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func readZip(t *testing.T, data []byte) map[string]*zipper.File {
+	t.Helper()
+	zr, err := zipper.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]*zipper.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	return files
+}
+
+func readEntry(t *testing.T, f *zipper.File) string {
+	t.Helper()
+	rc, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestZip(t *testing.T) {
+	dir := testTree(t)
+
+	tests := []struct {
+		name     string
+		opts     archive.Options
+		wantLink os.FileMode
+		wantMiss bool
+	}{
+		{
+			name:     "follow symlinks",
+			opts:     archive.Options{Symlinks: archive.SymlinksFollow},
+			wantLink: 0,
+		},
+		{
+			name:     "store symlinks",
+			opts:     archive.Options{Symlinks: archive.SymlinksStore},
+			wantLink: os.ModeSymlink,
+		},
+		{
+			name:     "skip symlinks",
+			opts:     archive.Options{Symlinks: archive.SymlinksSkip},
+			wantMiss: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Zip(context.Background(), &buf, dir, tt.opts); err != nil {
+				t.Fatalf("Zip() error = %v", err)
+			}
+			files := readZip(t, buf.Bytes())
+
+			if f, ok := files["empty/"]; !ok || !f.Mode().IsDir() {
+				t.Errorf("Zip() missing directory entry %q", "empty/")
+			}
+			f, ok := files["file.txt"]
+			if !ok {
+				t.Fatalf("Zip() missing entry %q", "file.txt")
+			}
+			if !f.Modified.Equal(time.Unix(1500000000, 0)) {
+				t.Errorf("Zip() mtime = %v", f.Modified)
+			}
+			if f.Mode().Perm() != 0640 {
+				t.Errorf("Zip() mode = %v, want %v", f.Mode().Perm(), os.FileMode(0640))
+			}
+
+			link, ok := files["link"]
+			if ok == tt.wantMiss {
+				t.Fatalf("Zip() link entry present = %v, wantMiss %v", ok, tt.wantMiss)
+			}
+			if tt.wantMiss {
+				return
+			}
+			if got := link.Mode() & os.ModeSymlink; got != tt.wantLink {
+				t.Errorf("Zip() link mode = %v, want %v", got, tt.wantLink)
+			}
+			wantContent := "content"
+			if tt.wantLink != 0 {
+				wantContent = "file.txt"
+			}
+			if got := readEntry(t, link); got != wantContent {
+				t.Errorf("Zip() link content = %q, want %q", got, wantContent)
+			}
+		})
+	}
+}
+
+func TestZip_level(t *testing.T) {
+	dir := testTree(t)
+
+	tests := []struct {
+		name       string
+		opts       archive.Options
+		wantMethod uint16
+	}{
+		{
+			name:       "default",
+			opts:       archive.Options{},
+			wantMethod: zipper.Deflate,
+		},
+		{
+			name:       "best compression",
+			opts:       archive.Options{Level: 9},
+			wantMethod: zipper.Deflate,
+		},
+		{
+			name:       "store",
+			opts:       archive.Options{Store: true},
+			wantMethod: zipper.Store,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Zip(context.Background(), &buf, dir, tt.opts); err != nil {
+				t.Fatalf("Zip() error = %v", err)
+			}
+			f := readZip(t, buf.Bytes())["file.txt"]
+			if f.Method != tt.wantMethod {
+				t.Errorf("Zip() method = %d, want %d", f.Method, tt.wantMethod)
+			}
+			if got := readEntry(t, f); got != "content" {
+				t.Errorf("Zip() content = %q, want %q", got, "content")
+			}
+		})
+	}
+}
+
+func TestZip_reproducible(t *testing.T) {
+	dir := testTree(t)
+	epoch := time.Unix(1400000000, 0)
+	opts := archive.Options{Reproducible: true, SourceDateEpoch: epoch}
+
+	var first, second bytes.Buffer
+	if err := Zip(context.Background(), &first, dir, opts); err != nil {
+		t.Fatalf("Zip() error = %v", err)
+	}
+	if err := Zip(context.Background(), &second, dir, opts); err != nil {
+		t.Fatalf("Zip() error = %v", err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("Zip() archives differ between runs")
+	}
+	for name, f := range readZip(t, first.Bytes()) {
+		if f.Modified.After(epoch) {
+			t.Errorf("%s: Modified = %v, want at most %v", name, f.Modified, epoch)
+		}
+	}
+}
+
+func TestZip_checksums(t *testing.T) {
+	dir := testTree(t)
+	var buf bytes.Buffer
+	if err := Zip(context.Background(), &buf, dir, archive.Options{Checksums: true, Symlinks: archive.SymlinksStore}); err != nil {
+		t.Fatalf("Zip() error = %v", err)
+	}
+	f, ok := readZip(t, buf.Bytes())[archive.ChecksumsFileName]
+	if !ok {
+		t.Fatalf("Zip() has no %s entry", archive.ChecksumsFileName)
+	}
+	want := "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73  file.txt\n"
+	if got := readEntry(t, f); got != want {
+		t.Errorf("Zip() %s = %q, want %q", archive.ChecksumsFileName, got, want)
+	}
+}